@@ -0,0 +1,345 @@
+// +build linux
+
+//Traffic capture using memory-mapped AF_PACKET sockets (TPACKET_V3), without libpcap nor cgo
+package afpacket
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
+)
+
+//Capture the traffic using AF_PACKET sockets. With fanout, there is one socket per decoding gorutine. If a socket fails
+//(the interface went down, for example), it is opened again when the interface comes back and the period without
+//capture is told using gap markers.
+type CaptureContext struct {
+	device string
+	fanoutId int
+	ringsMutex sync.Mutex
+	rings []*ring
+	stop chan bool
+	wg sync.WaitGroup
+	packetsChan chan *capture.Packet
+	gapsChan chan capture.Gap
+	gapsMutex sync.Mutex
+	failedRings int
+	mac net.HardwareAddr
+	networks []*net.IPNet
+	linkType layers.LinkType
+	sampler *capture.Sampler
+	decoderOptions decoder.Options
+	statsMutex sync.Mutex
+	received uint64
+	dropped uint64
+	initialIfDropped uint64
+	logger *log.Logger
+}
+
+//Creates a capture context using AF_PACKET sockets and opens the device to capture. If fanout is greater than one,
+//that number of sockets will be opened in a fanout group and the traffic will be decoded by that number of
+//gorutines. Ensure that the process has permission to capture traffic through the device (CAP_NET_RAW).
+func New(device string, fanout int) (*CaptureContext, error) {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return nil, err
+	}
+
+	networks, err := capture.InterfaceNetworks(device)
+	if err != nil {
+		return nil, err
+	}
+
+	linkType := linkTypeOf(iface)
+	if fanout < 1 {
+		fanout = 1
+	}
+
+	fanoutId := -1
+	if fanout > 1 {
+		//The id must be different for every interface
+		fanoutId = (os.Getpid() * 31 + iface.Index) & 0xFFFF
+	}
+
+	rings := make([]*ring, 0, fanout)
+	for i := 0; i < fanout; i++ {
+		r, err := newRing(iface, fanoutId)
+		if err != nil {
+			for _, r := range rings {
+				r.close()
+			}
+			return nil, err
+		}
+		rings = append(rings, r)
+	}
+
+	return &CaptureContext{
+		device: device,
+		fanoutId: fanoutId,
+		rings: rings,
+		stop: make(chan bool),
+		packetsChan: make(chan *capture.Packet, capture.PacketsBufferSize),
+		gapsChan: make(chan capture.Gap, capture.GapsBufferSize),
+		mac: iface.HardwareAddr,
+		networks: networks,
+		linkType: linkType,
+		initialIfDropped: interfaceDrops(device),
+		logger: log.New(os.Stdout, "[AFPacketContext]: ", log.LstdFlags),
+	}, nil
+}
+
+//Ends with the capture session
+func (c *CaptureContext) Close() {
+	c.logger.Println("Closing...")
+	close(c.stop)
+	c.wg.Wait()
+	for _, r := range c.rings {
+		if r != nil {
+			r.close()
+		}
+	}
+	close(c.packetsChan)
+	close(c.gapsChan)
+}
+
+//Starts the capture session, one gorutine for each socket. Use Packets() to grab the packets channel.
+func (c *CaptureContext) StartCapturing() {
+	c.logger.Println("Starting", len(c.rings), "capture gorutine(s)")
+	c.logger.Println("Capturing", c.device, "with MAC", c.mac.String())
+	for i := range c.rings {
+		c.wg.Add(1)
+		go c.capture(i)
+	}
+}
+
+//Reads the socket at the index of the rings. Only this gorutine replaces it when it fails.
+func (c *CaptureContext) capture(i int) {
+	defer c.wg.Done()
+	d, _ := decoder.NewForLinkType(c.linkType, c.decoderOptions)
+	for {
+		select {
+		case <- c.stop:
+			c.logger.Println("Stopping capturer gorutine")
+			return
+		default:
+		}
+
+		_, err := c.rings[i].readBlock(100 * time.Millisecond, func(data []byte, ci gopacket.CaptureInfo, vlan uint16) {
+			rate, take := c.sampler.Sample(len(c.packetsChan), cap(c.packetsChan))
+			if !take {
+				return
+			}
+
+			//The ring will be reused by the kernel, so the packet must not point to it
+			frame := make([]byte, len(data))
+			copy(frame, data)
+			packet := d.Decode(frame, ci)
+			setStrippedVlan(packet, vlan)
+			packet.SamplingRate = rate
+			select {
+			case c.packetsChan <- packet:
+			case <- c.stop:
+			}
+		})
+
+		if err != nil {
+			c.logger.Println("Capture of", c.device, "failed:", err)
+			c.ringFailed(err)
+			if !c.reopen(i) {
+				c.logger.Println("Stopping capturer gorutine")
+				return
+			}
+			c.logger.Println("Capture of", c.device, "is back")
+			c.ringRecovered()
+		}
+	}
+}
+
+//Tells that the capture went down when the first socket fails. With fanout, the other sockets fail at the same time.
+func (c *CaptureContext) ringFailed(err error) {
+	c.gapsMutex.Lock()
+	defer c.gapsMutex.Unlock()
+	c.failedRings++
+	if c.failedRings == 1 {
+		capture.SendGap(c.gapsChan, capture.Gap{ Interface: c.device, Timestamp: time.Now(), Down: true, Err: err })
+	}
+}
+
+//Tells that the capture came back when the last failed socket is opened again.
+func (c *CaptureContext) ringRecovered() {
+	c.gapsMutex.Lock()
+	defer c.gapsMutex.Unlock()
+	c.failedRings--
+	if c.failedRings == 0 {
+		capture.SendGap(c.gapsChan, capture.Gap{ Interface: c.device, Timestamp: time.Now() })
+	}
+}
+
+//Closes the failed socket at the index and opens it again, waiting more time after every failed try. Returns false if
+//the capture was stopped while waiting.
+func (c *CaptureContext) reopen(i int) bool {
+	c.ringsMutex.Lock()
+	c.rings[i].close()
+	c.rings[i] = nil
+	c.ringsMutex.Unlock()
+
+	backoff := capture.MinReopenBackoff
+	for {
+		select {
+		case <- c.stop:
+			return false
+		case <- time.After(backoff):
+		}
+
+		r, err := c.open()
+		if err == nil {
+			c.ringsMutex.Lock()
+			c.rings[i] = r
+			c.ringsMutex.Unlock()
+			return true
+		}
+
+		c.logger.Println("Could not reopen", c.device + ":", err)
+		backoff *= 2
+		if backoff > capture.MaxReopenBackoff {
+			backoff = capture.MaxReopenBackoff
+		}
+	}
+}
+
+//Opens a socket of the device in the same fanout group. The interface may have been created again, so it is looked up.
+func (c *CaptureContext) open() (*ring, error) {
+	iface, err := net.InterfaceByName(c.device)
+	if err != nil {
+		return nil, err
+	}
+
+	if linkType := linkTypeOf(iface); linkType != c.linkType {
+		return nil, fmt.Errorf("the link type has changed from %s to %s", c.linkType, linkType)
+	}
+
+	return newRing(iface, c.fanoutId)
+}
+
+//Interfaces without MAC address (tunnels, PPP...) have no link layer header.
+func linkTypeOf(iface *net.Interface) layers.LinkType {
+	if len(iface.HardwareAddr) != 6 && iface.Flags & net.FlagLoopback == 0 {
+		return layers.LinkTypeRaw
+	}
+	return layers.LinkTypeEthernet
+}
+
+//Puts back the VLAN ID of the tag removed by the kernel. With QinQ, the kernel removes the outer tag and the inner one
+//is still in the frame.
+func setStrippedVlan(packet *capture.Packet, vlan uint16) {
+	if vlan == 0 {
+		return
+	}
+	if packet.VlanID != 0 {
+		packet.OuterVlanID = vlan
+	} else {
+		packet.VlanID = vlan
+	}
+}
+
+//Sets the sampler that decides which packets are decoded, shared by all the sockets. Must be called before
+//StartCapturing.
+func (c *CaptureContext) SetSampler(sampler *capture.Sampler) {
+	c.sampler = sampler
+}
+
+//Sets what the decoders of the sockets leave out of the packets. Must be called before StartCapturing.
+func (c *CaptureContext) SetDecoderOptions(options decoder.Options) {
+	c.decoderOptions = options
+}
+
+//Returns the channel where the gap markers are passed through when the capture fails and when it comes back.
+func (c *CaptureContext) Gaps() chan capture.Gap {
+	return c.gapsChan
+}
+
+//Returns the packets channel where all the packets will be passed through.
+func (c *CaptureContext) Packets() chan *capture.Packet {
+	return c.packetsChan
+}
+
+//Gets the MAC Address of the device being captured (nil if the link has no MAC addresses).
+func (c *CaptureContext) GetMAC() net.HardwareAddr {
+	if len(c.mac) == 0 {
+		return nil
+	}
+	return c.mac
+}
+
+//Gets the statistics of the sockets of the device being captured. The drops of the interface are read from sysfs. The
+//sockets that are being opened again have no statistics.
+func (c *CaptureContext) Health() ([]capture.Health, error) {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	c.ringsMutex.Lock()
+	defer c.ringsMutex.Unlock()
+	for _, r := range c.rings {
+		if r == nil {
+			continue
+		}
+		received, dropped, err := r.stats()
+		if err != nil {
+			return nil, err
+		}
+		c.received += received
+		c.dropped += dropped
+	}
+
+	ifDropped := interfaceDrops(c.device)
+	if ifDropped < c.initialIfDropped {
+		ifDropped = c.initialIfDropped
+	}
+
+	return []capture.Health{{
+		Interface: c.device,
+		Timestamp: time.Now(),
+		Received: c.received,
+		DroppedByKernel: c.dropped,
+		DroppedByInterface: ifDropped - c.initialIfDropped,
+	}}, nil
+}
+
+//Gets the packets dropped by the interface since it was brought up, or 0 if it cannot be read.
+func interfaceDrops(device string) uint64 {
+	content, err := ioutil.ReadFile("/sys/class/net/" + device + "/statistics/rx_dropped")
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	return value
+}
+
+//Gets the networks of the addresses of the device being captured.
+func (c *CaptureContext) GetNetworks() []*net.IPNet {
+	return c.networks
+}
+
+//Gets all the active network interfaces (only their names).
+func GetActiveInterfaces() ([]string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	validDevices := make([]string, 0)
+	for _, i := range interfaces {
+		if i.Flags & net.FlagUp != 0 {
+			validDevices = append(validDevices, i.Name)
+		}
+	}
+	return validDevices, nil
+}
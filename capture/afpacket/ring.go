@@ -0,0 +1,155 @@
+// +build linux
+
+package afpacket
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+	"unsafe"
+	"github.com/google/gopacket"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	blockSize = 1 << 20
+	blockNr = 64
+	frameSize = 1 << 11
+	blockTimeout = 100 //ms
+)
+
+//A memory-mapped TPACKET_V3 ring of one AF_PACKET socket. The kernel fills blocks with frames and gives the block to
+//user space, which must return it when all its frames have been read.
+type ring struct {
+	fd int
+	data []byte
+	current int
+}
+
+//Opens an AF_PACKET socket bound to the interface and maps its RX ring. If fanoutId is not negative, the socket joins
+//the fanout group with that id, so the kernel distributes the traffic between the sockets of the group.
+func newRing(iface *net.Interface, fanoutId int) (*ring, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ring{ fd: fd }
+	if err = r.setup(iface, fanoutId); err != nil {
+		r.close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *ring) setup(iface *net.Interface, fanoutId int) error {
+	err := unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3)
+	if err != nil {
+		return err
+	}
+
+	req := unix.TpacketReq3{
+		Block_size: blockSize,
+		Block_nr: blockNr,
+		Frame_size: frameSize,
+		Frame_nr: blockSize / frameSize * blockNr,
+		Retire_blk_tov: blockTimeout,
+	}
+	err = unix.SetsockoptTpacketReq3(r.fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req)
+	if err != nil {
+		return err
+	}
+
+	r.data, err = unix.Mmap(r.fd, 0, blockSize * blockNr, unix.PROT_READ | unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	err = unix.Bind(r.fd, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex: iface.Index,
+	})
+	if err != nil {
+		return err
+	}
+
+	if fanoutId >= 0 {
+		mode := unix.PACKET_FANOUT_HASH | unix.PACKET_FANOUT_FLAG_DEFRAG
+		err = unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_FANOUT, (fanoutId & 0xFFFF) | (mode << 16))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Reads the frames of the next block, calling the function for each one of them. The data is only valid inside the
+//function. The kernel removes the VLAN tag of the frames (the outer one with QinQ), so its VLAN ID is given apart, or
+//0 if the frame was not tagged. If there is no block ready after the timeout, returns false.
+func (r *ring) readBlock(timeout time.Duration, frame func(data []byte, ci gopacket.CaptureInfo, vlan uint16)) (bool, error) {
+	block := r.data[r.current * blockSize : (r.current + 1) * blockSize]
+	desc := (*unix.TpacketBlockDesc)(unsafe.Pointer(&block[0]))
+	hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&desc.Hdr[0]))
+
+	if atomic.LoadUint32(&hdr.Block_status) & unix.TP_STATUS_USER == 0 {
+		fds := []unix.PollFd{{ Fd: int32(r.fd), Events: unix.POLLIN | unix.POLLERR }}
+		_, err := unix.Poll(fds, int(timeout / time.Millisecond))
+		if err != nil && err != unix.EINTR {
+			return false, err
+		}
+		//The kernel tells the errors of the socket (like ENETDOWN when the interface goes down) with POLLERR
+		if fds[0].Revents & unix.POLLERR != 0 {
+			if errno, err := unix.GetsockoptInt(r.fd, unix.SOL_SOCKET, unix.SO_ERROR); err != nil {
+				return false, err
+			} else if errno != 0 {
+				return false, unix.Errno(errno)
+			}
+		}
+		if atomic.LoadUint32(&hdr.Block_status) & unix.TP_STATUS_USER == 0 {
+			return false, nil
+		}
+	}
+
+	offset := hdr.Offset_to_first_pkt
+	for i := uint32(0); i < hdr.Num_pkts; i++ {
+		pkt := (*unix.Tpacket3Hdr)(unsafe.Pointer(&block[offset]))
+		start := offset + uint32(pkt.Mac)
+		vlan := uint16(0)
+		if pkt.Status & unix.TP_STATUS_VLAN_VALID != 0 {
+			vlan = uint16(pkt.Hv1.Vlan_tci) & 0x0FFF
+		}
+		frame(block[start : start + pkt.Snaplen], gopacket.CaptureInfo{
+			Timestamp: time.Unix(int64(pkt.Sec), int64(pkt.Nsec)),
+			CaptureLength: int(pkt.Snaplen),
+			Length: int(pkt.Len),
+		}, vlan)
+		offset += pkt.Next_offset
+	}
+
+	atomic.StoreUint32(&hdr.Block_status, unix.TP_STATUS_KERNEL)
+	r.current = (r.current + 1) % blockNr
+	return true, nil
+}
+
+//Reads the statistics of the socket. The kernel resets them after every read, so they are the packets received and
+//dropped since the last call. The received packets include the dropped ones.
+func (r *ring) stats() (received uint64, dropped uint64, err error) {
+	stats, err := unix.GetsockoptTpacketStatsV3(r.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(stats.Packets), uint64(stats.Drops), nil
+}
+
+func (r *ring) close() {
+	if r.data != nil {
+		_ = unix.Munmap(r.data)
+	}
+	_ = unix.Close(r.fd)
+}
+
+func htons(v uint16) uint16 {
+	return (v << 8) | (v >> 8)
+}
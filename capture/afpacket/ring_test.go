@@ -0,0 +1,92 @@
+// +build linux
+
+package afpacket
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+	"github.com/google/gopacket"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
+	"golang.org/x/sys/unix"
+)
+
+//An untagged ethernet frame with an IPv4 UDP packet, as the kernel leaves the tagged frames after removing the tag
+var testFrame = []byte{
+	0x12, 0, 0, 0, 0, 2, 0x12, 0, 0, 0, 0, 1, 0x08, 0x00,
+	0x45, 0, 0, 28, 0, 0, 0, 0, 64, 17, 0, 0, 192, 168, 1, 10, 1, 1, 1, 1,
+	0x9c, 0x40, 0, 53, 0, 8, 0, 0,
+}
+
+//Builds a ring with a block ready for user space, with a frame for every status and VLAN TCI.
+func testRing(statuses []uint32, tcis []uint32) *ring {
+	r := &ring{ data: make([]byte, blockSize) }
+	desc := (*unix.TpacketBlockDesc)(unsafe.Pointer(&r.data[0]))
+	hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&desc.Hdr[0]))
+	hdr.Block_status = unix.TP_STATUS_USER
+	hdr.Num_pkts = uint32(len(statuses))
+	hdr.Offset_to_first_pkt = 64
+
+	offset := hdr.Offset_to_first_pkt
+	for i := range statuses {
+		pkt := (*unix.Tpacket3Hdr)(unsafe.Pointer(&r.data[offset]))
+		pkt.Status = statuses[i]
+		pkt.Hv1.Vlan_tci = tcis[i]
+		pkt.Mac = 64
+		pkt.Snaplen = uint32(len(testFrame))
+		pkt.Len = uint32(len(testFrame))
+		pkt.Next_offset = 256
+		copy(r.data[offset + uint32(pkt.Mac):], testFrame)
+		offset += pkt.Next_offset
+	}
+	return r
+}
+
+func TestReadBlockGivesTheVlanRemovedByTheKernel(t *testing.T) {
+	//The priority bits are not part of the VLAN ID, and the TCI is only valid with TP_STATUS_VLAN_VALID
+	r := testRing(
+		[]uint32{ unix.TP_STATUS_USER | unix.TP_STATUS_VLAN_VALID, unix.TP_STATUS_USER, unix.TP_STATUS_USER | unix.TP_STATUS_VLAN_VALID },
+		[]uint32{ 0x2000 | 100, 200, 0 },
+	)
+
+	d := decoder.New(decoder.Options{})
+	packets := make([]*capture.Packet, 0)
+	ready, err := r.readBlock(time.Millisecond, func(data []byte, ci gopacket.CaptureInfo, vlan uint16) {
+		frame := make([]byte, len(data))
+		copy(frame, data)
+		packet := d.Decode(frame, ci)
+		setStrippedVlan(packet, vlan)
+		packets = append(packets, packet)
+	})
+	if err != nil || !ready {
+		t.Fatal("The block should be read, but it was not:", ready, err)
+	}
+
+	expected := []uint16{ 100, 0, 0 }
+	if len(packets) != len(expected) {
+		t.Fatalf("There should be %d packets, but there are %d", len(expected), len(packets))
+	}
+	for i, packet := range packets {
+		if packet.VlanID != expected[i] || packet.OuterVlanID != 0 {
+			t.Errorf("The VLAN of the packet %d should be %d, but it is %d (outer %d)", i, expected[i], packet.VlanID, packet.OuterVlanID)
+		}
+		if packet.SrcIp.String() != "192.168.1.10" || packet.DstPort != 53 {
+			t.Errorf("The packet %d was not decoded: %s:%d", i, packet.SrcIp, packet.DstPort)
+		}
+	}
+
+	hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&(*unix.TpacketBlockDesc)(unsafe.Pointer(&r.data[0])).Hdr[0]))
+	if hdr.Block_status != unix.TP_STATUS_KERNEL {
+		t.Error("The block should be given back to the kernel")
+	}
+}
+
+func TestSetStrippedVlanWithQinQ(t *testing.T) {
+	//The inner tag is still in the frame, so the removed one is the outer
+	packet := &capture.Packet{ VlanID: 20 }
+	setStrippedVlan(packet, 300)
+	if packet.VlanID != 20 || packet.OuterVlanID != 300 {
+		t.Errorf("The VLANs should be 20 inside 300, but they are %d inside %d", packet.VlanID, packet.OuterVlanID)
+	}
+}
@@ -13,3 +13,25 @@ type Context interface {
 	//Ends the capture session.
 	Close()
 }
+
+//Context that replays traffic that was captured before (from a file, for example). For these contexts, the time is
+//given by the timestamps of the packets and not by the wall clock. When there are no more packets to replay, the
+//channel returned by Packets() is closed.
+type OfflineContext interface {
+	Context
+	//Returns true if the packets come from an already captured source.
+	IsOffline() bool
+}
+
+//Context that can restrict the captured traffic using a BPF filter expression (the same syntax as tcpdump).
+type FilterableContext interface {
+	Context
+	//Compiles the expression and applies it to the capture session.
+	SetFilter(expr string) error
+}
+
+//Returns true if the context replays already captured traffic.
+func IsOffline(c Context) bool {
+	offline, ok := c.(OfflineContext)
+	return ok && offline.IsOffline()
+}
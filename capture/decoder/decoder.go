@@ -0,0 +1,216 @@
+//Decoding of the captured frames into capture.Packet, shared between the capture implementations
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Minimum length of an ethernet frame (with the FCS), shorter frames are padded
+const minFrameLength = 64
+
+//What the decoders leave out of the packets. Reading the DNS responses and the server names of the TLS and QUIC
+//handshakes takes time in every packet and is only needed to know the domains (and the server names of the flows). The
+//zero value reads everything.
+type Options struct {
+	IgnoreDnsAnswers bool
+	IgnoreServerNames bool
+}
+
+//Context whose decoders can leave things out of the packets.
+type ConfigurableContext interface {
+	capture.Context
+	//Sets the options of the decoders. Must be called before StartCapturing.
+	SetDecoderOptions(options Options)
+}
+
+//Holds the state needed to decode frames. Reuses the layers between frames, so it must not be shared between
+//gorutines: every gorutine that decodes frames should have its own Decoder.
+type Decoder struct {
+	parser *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+	eth layers.Ethernet
+	sll layers.LinuxSLL
+	sll2 linuxSLL2
+	raw rawIP
+	loop layers.Loopback
+	dot1q layers.Dot1Q
+	pppoe pppoe
+	ppp ppp
+	ip4 layers.IPv4
+	ip6 layers.IPv6
+	tcp layers.TCP
+	udp layers.UDP
+	dns layers.DNS
+	options Options
+}
+
+//Creates a decoder for ethernet frames. Understands 802.1Q VLAN tags (also QinQ) and PPPoE sessions.
+func New(options Options) *Decoder {
+	d, _ := NewForLinkType(layers.LinkTypeEthernet, options)
+	return d
+}
+
+//Creates a decoder for frames of the given link type. Apart from ethernet, supports Linux cooked captures (v1), raw IP
+//(tun devices, WireGuard...), BSD loopback and PPP. Links without MAC addresses produce packets without MACs.
+func NewForLinkType(linkType layers.LinkType, options Options) (*Decoder, error) {
+	return NewForDatalink(int(linkType), options)
+}
+
+//Like NewForLinkType, but with the whole value of the link type, so the ones that do not fit in gopacket's LinkType
+//(Linux cooked captures v2) are supported too.
+func NewForDatalink(datalink int, options Options) (*Decoder, error) {
+	first, ok := firstLayerType(datalink)
+	if !ok {
+		if datalink <= 0xFF {
+			return nil, fmt.Errorf("unsupported link type %s", layers.LinkType(datalink))
+		}
+		return nil, fmt.Errorf("unsupported link type %d", datalink)
+	}
+
+	d := &Decoder{ options: options }
+	d.parser = gopacket.NewDecodingLayerParser(
+		first,
+		&d.eth, &d.sll, &d.sll2, &d.raw, &d.loop,
+		&d.dot1q, &d.pppoe, &d.ppp, &d.ip4, &d.ip6, &d.tcp, &d.udp,
+	)
+	return d, nil
+}
+
+//Decodes the frame into a capture.Packet. The returned packet can reference the data, so the data must not be modified
+//after calling this function.
+func (d *Decoder) Decode(data []byte, ci gopacket.CaptureInfo) *capture.Packet {
+	d.parser.DecodeLayers(data, &d.decoded)
+	var ppacket capture.Packet
+	ppacket.Timestamp = ci.Timestamp
+	tags := 0
+	headers := 0 //Length of the link layer headers, with the VLAN tags and PPPoE
+	ipHeader := 0
+	ipLength := 0 //Length of the IP packet as told by its header (0 if unknown)
+	l4Header := -1
+	var dnsPayload []byte //The payload of the DNS responses
+	dnsOverTcp := false
+	var tlsPayload []byte //The payload of the TLS (HTTPS) connections from the clients
+	var quicPayload []byte //The payload of the QUIC (HTTP/3) connections from the clients
+	for _, layerType := range d.decoded {
+		switch layerType {
+		case layers.LayerTypeEthernet:
+			headers += len(d.eth.Contents)
+			ppacket.SrcMac = d.eth.SrcMAC
+			ppacket.DstMac = d.eth.DstMAC
+		case layers.LayerTypeLinuxSLL:
+			headers += len(d.sll.Contents)
+			ppacket.SrcMac = linkAddress(d.sll.AddrType, d.sll.Addr)
+		case layerTypeLinuxSLL2:
+			headers += len(d.sll2.Contents)
+			ppacket.SrcMac = linkAddress(d.sll2.addrType, d.sll2.addr)
+		case layerTypeRawIP:
+			headers += len(d.raw.Contents)
+		case layers.LayerTypeLoopback:
+			headers += len(d.loop.Contents)
+		case layers.LayerTypePPPoE:
+			headers += len(d.pppoe.Contents)
+		case layers.LayerTypePPP:
+			headers += len(d.ppp.Contents)
+		case layers.LayerTypeDot1Q:
+			//The same layer is reused for every tag, so it holds the inner one. The outer is read from the frame.
+			tags++
+			headers += len(d.dot1q.Contents)
+			ppacket.VlanID = d.dot1q.VLANIdentifier
+			if tags == 2 && len(d.eth.Payload) >= 2 {
+				ppacket.OuterVlanID = binary.BigEndian.Uint16(d.eth.Payload[:2]) & 0x0FFF
+			}
+		case layers.LayerTypeIPv4:
+			ppacket.SrcIp = d.ip4.SrcIP
+			ppacket.DstIp = d.ip4.DstIP
+			ppacket.IpType = 4
+			ppacket.Protocol = uint8(d.ip4.Protocol)
+			ipHeader = len(d.ip4.Contents)
+			ipLength = int(d.ip4.Length)
+		case layers.LayerTypeIPv6:
+			ppacket.SrcIp = d.ip6.SrcIP
+			ppacket.DstIp = d.ip6.DstIP
+			ppacket.IpType = 6
+			ppacket.Protocol = uint8(d.ip6.NextHeader)
+			ipHeader = len(d.ip6.Contents)
+			if d.ip6.Length != 0 {
+				ipLength = ipHeader + int(d.ip6.Length)
+			}
+		case layers.LayerTypeTCP:
+			ppacket.SrcPort = uint16(d.tcp.SrcPort)
+			ppacket.DstPort = uint16(d.tcp.DstPort)
+			l4Header = len(d.tcp.Contents)
+			if d.tcp.SrcPort == 53 && !d.options.IgnoreDnsAnswers {
+				dnsPayload = d.tcp.Payload
+				dnsOverTcp = true
+			} else if d.tcp.DstPort == 443 && !d.options.IgnoreServerNames {
+				tlsPayload = d.tcp.Payload
+			}
+			if d.tcp.FIN {
+				ppacket.TcpFlags |= capture.TcpFin
+			}
+			if d.tcp.SYN {
+				ppacket.TcpFlags |= capture.TcpSyn
+			}
+			if d.tcp.RST {
+				ppacket.TcpFlags |= capture.TcpRst
+			}
+			if d.tcp.ACK {
+				ppacket.TcpFlags |= capture.TcpAck
+			}
+		case layers.LayerTypeUDP:
+			ppacket.SrcPort = uint16(d.udp.SrcPort)
+			ppacket.DstPort = uint16(d.udp.DstPort)
+			l4Header = len(d.udp.Contents)
+			if (d.udp.SrcPort == 53 || d.udp.SrcPort == 5353) && !d.options.IgnoreDnsAnswers {
+				dnsPayload = d.udp.Payload
+			} else if d.udp.DstPort == 443 && !d.options.IgnoreServerNames {
+				quicPayload = d.udp.Payload
+			}
+		}
+	}
+
+	//The sizes are computed from the length of the frame in the wire, so truncated captures (snaplen) and GRO/TSO
+	//super-frames are counted right
+	wire := ci.Length
+	if wire < len(data) {
+		wire = len(data)
+	}
+	l3 := wire - headers
+	//Ethernet pads the frames shorter than the minimum size, the IP header tells the real length
+	if ipLength > 0 && ipLength < l3 && wire <= minFrameLength {
+		l3 = ipLength
+	}
+	if l3 < 0 {
+		l3 = 0
+	}
+	ppacket.FrameBytes = uint64(wire)
+	ppacket.Bytes = uint64(l3)
+	if l4Header >= 0 && l3 > ipHeader + l4Header {
+		ppacket.DataBytes = uint64(l3 - ipHeader - l4Header)
+	}
+
+	if len(dnsPayload) != 0 {
+		ppacket.DnsAnswers = d.decodeDns(dnsPayload, dnsOverTcp)
+	}
+	if len(tlsPayload) != 0 {
+		ppacket.ServerName = serverNameOfTlsRecord(tlsPayload)
+	}
+	if len(quicPayload) != 0 {
+		ppacket.ServerName = serverNameOfQuicInitial(quicPayload)
+	}
+
+	return &ppacket
+}
+
+//The cooked captures only have the address of the sender, and it is only useful if it is an ethernet one.
+func linkAddress(addrType uint16, addr []byte) net.HardwareAddr {
+	if addrType != 1 || len(addr) != 6 { //ARPHRD_ETHER
+		return nil
+	}
+	return net.HardwareAddr(addr)
+}
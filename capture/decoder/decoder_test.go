@@ -0,0 +1,290 @@
+package decoder
+
+import (
+	"net"
+	"testing"
+	"time"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/melchor629/speedy/capture"
+)
+
+var srcMac = net.HardwareAddr{ 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 }
+var dstMac = net.HardwareAddr{ 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff }
+
+//Builds a frame with the given layers between ethernet and IPv4, and UDP with 10 bytes of payload over the IPv4.
+func buildFrame(t testing.TB, ethernetType layers.EthernetType, middle ...gopacket.SerializableLayer) []byte {
+	ip := &layers.IPv4{
+		Version: 4,
+		TTL: 64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IP{ 192, 168, 1, 2 },
+		DstIP: net.IP{ 1, 1, 1, 1 },
+	}
+	udp := &layers.UDP{ SrcPort: 50000, DstPort: 53 }
+	_ = udp.SetNetworkLayerForChecksum(ip)
+
+	all := []gopacket.SerializableLayer{ &layers.Ethernet{ SrcMAC: srcMac, DstMAC: dstMac, EthernetType: ethernetType } }
+	all = append(all, middle...)
+	all = append(all, ip, udp, gopacket.Payload(make([]byte, 10)))
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ FixLengths: true, ComputeChecksums: true }, all...)
+	if err != nil {
+		t.Fatal("Could not build the frame:", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeIPv4UDP(t *testing.T) {
+	data := buildFrame(t, layers.EthernetTypeIPv4)
+	ts := time.Unix(1000, 0)
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{ Timestamp: ts, CaptureLength: len(data), Length: len(data) })
+
+	if packet.SrcMac.String() != srcMac.String() || packet.DstMac.String() != dstMac.String() {
+		t.Error("MACs are not the expected ones:", packet.SrcMac, packet.DstMac)
+	}
+	if packet.IpType != 4 || !packet.SrcIp.Equal(net.IP{ 192, 168, 1, 2 }) || !packet.DstIp.Equal(net.IP{ 1, 1, 1, 1 }) {
+		t.Error("IPs are not the expected ones:", packet.IpType, packet.SrcIp, packet.DstIp)
+	}
+	if packet.SrcPort != 50000 || packet.DstPort != 53 || packet.Protocol != 17 {
+		t.Error("Ports or protocol are not the expected ones:", packet.SrcPort, packet.DstPort, packet.Protocol)
+	}
+	if !packet.Timestamp.Equal(ts) {
+		t.Error("Timestamp is not the expected one:", packet.Timestamp)
+	}
+	if packet.VlanID != 0 {
+		t.Error("VLAN should be 0, but is", packet.VlanID)
+	}
+}
+
+func TestDecodeDot1Q(t *testing.T) {
+	data := buildFrame(t, layers.EthernetTypeDot1Q, &layers.Dot1Q{ VLANIdentifier: 10, Type: layers.EthernetTypeIPv4 })
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{})
+
+	if packet.VlanID != 10 || packet.OuterVlanID != 0 {
+		t.Error("VLANs should be 10 and 0, but are", packet.VlanID, packet.OuterVlanID)
+	}
+	if packet.IpType != 4 || !packet.SrcIp.Equal(net.IP{ 192, 168, 1, 2 }) {
+		t.Error("IP information was lost:", packet.IpType, packet.SrcIp)
+	}
+}
+
+func TestDecodeQinQ(t *testing.T) {
+	data := buildFrame(t, layers.EthernetTypeQinQ,
+		&layers.Dot1Q{ VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q },
+		&layers.Dot1Q{ VLANIdentifier: 20, Type: layers.EthernetTypeIPv4 },
+	)
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{})
+
+	if packet.VlanID != 20 || packet.OuterVlanID != 100 {
+		t.Error("VLANs should be 20 and 100, but are", packet.VlanID, packet.OuterVlanID)
+	}
+	if packet.IpType != 4 || !packet.DstIp.Equal(net.IP{ 1, 1, 1, 1 }) {
+		t.Error("IP information was lost:", packet.IpType, packet.DstIp)
+	}
+}
+
+func TestDecodePPPoE(t *testing.T) {
+	data := buildFrame(t, layers.EthernetTypePPPoESession,
+		&layers.PPPoE{ Version: 1, Type: 1, Code: layers.PPPoECodeSession, SessionId: 0x1234 },
+		&layers.PPP{ PPPType: layers.PPPTypeIPv4 },
+	)
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{})
+
+	if packet.IpType != 4 || !packet.SrcIp.Equal(net.IP{ 192, 168, 1, 2 }) || !packet.DstIp.Equal(net.IP{ 1, 1, 1, 1 }) {
+		t.Error("IP information was lost:", packet.IpType, packet.SrcIp, packet.DstIp)
+	}
+	if packet.SrcPort != 50000 || packet.DstPort != 53 {
+		t.Error("Ports are not the expected ones:", packet.SrcPort, packet.DstPort)
+	}
+}
+
+func TestDecodeRawIP(t *testing.T) {
+	frame := buildFrame(t, layers.EthernetTypeIPv4)
+	data := frame[14:] //Without the ethernet header
+	d, err := NewForLinkType(layers.LinkTypeRaw, Options{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	packet := d.Decode(data, gopacket.CaptureInfo{})
+
+	if packet.SrcMac != nil || packet.DstMac != nil {
+		t.Error("There should not be MACs:", packet.SrcMac, packet.DstMac)
+	}
+	if packet.IpType != 4 || !packet.SrcIp.Equal(net.IP{ 192, 168, 1, 2 }) || !packet.DstIp.Equal(net.IP{ 1, 1, 1, 1 }) {
+		t.Error("IPs are not the expected ones:", packet.IpType, packet.SrcIp, packet.DstIp)
+	}
+	//The ethernet padding of the built frame is not part of the IP packet
+	if packet.Bytes != 38 {
+		t.Error("Bytes should be 38 but are", packet.Bytes)
+	}
+}
+
+func TestDecodeLinuxSLL(t *testing.T) {
+	frame := buildFrame(t, layers.EthernetTypeIPv4)
+	header := []byte{ 0, 4, 0, 1, 0, 6, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0, 0, 0x08, 0x00 }
+	data := append(header, frame[14:]...)
+	d, err := NewForLinkType(layers.LinkTypeLinuxSLL, Options{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	packet := d.Decode(data, gopacket.CaptureInfo{})
+
+	if packet.SrcMac.String() != srcMac.String() {
+		t.Error("SrcMac should be", srcMac, "but is", packet.SrcMac)
+	}
+	if packet.IpType != 4 || !packet.DstIp.Equal(net.IP{ 1, 1, 1, 1 }) {
+		t.Error("IP information was lost:", packet.IpType, packet.DstIp)
+	}
+}
+
+func TestDecodeLinuxSLL2(t *testing.T) {
+	frame := buildFrame(t, layers.EthernetTypeIPv4)
+	header := []byte{ 0x08, 0x00, 0, 0, 0, 0, 0, 3, 0, 1, 4, 6, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0, 0 }
+	data := append(header, frame[14:]...)
+	d, err := NewForDatalink(DatalinkLinuxSLL2, Options{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	packet := d.Decode(data, gopacket.CaptureInfo{})
+
+	if packet.SrcMac.String() != srcMac.String() {
+		t.Error("SrcMac should be", srcMac, "but is", packet.SrcMac)
+	}
+	if packet.IpType != 4 || !packet.DstIp.Equal(net.IP{ 1, 1, 1, 1 }) {
+		t.Error("IP information was lost:", packet.IpType, packet.DstIp)
+	}
+}
+
+func TestHasMacs(t *testing.T) {
+	for _, datalink := range []int{ int(layers.LinkTypeEthernet), int(layers.LinkTypeLinuxSLL), DatalinkLinuxSLL2 } {
+		if !HasMacs(datalink) {
+			t.Error("The link type", datalink, "should have MACs")
+		}
+	}
+	for _, datalink := range []int{ int(layers.LinkTypeRaw), int(layers.LinkTypeNull), int(layers.LinkTypePPP) } {
+		if HasMacs(datalink) {
+			t.Error("The link type", datalink, "should not have MACs")
+		}
+	}
+}
+
+func TestNewForLinkTypeUnsupported(t *testing.T) {
+	_, err := NewForLinkType(layers.LinkTypeIEEE802_11, Options{})
+
+	if err == nil {
+		t.Error("An error was expected")
+	}
+
+	//The low byte of the Linux cooked capture v2 is not a link type of its own
+	if _, err := NewForLinkType(layers.LinkType(DatalinkLinuxSLL2 & 0xFF), Options{}); err == nil {
+		t.Error("An error was expected for the truncated Linux SLL2 link type")
+	}
+}
+
+func TestDecodeSizesOfATruncatedCapture(t *testing.T) {
+	ip := &layers.IPv4{
+		Version: 4,
+		TTL: 64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP: net.IP{ 192, 168, 1, 2 },
+		DstIP: net.IP{ 1, 1, 1, 1 },
+	}
+	tcp := &layers.TCP{ SrcPort: 50000, DstPort: 443, ACK: true }
+	_ = tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(
+		buf,
+		gopacket.SerializeOptions{ FixLengths: true, ComputeChecksums: true },
+		&layers.Ethernet{ SrcMAC: srcMac, DstMAC: dstMac, EthernetType: layers.EthernetTypeIPv4 },
+		ip, tcp, gopacket.Payload(make([]byte, 100000)),
+	)
+	if err != nil {
+		t.Fatal("Could not build the frame:", err)
+	}
+	//A GRO super-frame bigger than 64KiB, captured with a snaplen of 96 bytes
+	length := len(buf.Bytes())
+	data := buf.Bytes()[:96]
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: length })
+
+	if packet.FrameBytes != uint64(length) {
+		t.Error("FrameBytes should be", length, "but are", packet.FrameBytes)
+	}
+	if packet.Bytes != uint64(length - 14) {
+		t.Error("Bytes should be", length - 14, "but are", packet.Bytes)
+	}
+	if packet.DataBytes != 100000 {
+		t.Error("DataBytes should be 100000 but are", packet.DataBytes)
+	}
+}
+
+func TestDecodeUDPDataBytesWithoutHeader(t *testing.T) {
+	data := buildFrame(t, layers.EthernetTypeIPv4)
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) })
+
+	if packet.DataBytes != 10 {
+		t.Error("DataBytes should be 10 but are", packet.DataBytes)
+	}
+}
+
+func TestDecodeTCPFlags(t *testing.T) {
+	ip := &layers.IPv4{ Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{ 192, 168, 1, 2 }, DstIP: net.IP{ 1, 1, 1, 1 } }
+	tcp := &layers.TCP{ SrcPort: 50000, DstPort: 443, SYN: true, FIN: true, Window: 1024 }
+	_ = tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	ethernet := &layers.Ethernet{ SrcMAC: srcMac, DstMAC: dstMac, EthernetType: layers.EthernetTypeIPv4 }
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ FixLengths: true, ComputeChecksums: true }, ethernet, ip, tcp)
+	if err != nil {
+		t.Fatal("Could not build the frame:", err)
+	}
+	data := buf.Bytes()
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) })
+
+	if packet.Protocol != 6 || packet.TcpFlags != capture.TcpSyn | capture.TcpFin {
+		t.Error("The packet should be a TCP SYN-FIN, but has protocol", packet.Protocol, "and flags", packet.TcpFlags)
+	}
+	if packet.PacketCount() != 1 {
+		t.Error("A captured packet stands for 1 packet, but stands for", packet.PacketCount())
+	}
+}
+
+//Reports how many packets per second one decoder can decode.
+func BenchmarkDecode(b *testing.B) {
+	data := buildFrame(b, layers.EthernetTypeIPv4)
+	ci := gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) }
+	d := New(Options{})
+
+	start := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Decode(data, ci)
+	}
+	b.ReportMetric(float64(b.N) / time.Since(start).Seconds(), "packets/s")
+}
+
+//Reports how many packets per second are decoded with one decoder per gorutine, as the decoding workers do.
+func BenchmarkDecodeWorkers(b *testing.B) {
+	data := buildFrame(b, layers.EthernetTypeIPv4)
+	ci := gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) }
+
+	start := time.Now()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		d := New(Options{})
+		for pb.Next() {
+			d.Decode(data, ci)
+		}
+	})
+	b.ReportMetric(float64(b.N) / time.Since(start).Seconds(), "packets/s")
+}
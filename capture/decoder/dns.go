@@ -0,0 +1,62 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Longest chain of CNAMEs followed to find the name that was asked for
+const maxCnameChain = 8
+
+//Gets the addresses of the names in a DNS response (A and AAAA answers), with the name that was asked for. Over TCP,
+//only the responses that fit in one segment are understood. Truncated or invalid responses have no answers.
+func (d *Decoder) decodeDns(payload []byte, overTcp bool) []capture.DnsAnswer {
+	if overTcp {
+		if len(payload) < 2 || len(payload) < 2 + int(binary.BigEndian.Uint16(payload)) {
+			return nil
+		}
+		payload = payload[2:2 + int(binary.BigEndian.Uint16(payload))]
+	}
+	if err := d.dns.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+		return nil
+	}
+	if !d.dns.QR || d.dns.ResponseCode != layers.DNSResponseCodeNoErr {
+		return nil
+	}
+
+	//The CNAME records point from the alias to the target, the addresses are looked up in the other way
+	aliases := make(map[string]string)
+	for _, answer := range d.dns.Answers {
+		if answer.Type == layers.DNSTypeCNAME {
+			aliases[dnsName(answer.CNAME)] = dnsName(answer.Name)
+		}
+	}
+
+	answers := make([]capture.DnsAnswer, 0)
+	for _, answer := range d.dns.Answers {
+		if answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA || answer.IP == nil {
+			continue
+		}
+
+		name := dnsName(answer.Name)
+		for i := 0; i < maxCnameChain; i++ {
+			alias, ok := aliases[name]
+			if !ok {
+				break
+			}
+			name = alias
+		}
+		ip := make(net.IP, len(answer.IP))
+		copy(ip, answer.IP)
+		answers = append(answers, capture.DnsAnswer{ Name: name, Ip: ip, Ttl: answer.TTL })
+	}
+	return answers
+}
+
+func dnsName(name []byte) string {
+	return strings.TrimSuffix(strings.ToLower(string(name)), ".")
+}
@@ -0,0 +1,89 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func dnsResponse(t *testing.T) []byte {
+	dns := &layers.DNS{
+		ID: 1,
+		QR: true,
+		RD: true,
+		RA: true,
+		Questions: []layers.DNSQuestion{ { Name: []byte("WWW.Netflix.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN } },
+		Answers: []layers.DNSResourceRecord{
+			{ Name: []byte("www.netflix.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 300, CNAME: []byte("www.geo.netflix.com") },
+			{ Name: []byte("www.geo.netflix.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 300, CNAME: []byte("edge.nflxso.net") },
+			{ Name: []byte("edge.nflxso.net"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.IP{ 1, 2, 3, 4 } },
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{ FixLengths: true }); err != nil {
+		t.Fatal("Could not build the DNS response:", err)
+	}
+	return buf.Bytes()
+}
+
+//Builds a frame from the DNS server (1.1.1.1) to the client with the given transport layer and payload.
+func dnsFrame(t *testing.T, tcp bool, payload []byte) []byte {
+	ip := &layers.IPv4{ Version: 4, TTL: 64, SrcIP: net.IP{ 1, 1, 1, 1 }, DstIP: net.IP{ 192, 168, 1, 2 } }
+	var transport gopacket.SerializableLayer
+	if tcp {
+		ip.Protocol = layers.IPProtocolTCP
+		segment := &layers.TCP{ SrcPort: 53, DstPort: 50000, ACK: true, PSH: true, Window: 1024 }
+		_ = segment.SetNetworkLayerForChecksum(ip)
+		transport = segment
+	} else {
+		ip.Protocol = layers.IPProtocolUDP
+		datagram := &layers.UDP{ SrcPort: 53, DstPort: 50000 }
+		_ = datagram.SetNetworkLayerForChecksum(ip)
+		transport = datagram
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	ethernet := &layers.Ethernet{ SrcMAC: dstMac, DstMAC: srcMac, EthernetType: layers.EthernetTypeIPv4 }
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ FixLengths: true, ComputeChecksums: true },
+		ethernet, ip, transport, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal("Could not build the frame:", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeDnsResponseFollowsTheCnames(t *testing.T) {
+	data := dnsFrame(t, false, dnsResponse(t))
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) })
+
+	if len(packet.DnsAnswers) != 1 {
+		t.Fatal("There should be one answer, but there are", packet.DnsAnswers)
+	}
+	answer := packet.DnsAnswers[0]
+	if answer.Name != "www.netflix.com" || !answer.Ip.Equal(net.IP{ 1, 2, 3, 4 }) || answer.Ttl != 60 {
+		t.Error("The answer is not the expected one:", answer)
+	}
+}
+
+func TestDecodeDnsResponseOverTcp(t *testing.T) {
+	response := dnsResponse(t)
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(len(response)))
+	data := dnsFrame(t, true, append(payload, response...))
+	decoder := New(Options{})
+
+	packet := decoder.Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) })
+	if len(packet.DnsAnswers) != 1 || packet.DnsAnswers[0].Name != "www.netflix.com" {
+		t.Error("There should be the answer of www.netflix.com, but there are", packet.DnsAnswers)
+	}
+
+	//The response does not fit in the segment
+	data = dnsFrame(t, true, payload)
+	packet = decoder.Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) })
+	if len(packet.DnsAnswers) != 0 {
+		t.Error("An incomplete response has no answers, but there are", packet.DnsAnswers)
+	}
+}
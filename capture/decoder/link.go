@@ -0,0 +1,118 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+//Link type of the Linux "cooked" capture v2 (used by libpcap for the any device in recent versions). gopacket's LinkType
+//is a byte and cannot hold it, so it can only be given to NewForDatalink.
+const DatalinkLinuxSLL2 = 276
+
+//Layers not available in gopacket (or not usable with a DecodingLayerParser).
+var (
+	layerTypeLinuxSLL2 = gopacket.RegisterLayerType(6290, gopacket.LayerTypeMetadata{ Name: "LinuxSLL2" })
+	layerTypeRawIP = gopacket.RegisterLayerType(6291, gopacket.LayerTypeMetadata{ Name: "RawIP" })
+)
+
+//Linux "cooked" capture v2 header. Like LinuxSLL, but with the protocol at the beginning and the interface index.
+type linuxSLL2 struct {
+	layers.BaseLayer
+	ethernetType layers.EthernetType
+	addrType uint16
+	packetType layers.LinuxSLLPacketType
+	addr []byte
+}
+
+func (l *linuxSLL2) LayerType() gopacket.LayerType { return layerTypeLinuxSLL2 }
+
+func (l *linuxSLL2) CanDecode() gopacket.LayerClass { return layerTypeLinuxSLL2 }
+
+func (l *linuxSLL2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 20 {
+		df.SetTruncated()
+		return errors.New("Linux SLL2 header too short")
+	}
+
+	l.ethernetType = layers.EthernetType(binary.BigEndian.Uint16(data[0:2]))
+	l.addrType = binary.BigEndian.Uint16(data[8:10])
+	l.packetType = layers.LinuxSLLPacketType(data[10])
+	addrLen := int(data[11])
+	if addrLen > 8 {
+		addrLen = 8
+	}
+	l.addr = data[12:12 + addrLen]
+	l.BaseLayer = layers.BaseLayer{ Contents: data[:20], Payload: data[20:] }
+	return nil
+}
+
+func (l *linuxSLL2) NextLayerType() gopacket.LayerType {
+	return l.ethernetType.LayerType()
+}
+
+//Frames of links without a link layer (tun devices, WireGuard, raw IP captures). The next layer is IPv4 or IPv6
+//depending on the version of the IP header.
+type rawIP struct {
+	layers.BaseLayer
+	version uint8
+}
+
+func (r *rawIP) LayerType() gopacket.LayerType { return layerTypeRawIP }
+
+func (r *rawIP) CanDecode() gopacket.LayerClass { return layerTypeRawIP }
+
+func (r *rawIP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 1 {
+		df.SetTruncated()
+		return errors.New("raw IP packet too short")
+	}
+
+	r.version = data[0] >> 4
+	r.BaseLayer = layers.BaseLayer{ Contents: data[:0], Payload: data }
+	return nil
+}
+
+func (r *rawIP) NextLayerType() gopacket.LayerType {
+	switch r.version {
+	case 4:
+		return layers.LayerTypeIPv4
+	case 6:
+		return layers.LayerTypeIPv6
+	default:
+		return gopacket.LayerTypeZero
+	}
+}
+
+//Returns true if the frames of the link type have MAC addresses (at least the source one).
+func HasMacs(datalink int) bool {
+	switch datalink {
+	case int(layers.LinkTypeEthernet), int(layers.LinkTypeLinuxSLL), DatalinkLinuxSLL2:
+		return true
+	default:
+		return false
+	}
+}
+
+//Gets the first layer to decode for the link type (the whole value, as stored in the capture files), or false if the
+//link type is not supported.
+func firstLayerType(datalink int) (gopacket.LayerType, bool) {
+	switch datalink {
+	case int(layers.LinkTypeEthernet):
+		return layers.LayerTypeEthernet, true
+	case int(layers.LinkTypeLinuxSLL):
+		return layers.LayerTypeLinuxSLL, true
+	case DatalinkLinuxSLL2:
+		return layerTypeLinuxSLL2, true
+	case int(layers.LinkTypeRaw), int(layers.LinkTypeIPv4), int(layers.LinkTypeIPv6), 12, 14:
+		//12 and 14 are used for raw IP by some BSDs
+		return layerTypeRawIP, true
+	case int(layers.LinkTypeNull), int(layers.LinkTypeLoop):
+		return layers.LayerTypeLoopback, true
+	case int(layers.LinkTypePPP):
+		return layers.LayerTypePPP, true
+	default:
+		return gopacket.LayerTypeZero, false
+	}
+}
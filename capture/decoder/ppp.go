@@ -0,0 +1,96 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+//gopacket's PPPoE and PPP layers cannot be used with a DecodingLayerParser, so these are minimal versions that can.
+
+//PPPoE header (RFC 2516). Only the session stage carries PPP frames.
+type pppoe struct {
+	layers.BaseLayer
+	code layers.PPPoECode
+	sessionId uint16
+}
+
+func (p *pppoe) LayerType() gopacket.LayerType { return layers.LayerTypePPPoE }
+
+func (p *pppoe) CanDecode() gopacket.LayerClass { return layers.LayerTypePPPoE }
+
+func (p *pppoe) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 6 {
+		df.SetTruncated()
+		return errors.New("PPPoE header too short")
+	}
+
+	p.code = layers.PPPoECode(data[1])
+	p.sessionId = binary.BigEndian.Uint16(data[2:4])
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if length > len(data) - 6 {
+		length = len(data) - 6
+	}
+	p.BaseLayer = layers.BaseLayer{ Contents: data[:6], Payload: data[6:6 + length] }
+	return nil
+}
+
+func (p *pppoe) NextLayerType() gopacket.LayerType {
+	if p.code != layers.PPPoECodeSession {
+		return gopacket.LayerTypeZero
+	}
+	return layers.LayerTypePPP
+}
+
+//PPP header, which only has the protocol of the payload (RFC 1661). The protocol field can be compressed into one byte.
+type ppp struct {
+	layers.BaseLayer
+	pppType layers.PPPType
+}
+
+func (p *ppp) LayerType() gopacket.LayerType { return layers.LayerTypePPP }
+
+func (p *ppp) CanDecode() gopacket.LayerClass { return layers.LayerTypePPP }
+
+func (p *ppp) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 1 {
+		df.SetTruncated()
+		return errors.New("PPP header too short")
+	}
+
+	//Address and control fields are only present in some captures (HDLC-like framing)
+	offset := 0
+	if len(data) >= 2 && data[0] == 0xff && data[1] == 0x03 {
+		offset = 2
+	}
+
+	headerLength := offset + 1
+	if len(data) < headerLength {
+		df.SetTruncated()
+		return errors.New("PPP header too short")
+	}
+	p.pppType = layers.PPPType(data[offset])
+	if data[offset] & 0x1 == 0 {
+		if len(data) < offset + 2 {
+			df.SetTruncated()
+			return errors.New("PPP header too short")
+		}
+		headerLength = offset + 2
+		p.pppType = layers.PPPType(binary.BigEndian.Uint16(data[offset:offset + 2]))
+	}
+
+	p.BaseLayer = layers.BaseLayer{ Contents: data[:headerLength], Payload: data[headerLength:] }
+	return nil
+}
+
+func (p *ppp) NextLayerType() gopacket.LayerType {
+	switch p.pppType {
+	case layers.PPPTypeIPv4:
+		return layers.LayerTypeIPv4
+	case layers.PPPTypeIPv6:
+		return layers.LayerTypeIPv6
+	default:
+		return gopacket.LayerTypeZero
+	}
+}
@@ -0,0 +1,218 @@
+package decoder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+//The keys of the QUIC Initial packets are derived from the destination connection ID with a salt (and labels) published
+//for every version, so anyone can decrypt them (they are only protected against modifications).
+type quicVersion struct {
+	initialType uint8 //Type of the Initial packets in the long header
+	salt []byte
+	keyLabel string
+	ivLabel string
+	hpLabel string
+}
+
+var quicVersions = map[uint32]quicVersion{
+	//RFC 9001
+	0x00000001: {
+		initialType: 0,
+		salt: []byte{ 0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a },
+		keyLabel: "quic key",
+		ivLabel: "quic iv",
+		hpLabel: "quic hp",
+	},
+	//RFC 9369
+	0x6b3343cf: {
+		initialType: 1,
+		salt: []byte{ 0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9 },
+		keyLabel: "quicv2 key",
+		ivLabel: "quicv2 iv",
+		hpLabel: "quicv2 hp",
+	},
+}
+
+//The keys of the client Initial packets.
+type quicKeys struct {
+	key []byte
+	iv []byte
+	hp []byte
+}
+
+func quicClientKeys(version quicVersion, dcid []byte) quicKeys {
+	initialSecret := hkdfExtract(version.salt, dcid)
+	clientSecret := hkdfExpandLabel(initialSecret, "client in", sha256.Size)
+	return quicKeys{
+		key: hkdfExpandLabel(clientSecret, version.keyLabel, 16),
+		iv: hkdfExpandLabel(clientSecret, version.ivLabel, 12),
+		hp: hkdfExpandLabel(clientSecret, version.hpLabel, 16),
+	}
+}
+
+//Gets the server name (SNI) of the ClientHello of a QUIC Initial packet sent by a client, decrypting it. Only the first
+//packet of the datagram is read, and the name must be in it (ClientHellos split in several packets usually have the
+//name in the first one, but not always).
+func serverNameOfQuicInitial(datagram []byte) string {
+	//Long header with the fixed bit
+	if len(datagram) < 7 || datagram[0] & 0xc0 != 0xc0 {
+		return ""
+	}
+	version, ok := quicVersions[binary.BigEndian.Uint32(datagram[1:5])]
+	if !ok || (datagram[0] >> 4) & 0x03 != version.initialType {
+		return ""
+	}
+
+	r := byteReader{ datagram[5:] }
+	dcid := r.bytes(int(r.uint8()))
+	r.skip(int(r.uint8())) //Source connection ID
+	//The lengths are compared before converting them, as they may not fit in an int
+	tokenLength, ok := r.varint()
+	if !ok || dcid == nil || tokenLength > uint64(len(r.data)) {
+		return ""
+	}
+	r.skip(int(tokenLength))
+	length, ok := r.varint()
+	if !ok || length > uint64(len(r.data)) {
+		return ""
+	}
+	pnOffset := len(datagram) - len(r.data)
+	packet := make([]byte, pnOffset + int(length))
+	copy(packet, datagram)
+
+	payload := decryptQuicInitial(quicClientKeys(version, dcid), packet, pnOffset)
+	if payload == nil {
+		return ""
+	}
+	return serverNameOfClientHello(quicCryptoData(payload))
+}
+
+//Removes the header protection and decrypts the payload of the packet (modifying it). Returns nil if it cannot be
+//decrypted (it was not a client Initial packet).
+func decryptQuicInitial(keys quicKeys, packet []byte, pnOffset int) []byte {
+	//The sample is taken as if the packet number had 4 bytes
+	if len(packet) < pnOffset + 4 + aes.BlockSize {
+		return nil
+	}
+	hp, err := aes.NewCipher(keys.hp)
+	if err != nil {
+		return nil
+	}
+	mask := make([]byte, aes.BlockSize)
+	hp.Encrypt(mask, packet[pnOffset + 4:pnOffset + 4 + aes.BlockSize])
+	packet[0] ^= mask[0] & 0x0f
+	pnLength := int(packet[0] & 0x03) + 1
+	pn := uint64(0)
+	for i := 0; i < pnLength; i++ {
+		packet[pnOffset + i] ^= mask[1 + i]
+		pn = pn << 8 | uint64(packet[pnOffset + i])
+	}
+
+	block, err := aes.NewCipher(keys.key)
+	if err != nil {
+		return nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil
+	}
+	nonce := make([]byte, len(keys.iv))
+	copy(nonce, keys.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce) - 1 - i] ^= byte(pn >> (8 * i))
+	}
+	header := packet[:pnOffset + pnLength]
+	payload, err := aead.Open(nil, nonce, packet[pnOffset + pnLength:], header)
+	if err != nil {
+		return nil
+	}
+	return payload
+}
+
+//Gets the data of the CRYPTO frames of the payload, from the beginning up to the first hole. The frames can be in any
+//order. Stops at the first frame that is not CRYPTO, PADDING nor PING.
+func quicCryptoData(payload []byte) []byte {
+	type cryptoFrame struct {
+		offset uint64
+		data []byte
+	}
+	frames := make([]cryptoFrame, 0)
+	r := byteReader{ payload }
+	for len(r.data) > 0 {
+		frameType, _ := r.varint()
+		if frameType == 0x00 || frameType == 0x01 { //PADDING, PING
+			continue
+		}
+		if frameType != 0x06 {
+			break
+		}
+		offset, okOffset := r.varint()
+		length, okLength := r.varint()
+		if !okOffset || !okLength || length > uint64(len(r.data)) {
+			break
+		}
+		frames = append(frames, cryptoFrame{ offset, r.bytes(int(length)) })
+	}
+
+	data := make([]byte, 0)
+	for joined := true; joined; {
+		joined = false
+		for _, frame := range frames {
+			end := frame.offset + uint64(len(frame.data))
+			if frame.offset <= uint64(len(data)) && end > uint64(len(data)) {
+				data = append(data, frame.data[uint64(len(data)) - frame.offset:]...)
+				joined = true
+			}
+		}
+	}
+	return data
+}
+
+//Reads a variable length integer of QUIC (RFC 9000, section 16).
+func (r *byteReader) varint() (uint64, bool) {
+	if len(r.data) == 0 {
+		return 0, false
+	}
+	length := 1 << (r.data[0] >> 6)
+	value := r.bytes(length)
+	if value == nil {
+		return 0, false
+	}
+	result := uint64(value[0] & 0x3f)
+	for _, b := range value[1:] {
+		result = result << 8 | uint64(b)
+	}
+	return result, true
+}
+
+//HKDF (RFC 5869) with SHA-256, as used by TLS 1.3 (RFC 8446, section 7.1).
+func hkdfExtract(salt []byte, secret []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	fullLabel := "tls13 " + label
+	info := make([]byte, 2, 4 + len(fullLabel))
+	binary.BigEndian.PutUint16(info, uint16(length))
+	info = append(info, byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, 0) //No context
+
+	output := make([]byte, 0, length + sha256.Size)
+	previous := []byte{}
+	for i := byte(1); len(output) < length; i++ {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(previous)
+		mac.Write(info)
+		mac.Write([]byte{ i })
+		previous = mac.Sum(nil)
+		output = append(output, previous...)
+	}
+	return output[:length]
+}
@@ -0,0 +1,102 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	tlsRecordHandshake = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsExtensionServerName = 0x0000
+)
+
+//Gets the server name (SNI) of a TLS ClientHello sent over TCP. The record must start at the beginning of the segment
+//and the name must be in it, so the ClientHellos split in several segments have no name.
+func serverNameOfTlsRecord(payload []byte) string {
+	if len(payload) < 5 || payload[0] != tlsRecordHandshake {
+		return ""
+	}
+	return serverNameOfClientHello(payload[5:])
+}
+
+//Gets the server name (SNI) of a ClientHello handshake message (without the TLS record header, as it is in the QUIC
+//CRYPTO frames). If the message is incomplete, the name is searched in the part that is there.
+func serverNameOfClientHello(message []byte) string {
+	if len(message) < 4 || message[0] != tlsHandshakeClientHello {
+		return ""
+	}
+
+	r := byteReader{ message[4:] }
+	//Version and random
+	r.skip(2 + 32)
+	//Session ID, cipher suites and compression methods
+	r.skip(int(r.uint8()))
+	r.skip(int(r.uint16()))
+	r.skip(int(r.uint8()))
+	extensionsLength := int(r.uint16())
+	//If the extensions do not fit, the ones that are there are read
+	extensions := byteReader{ r.data }
+	if extensionsLength < len(r.data) {
+		extensions.data = r.data[:extensionsLength]
+	}
+
+	for len(extensions.data) >= 4 {
+		extensionType := extensions.uint16()
+		extension := extensions.bytes(int(extensions.uint16()))
+		if extensionType != tlsExtensionServerName {
+			continue
+		}
+
+		names := byteReader{ extension }
+		names = byteReader{ names.bytes(int(names.uint16())) }
+		for len(names.data) >= 3 {
+			nameType := names.uint8()
+			name := names.bytes(int(names.uint16()))
+			if nameType == 0 && len(name) != 0 { //host_name
+				return strings.TrimSuffix(strings.ToLower(string(name)), ".")
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+//Reads big endian values from the data. When there is not enough data (or the length is negative), it reads zeros (and
+//nil slices) and the data is left empty.
+type byteReader struct {
+	data []byte
+}
+
+func (r *byteReader) skip(n int) {
+	if n < 0 || n > len(r.data) {
+		n = len(r.data)
+	}
+	r.data = r.data[n:]
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data) {
+		r.data = r.data[len(r.data):]
+		return nil
+	}
+	value := r.data[:n]
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *byteReader) uint8() uint8 {
+	value := r.bytes(1)
+	if value == nil {
+		return 0
+	}
+	return value[0]
+}
+
+func (r *byteReader) uint16() uint16 {
+	value := r.bytes(2)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(value)
+}
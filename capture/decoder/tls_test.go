@@ -0,0 +1,218 @@
+package decoder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"testing"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func uint16Bytes(value int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(value))
+	return b
+}
+
+//Builds a ClientHello handshake message with the SNI and other extensions around it.
+func clientHello(serverName string) []byte {
+	sni := uint16Bytes(len(serverName) + 3)
+	sni = append(sni, 0)
+	sni = append(sni, uint16Bytes(len(serverName))...)
+	sni = append(sni, serverName...)
+
+	extensions := []byte{ 0x00, 0x17, 0x00, 0x00 } //extended_master_secret
+	extensions = append(extensions, uint16Bytes(tlsExtensionServerName)...)
+	extensions = append(extensions, uint16Bytes(len(sni))...)
+	extensions = append(extensions, sni...)
+	extensions = append(extensions, 0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04) //supported_versions
+
+	body := []byte{ 0x03, 0x03 }
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 32)
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0x00, 0x02, 0x13, 0x01)
+	body = append(body, 0x01, 0x00)
+	body = append(body, uint16Bytes(len(extensions))...)
+	body = append(body, extensions...)
+
+	message := []byte{ tlsHandshakeClientHello, 0, byte(len(body) >> 8), byte(len(body)) }
+	return append(message, body...)
+}
+
+func TestServerNameOfTlsRecord(t *testing.T) {
+	message := clientHello("WWW.Example.com")
+	record := []byte{ tlsRecordHandshake, 0x03, 0x01 }
+	record = append(record, uint16Bytes(len(message))...)
+	record = append(record, message...)
+
+	if name := serverNameOfTlsRecord(record); name != "www.example.com" {
+		t.Error("The name should be www.example.com, but is", name)
+	}
+	//The rest of the ClientHello is in the next segment
+	if name := serverNameOfTlsRecord(record[:len(record) - 7]); name != "www.example.com" {
+		t.Error("The name is in the segment, it should be www.example.com, but is", name)
+	}
+	if name := serverNameOfTlsRecord(record[:100]); name != "" {
+		t.Error("The name is not in the segment, but it is", name)
+	}
+	if name := serverNameOfTlsRecord([]byte{ 0x17, 0x03, 0x03, 0x00, 0x10 }); name != "" {
+		t.Error("Application data has no name, but it is", name)
+	}
+}
+
+func TestQuicClientKeysOfTheRFCs(t *testing.T) {
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	expected := map[uint32][3]string{
+		0x00000001: { "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2" },
+		0x6b3343cf: { "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f", "45b95e15235d6f45a6b19cbcb0294ba9" },
+	}
+
+	for version, values := range expected {
+		keys := quicClientKeys(quicVersions[version], dcid)
+		got := [3]string{ hex.EncodeToString(keys.key), hex.EncodeToString(keys.iv), hex.EncodeToString(keys.hp) }
+		if got != values {
+			t.Errorf("The keys of the version %#x are not the ones of its RFC: %v", version, got)
+		}
+	}
+}
+
+//Builds a client Initial packet of the version with the ClientHello split in two CRYPTO frames (in reverse order, as
+//some browsers do), encrypted and with the header protection.
+func quicInitial(t *testing.T, versionNumber uint32, hello []byte) []byte {
+	version := quicVersions[versionNumber]
+	dcid := []byte{ 1, 2, 3, 4, 5, 6, 7, 8 }
+	half := len(hello) / 2
+	payload := []byte{ 0x06, 0x40 | byte(half >> 8), byte(half) }
+	payload = append(payload, 0x40 | byte((len(hello) - half) >> 8), byte(len(hello) - half))
+	payload = append(payload, hello[half:]...)
+	payload = append(payload, 0x01, 0x06, 0x00)
+	payload = append(payload, 0x40 | byte(half >> 8), byte(half))
+	payload = append(payload, hello[:half]...)
+	payload = append(payload, make([]byte, 1100 - len(payload))...)
+
+	pnLength := 2
+	length := pnLength + len(payload) + 16
+	header := []byte{ 0xc0 | version.initialType << 4 | byte(pnLength - 1), 0, 0, 0, 0 }
+	binary.BigEndian.PutUint32(header[1:5], versionNumber)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 0, 0) //No source connection ID nor token
+	header = append(header, 0x40 | byte(length >> 8), byte(length))
+	pnOffset := len(header)
+	header = append(header, 0, 7) //Packet number 7
+
+	keys := quicClientKeys(version, dcid)
+	block, _ := aes.NewCipher(keys.key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, len(keys.iv))
+	copy(nonce, keys.iv)
+	nonce[len(nonce) - 1] ^= 7
+	packet := aead.Seal(append([]byte{}, header...), nonce, payload, header)
+
+	hp, _ := aes.NewCipher(keys.hp)
+	mask := make([]byte, aes.BlockSize)
+	hp.Encrypt(mask, packet[pnOffset + 4:pnOffset + 4 + aes.BlockSize])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < pnLength; i++ {
+		packet[pnOffset + i] ^= mask[1 + i]
+	}
+	if len(packet) != pnOffset + length {
+		t.Fatal("The packet was not built right")
+	}
+	return packet
+}
+
+func TestServerNameOfQuicInitial(t *testing.T) {
+	for _, version := range []uint32{ 0x00000001, 0x6b3343cf } {
+		packet := quicInitial(t, version, clientHello("www.youtube.com"))
+
+		if name := serverNameOfQuicInitial(packet); name != "www.youtube.com" {
+			t.Errorf("The name of the version %#x should be www.youtube.com, but is %q", version, name)
+		}
+		packet[len(packet) - 1] ^= 1
+		if name := serverNameOfQuicInitial(packet); name != "" {
+			t.Errorf("A modified packet of the version %#x cannot be decrypted, but the name is %q", version, name)
+		}
+	}
+
+	if name := serverNameOfQuicInitial([]byte{ 0x40, 1, 2, 3, 4, 5, 6, 7, 8 }); name != "" {
+		t.Error("A short header packet has no name, but it is", name)
+	}
+}
+
+func TestServerNamesWithOversizedLengths(t *testing.T) {
+	//The largest QUIC varint, which does not fit in an int (and is negative in one of 32 bits)
+	huge := []byte{ 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff }
+	hello := clientHello("www.example.com")
+	//After the header, the version, the random, the session ID, the cipher suites, the compression methods, the length of
+	//the extensions and the first extension, there is the server name one
+	oversizedServerName := append([]byte{}, hello...)
+	oversizedServerName[4 + 2 + 32 + 33 + 4 + 2 + 2 + 4 + 2] = 0xff
+	quicHeader := []byte{ 0xc0, 0, 0, 0, 1, 8, 1, 2, 3, 4, 5, 6, 7, 8, 0 }
+
+	tests := []struct {
+		name string
+		parse func([]byte) string
+		data []byte
+	}{
+		{ "ClientHello with oversized server name extension", serverNameOfClientHello, oversizedServerName },
+		{ "ClientHello with oversized session ID", serverNameOfClientHello, append([]byte{ 1, 0, 0, 0xff }, make([]byte, 34)...) },
+		{ "QUIC with oversized token", serverNameOfQuicInitial, append(append(append([]byte{}, quicHeader...), huge...), make([]byte, 40)...) },
+		{ "QUIC with oversized length", serverNameOfQuicInitial, append(append(append([]byte{}, quicHeader...), 0), huge...) },
+		{ "QUIC with oversized connection ID", serverNameOfQuicInitial, []byte{ 0xc0, 0, 0, 0, 1, 0xff, 1, 2 } },
+		{ "CRYPTO frame with oversized length", func(payload []byte) string {
+			return serverNameOfClientHello(quicCryptoData(payload))
+		}, append(append([]byte{ 0x06, 0x00 }, huge...), hello...) },
+		{ "CRYPTO frame with oversized offset", func(payload []byte) string {
+			return serverNameOfClientHello(quicCryptoData(payload))
+		}, append(append(append([]byte{ 0x06 }, huge...), 0x40 | byte(len(hello) >> 8), byte(len(hello))), hello...) },
+	}
+	for _, test := range tests {
+		if name := test.parse(test.data); name != "" {
+			t.Errorf("%s: there should be no name, but it is %q", test.name, name)
+		}
+	}
+
+	r := byteReader{ []byte{ 1, 2, 3 } }
+	if r.bytes(-1) != nil || len(r.data) != 0 {
+		t.Error("A negative length should read nothing and leave the data empty")
+	}
+	r = byteReader{ []byte{ 1, 2, 3 } }
+	if r.skip(-1); len(r.data) != 0 {
+		t.Error("A negative skip should leave the data empty")
+	}
+}
+
+func TestDecodeTlsClientHello(t *testing.T) {
+	message := clientHello("www.example.com")
+	record := []byte{ tlsRecordHandshake, 0x03, 0x01 }
+	record = append(record, uint16Bytes(len(message))...)
+	record = append(record, message...)
+	ip := &layers.IPv4{ Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{ 192, 168, 1, 2 }, DstIP: net.IP{ 1, 1, 1, 1 } }
+	tcp := &layers.TCP{ SrcPort: 50000, DstPort: 443, ACK: true, PSH: true, Window: 1024 }
+	_ = tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	ethernet := &layers.Ethernet{ SrcMAC: srcMac, DstMAC: dstMac, EthernetType: layers.EthernetTypeIPv4 }
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ FixLengths: true, ComputeChecksums: true },
+		ethernet, ip, tcp, gopacket.Payload(record))
+	if err != nil {
+		t.Fatal("Could not build the frame:", err)
+	}
+	data := buf.Bytes()
+
+	packet := New(Options{}).Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) })
+
+	if packet.ServerName != "www.example.com" {
+		t.Error("The server name should be www.example.com, but is", packet.ServerName)
+	}
+
+	//Without domains nor flows, the handshakes are not read
+	packet = New(Options{ IgnoreServerNames: true }).Decode(data, gopacket.CaptureInfo{ CaptureLength: len(data), Length: len(data) })
+	if packet.ServerName != "" {
+		t.Error("The server names should not be read, but it is", packet.ServerName)
+	}
+}
@@ -0,0 +1,96 @@
+//Capture context that collects the flows exported by routers and switches (sFlow v5, NetFlow v5/v9 and IPFIX) instead
+//of sniffing the traffic
+package flow
+
+import (
+	"log"
+	"net"
+	"os"
+	"time"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
+)
+
+//Receives the flow datagrams through UDP and turns every flow record or packet sample into a capture.Packet. As the
+//records have no MAC of the capture device, the direction of the traffic must be known using the local networks.
+type CollectorContext struct {
+	addr string
+	conn *net.UDPConn
+	done chan bool
+	packetsChan chan *capture.Packet
+	parser *parser
+	logger *log.Logger
+}
+
+//Creates a collector that listens on the given UDP address (":2055", for example).
+func New(addr string) (*CollectorContext, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CollectorContext{
+		addr,
+		conn,
+		make(chan bool),
+		make(chan *capture.Packet, capture.PacketsBufferSize),
+		newParser(decoder.Options{}),
+		log.New(os.Stdout, "[FlowCollector]: ", log.LstdFlags),
+	}, nil
+}
+
+//Stops listening for datagrams.
+func (c *CollectorContext) Close() {
+	c.logger.Println("Closing...")
+	_ = c.conn.Close()
+	<- c.done
+}
+
+//Starts receiving datagrams. Use Packets() to grab the packets channel.
+func (c *CollectorContext) StartCapturing() {
+	c.logger.Println("Starting collector gorutine")
+	c.logger.Println("Listening for sFlow, NetFlow and IPFIX on", c.addr)
+	buffer := make([]byte, 65535)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buffer)
+		if err != nil {
+			c.logger.Println("Stopping collector gorutine")
+			break
+		}
+
+		//The packets can reference the datagram, so it cannot be reused
+		datagram := make([]byte, n)
+		copy(datagram, buffer[:n])
+		packets, err := c.parser.parse(datagram, from.IP.String(), time.Now())
+		if err != nil {
+			c.logger.Println("Invalid datagram from", from, ":", err)
+		}
+
+		for _, packet := range packets {
+			c.packetsChan <- packet
+		}
+	}
+
+	close(c.packetsChan)
+	close(c.done)
+}
+
+//Sets what the decoders of the sampled packets (sFlow) leave out of the packets. Must be called before StartCapturing.
+func (c *CollectorContext) SetDecoderOptions(options decoder.Options) {
+	c.parser = newParser(options)
+}
+
+//Returns the packets channel where all the flows will be passed through.
+func (c *CollectorContext) Packets() chan *capture.Packet {
+	return c.packetsChan
+}
+
+//There is no capture device, so there is no MAC.
+func (c *CollectorContext) GetMAC() net.HardwareAddr {
+	return nil
+}
@@ -0,0 +1,370 @@
+package flow
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
+)
+
+//Builds big endian data from a list of values: uint8, uint16, uint32, net.IP or []byte.
+func build(values ...interface{}) []byte {
+	data := make([]byte, 0)
+	for _, value := range values {
+		switch v := value.(type) {
+		case uint8:
+			data = append(data, v)
+		case uint16:
+			data = append(data, 0, 0)
+			binary.BigEndian.PutUint16(data[len(data) - 2:], v)
+		case uint32:
+			data = append(data, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(data[len(data) - 4:], v)
+		case net.IP:
+			data = append(data, v...)
+		case []byte:
+			data = append(data, v...)
+		}
+	}
+	return data
+}
+
+func netFlow5Datagram() []byte {
+	header := build(uint16(5), uint16(1), uint32(0), uint32(0), uint32(0), uint32(0), uint8(0), uint8(0), uint16(10))
+	record := build(
+		net.IP{ 192, 168, 1, 10 }.To4(), net.IP{ 1, 1, 1, 1 }.To4(), net.IP{ 0, 0, 0, 0 }.To4(),
+		uint16(1), uint16(2), uint32(3), uint32(1500), uint32(0), uint32(0),
+		uint16(50000), uint16(443), uint8(0), uint8(0), uint8(6), uint8(0),
+		uint16(0), uint16(0), uint8(0), uint8(0), uint16(0),
+	)
+	return append(header, record...)
+}
+
+func TestParseNetFlow5(t *testing.T) {
+	packets, err := newParser(decoder.Options{}).parse(netFlow5Datagram(), "10.0.0.1", time.Now())
+
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(packets) != 1 {
+		t.Fatal("There should be one packet, but there are", len(packets))
+	}
+
+	p := packets[0]
+	if !p.SrcIp.Equal(net.IP{ 192, 168, 1, 10 }) || !p.DstIp.Equal(net.IP{ 1, 1, 1, 1 }) || p.IpType != 4 || p.Protocol != 6 {
+		t.Error("IPs or protocol are not the expected ones:", p.SrcIp, p.DstIp, p.IpType, p.Protocol)
+	}
+	if p.Bytes != 1500 || p.SrcPort != 50000 || p.DstPort != 443 || p.SamplingRate != 10 {
+		t.Error("Bytes, ports or sampling rate are not the expected ones:", p.Bytes, p.SrcPort, p.DstPort, p.SamplingRate)
+	}
+	if p.Packets != 3 || p.PacketCount() != 3 {
+		t.Error("The record stands for 3 packets, but has", p.Packets)
+	}
+}
+
+func netFlow9Template() []byte {
+	//Template 256: source IPv4, destination IPv4, bytes (4), source port, destination port
+	template := build(
+		uint16(256), uint16(5),
+		uint16(fieldSourceIPv4Address), uint16(4),
+		uint16(fieldDestinationIPv4Address), uint16(4),
+		uint16(fieldOctetDeltaCount), uint16(4),
+		uint16(fieldSourceTransportPort), uint16(2),
+		uint16(fieldDestinationTransportPort), uint16(2),
+	)
+	return append(build(uint16(0), uint16(4 + len(template))), template...)
+}
+
+func netFlow9Data(bytes uint32) []byte {
+	record := build(net.IP{ 1, 1, 1, 1 }.To4(), net.IP{ 192, 168, 1, 10 }.To4(), bytes, uint16(443), uint16(50000))
+	//Padded to 4 bytes
+	record = append(record, 0, 0, 0)
+	return append(build(uint16(256), uint16(4 + len(record))), record...)
+}
+
+func TestParseNetFlow9WithTemplateInTheSameDatagram(t *testing.T) {
+	datagram := build(uint16(9), uint16(2), uint32(0), uint32(0), uint32(0), uint32(7))
+	datagram = append(datagram, netFlow9Template()...)
+	datagram = append(datagram, netFlow9Data(9000)...)
+
+	packets, err := newParser(decoder.Options{}).parse(datagram, "10.0.0.1", time.Now())
+
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(packets) != 1 {
+		t.Fatal("There should be one packet, but there are", len(packets))
+	}
+	if packets[0].Bytes != 9000 || !packets[0].DstIp.Equal(net.IP{ 192, 168, 1, 10 }) || packets[0].SrcPort != 443 {
+		t.Error("The packet is not the expected one:", packets[0])
+	}
+}
+
+func TestParseNetFlow9DataWithoutTemplateIsSkipped(t *testing.T) {
+	datagram := build(uint16(9), uint16(1), uint32(0), uint32(0), uint32(0), uint32(7))
+	datagram = append(datagram, netFlow9Data(9000)...)
+
+	packets, err := newParser(decoder.Options{}).parse(datagram, "10.0.0.1", time.Now())
+
+	if err != nil || len(packets) != 0 {
+		t.Error("There should be no packets nor errors:", packets, err)
+	}
+}
+
+func ipfixMessage(domain uint32, sets ...[]byte) []byte {
+	body := make([]byte, 0)
+	for _, set := range sets {
+		body = append(body, set...)
+	}
+	return append(build(uint16(10), uint16(16 + len(body)), uint32(0), uint32(0), domain), body...)
+}
+
+func TestParseIPFIXTemplatesAreKeptBetweenMessages(t *testing.T) {
+	//Template 300: enterprise field (4 bytes), source IPv6, destination IPv6, variable length field, bytes (8)
+	template := build(
+		uint16(300), uint16(5),
+		uint16(0x8000 | 100), uint16(4), uint32(12345),
+		uint16(fieldSourceIPv6Address), uint16(16),
+		uint16(fieldDestinationIPv6Address), uint16(16),
+		uint16(82), uint16(variableLength),
+		uint16(fieldOctetTotalCount), uint16(8),
+	)
+	templateSet := append(build(uint16(2), uint16(4 + len(template))), template...)
+	record := build(
+		uint32(0),
+		net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"),
+		uint8(4), []byte("eth0"),
+		uint32(0), uint32(70000),
+	)
+	dataSet := append(build(uint16(300), uint16(4 + len(record))), record...)
+	p := newParser(decoder.Options{})
+
+	_, err := p.parse(ipfixMessage(1, templateSet), "10.0.0.1", time.Now())
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	packets, err := p.parse(ipfixMessage(1, dataSet), "10.0.0.1", time.Now())
+
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(packets) != 1 {
+		t.Fatal("There should be one packet, but there are", len(packets))
+	}
+	if packets[0].Bytes != 70000 || packets[0].IpType != 6 || !packets[0].SrcIp.Equal(net.ParseIP("2001:db8::1")) {
+		t.Error("The packet is not the expected one:", packets[0])
+	}
+
+	//Other observation domains have their own templates
+	packets, _ = p.parse(ipfixMessage(2, dataSet), "10.0.0.1", time.Now())
+	if len(packets) != 0 {
+		t.Error("There should be no packets, but there are", len(packets))
+	}
+}
+
+func TestParseNetFlow9SamplingIntervalFromOptions(t *testing.T) {
+	//Options template 260: scope system (4), sampling interval (4)
+	options := build(uint16(260), uint16(4), uint16(4), uint16(1), uint16(4), uint16(fieldSamplingInterval), uint16(4))
+	optionsSet := append(build(uint16(1), uint16(4 + len(options) + 2)), append(options, 0, 0)...)
+	optionsRecord := build(net.IP{ 10, 0, 0, 1 }.To4(), uint32(64))
+	optionsData := append(build(uint16(260), uint16(4 + len(optionsRecord))), optionsRecord...)
+	datagram := build(uint16(9), uint16(3), uint32(0), uint32(0), uint32(0), uint32(7))
+	datagram = append(datagram, netFlow9Template()...)
+	datagram = append(datagram, optionsSet...)
+	datagram = append(datagram, optionsData...)
+	datagram = append(datagram, netFlow9Data(9000)...)
+	p := newParser(decoder.Options{})
+
+	packets, err := p.parse(datagram, "10.0.0.1", time.Now())
+
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(packets) != 1 || packets[0].SamplingRate != 64 {
+		t.Fatal("There should be one packet with a sampling rate of 64:", packets)
+	}
+
+	//The interval is kept for the next datagrams of the exporter and source ID, but not for the others
+	datagram = build(uint16(9), uint16(1), uint32(0), uint32(0), uint32(0), uint32(7))
+	datagram = append(datagram, netFlow9Data(9000)...)
+	packets, _ = p.parse(datagram, "10.0.0.1", time.Now())
+	if len(packets) != 1 || packets[0].SamplingRate != 64 {
+		t.Error("The sampling rate should still be 64:", packets)
+	}
+	p.parse(append(build(uint16(9), uint16(1), uint32(0), uint32(0), uint32(0), uint32(8)), netFlow9Template()...), "10.0.0.1", time.Now())
+	datagram = build(uint16(9), uint16(1), uint32(0), uint32(0), uint32(0), uint32(8))
+	datagram = append(datagram, netFlow9Data(9000)...)
+	packets, _ = p.parse(datagram, "10.0.0.1", time.Now())
+	if len(packets) != 1 || packets[0].SamplingRate != 0 {
+		t.Error("Other source IDs should have no sampling rate:", packets)
+	}
+}
+
+//Replays the datagrams captured from an exporter, as the collector would receive them
+func replay(t *testing.T, file string) [][]*capture.Packet {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal("Could not open the capture:", err)
+	}
+	defer f.Close()
+	reader, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal("Could not read the capture:", err)
+	}
+
+	p := newParser(decoder.Options{})
+	datagrams := make([][]*capture.Packet, 0)
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			return datagrams
+		} else if err != nil {
+			t.Fatal("Could not read the capture:", err)
+		}
+
+		packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		ip, _ := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		udp, _ := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if ip == nil || udp == nil {
+			t.Fatal("The capture has a packet that is not an UDP datagram")
+		}
+
+		packets, err := p.parse(udp.Payload, ip.SrcIP.String(), ci.Timestamp)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		datagrams = append(datagrams, packets)
+	}
+}
+
+func TestReplayNetFlow9Export(t *testing.T) {
+	datagrams := replay(t, "testdata/netflow9.pcap")
+
+	if len(datagrams) != 2 || len(datagrams[0]) != 2 || len(datagrams[1]) != 3 {
+		t.Fatal("There should be 2 and 3 flows in the datagrams:", datagrams)
+	}
+	//The first flows come before the options with the sampling interval
+	if datagrams[0][1].Bytes != 24310 || datagrams[0][1].Packets != 18 || datagrams[0][1].SamplingRate != 0 {
+		t.Error("The flow is not the expected one:", datagrams[0][1])
+	}
+	for _, p := range datagrams[1] {
+		if p.SamplingRate != 100 {
+			t.Error("The sampling rate should be 100, but is", p.SamplingRate)
+		}
+	}
+	p := datagrams[1][0]
+	if !p.SrcIp.Equal(net.IP{ 10, 20, 1, 32 }) || p.DstPort != 53 || p.Protocol != 17 || p.Bytes != 71 {
+		t.Error("The flow is not the expected one:", p)
+	}
+}
+
+func TestReplayIPFIXExport(t *testing.T) {
+	datagrams := replay(t, "testdata/ipfix.pcap")
+
+	if len(datagrams) != 2 || len(datagrams[0]) != 0 || len(datagrams[1]) != 3 {
+		t.Fatal("There should be 0 and 3 flows in the messages:", datagrams)
+	}
+	for _, p := range datagrams[1] {
+		if p.SamplingRate != 1000 {
+			t.Error("The sampling rate should be 1000, but is", p.SamplingRate)
+		}
+	}
+	if p := datagrams[1][1]; p.Bytes != 13400 || p.Packets != 10 || p.VlanID != 20 || p.SrcPort != 443 {
+		t.Error("The flow is not the expected one:", p)
+	}
+	if p := datagrams[1][2]; p.IpType != 6 || !p.DstIp.Equal(net.ParseIP("2606:4700::6810:85e5")) || p.Bytes != 2960 {
+		t.Error("The flow is not the expected one:", p)
+	}
+}
+
+func sFlowDatagram(t *testing.T) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	ip := &layers.IPv4{ Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{ 192, 168, 1, 10 }, DstIP: net.IP{ 8, 8, 8, 8 } }
+	udp := &layers.UDP{ SrcPort: 50000, DstPort: 53 }
+	_ = udp.SetNetworkLayerForChecksum(ip)
+	err := gopacket.SerializeLayers(
+		buf,
+		gopacket.SerializeOptions{ FixLengths: true },
+		&layers.Ethernet{
+			SrcMAC: net.HardwareAddr{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 },
+			DstMAC: net.HardwareAddr{ 0x00, 0x00, 0x5e, 0x00, 0x01, 0x01 },
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ip, udp, gopacket.Payload(make([]byte, 1000)),
+	)
+	if err != nil {
+		t.Fatal("Could not build the frame:", err)
+	}
+	//Only the first 64 bytes are sampled, the frame had the 4 bytes of the FCS
+	header := buf.Bytes()[:64]
+	frameLength := uint32(len(buf.Bytes()) + 4)
+
+	rawHeader := build(uint32(sFlowHeaderEthernet), frameLength, uint32(4), uint32(len(header)), header)
+	record := append(build(uint32(sFlowRawPacketHeader), uint32(len(rawHeader))), rawHeader...)
+	counterSample := build(uint32(2), uint32(4), uint32(0))
+	flowSample := build(
+		uint32(0), uint32(0), //Sequence and source ID
+		uint32(256), uint32(0), uint32(0), uint32(0), uint32(0), //Rate, pool, drops, input, output
+		uint32(1),
+	)
+	flowSample = append(flowSample, record...)
+	datagram := build(uint32(5), uint32(1), net.IP{ 10, 0, 0, 1 }.To4(), uint32(0), uint32(0), uint32(0), uint32(2))
+	datagram = append(datagram, counterSample...)
+	datagram = append(datagram, build(uint32(sFlowFlowSample), uint32(len(flowSample)))...)
+	return append(datagram, flowSample...)
+}
+
+func TestParseSFlowRawPacketHeader(t *testing.T) {
+	packets, err := newParser(decoder.Options{}).parse(sFlowDatagram(t), "10.0.0.1", time.Now())
+
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(packets) != 1 {
+		t.Fatal("There should be one packet, but there are", len(packets))
+	}
+
+	p := packets[0]
+	if p.SrcMac.String() != "12:22:33:44:55:66" || !p.DstIp.Equal(net.IP{ 8, 8, 8, 8 }) {
+		t.Error("MAC or IP are not the expected ones:", p.SrcMac, p.DstIp)
+	}
+	if p.Bytes != 1028 || p.DataBytes != 1000 || p.SamplingRate != 256 {
+		t.Error("Sizes or sampling rate are not the expected ones:", p.Bytes, p.DataBytes, p.SamplingRate)
+	}
+}
+
+func TestCollectorReceivesDatagrams(t *testing.T) {
+	c, err := New("127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	go c.StartCapturing()
+
+	conn, err := net.DialUDP("udp", nil, c.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal("Could not connect:", err)
+	}
+	_, _ = conn.Write(netFlow5Datagram())
+	_ = conn.Close()
+
+	select {
+	case packet := <- c.Packets():
+		if packet.Bytes != 1500 {
+			t.Error("Bytes should be 1500, but are", packet.Bytes)
+		}
+	case <- time.After(time.Second):
+		t.Error("No packet was received")
+	}
+
+	c.Close()
+	if _, ok := <- c.Packets(); ok {
+		t.Error("The packets channel should be closed")
+	}
+}
@@ -0,0 +1,50 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+const (
+	netFlow5HeaderLength = 24
+	netFlow5RecordLength = 48
+)
+
+//Decodes a NetFlow v5 datagram: a header followed by fixed size IPv4 flow records.
+func (p *parser) parseNetFlow5(datagram []byte, now time.Time) ([]*capture.Packet, error) {
+	if len(datagram) < netFlow5HeaderLength {
+		return nil, errors.New("NetFlow v5 header too short")
+	}
+
+	count := int(binary.BigEndian.Uint16(datagram[2:4]))
+	//The two upper bits are the sampling mode, the rest the interval
+	samplingRate := uint32(binary.BigEndian.Uint16(datagram[22:24]) & 0x3FFF)
+	packets := make([]*capture.Packet, 0, count)
+	for i := 0; i < count; i++ {
+		offset := netFlow5HeaderLength + i * netFlow5RecordLength
+		if offset + netFlow5RecordLength > len(datagram) {
+			return packets, errors.New("NetFlow v5 record too short")
+		}
+
+		record := datagram[offset : offset + netFlow5RecordLength]
+		bytes := uint64(binary.BigEndian.Uint32(record[20:24]))
+		packets = append(packets, &capture.Packet{
+			FrameBytes: bytes,
+			Bytes: bytes,
+			Packets: uint64(binary.BigEndian.Uint32(record[16:20])),
+			SrcIp: net.IP(record[0:4]),
+			DstIp: net.IP(record[4:8]),
+			IpType: 4,
+			Protocol: record[38],
+			SrcPort: binary.BigEndian.Uint16(record[32:34]),
+			DstPort: binary.BigEndian.Uint16(record[34:36]),
+			Timestamp: now,
+			SamplingRate: samplingRate,
+		})
+	}
+
+	return packets, nil
+}
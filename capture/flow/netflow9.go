@@ -0,0 +1,90 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+const (
+	netFlow9HeaderLength = 20
+	ipfixHeaderLength = 16
+)
+
+//Decodes a NetFlow v9 datagram: a header followed by flowsets. The templates are stored for the next datagrams, the
+//data records whose template is not known yet are skipped.
+func (p *parser) parseNetFlow9(datagram []byte, exporter string, now time.Time) ([]*capture.Packet, error) {
+	if len(datagram) < netFlow9HeaderLength {
+		return nil, errors.New("NetFlow v9 header too short")
+	}
+
+	sourceId := binary.BigEndian.Uint32(datagram[16:20])
+	return p.parseSets(datagram[netFlow9HeaderLength:], exporter, sourceId, 0, now)
+}
+
+//Decodes an IPFIX message: a header followed by sets. Works the same way as NetFlow v9, with other set IDs.
+func (p *parser) parseIPFIX(datagram []byte, exporter string, now time.Time) ([]*capture.Packet, error) {
+	if len(datagram) < ipfixHeaderLength {
+		return nil, errors.New("IPFIX header too short")
+	}
+
+	length := int(binary.BigEndian.Uint16(datagram[2:4]))
+	if length < ipfixHeaderLength || length > len(datagram) {
+		return nil, errors.New("invalid IPFIX message length")
+	}
+
+	domain := binary.BigEndian.Uint32(datagram[12:16])
+	return p.parseSets(datagram[ipfixHeaderLength:length], exporter, domain, 2, now)
+}
+
+//The sets of NetFlow v9 and IPFIX only differ in the IDs of the templates: 0 in v9 and 2 in IPFIX, and the next one
+//for the options templates. Of the options, only the sampling interval is kept.
+func (p *parser) parseSets(sets []byte, exporter string, domain uint32, templateSetId uint16, now time.Time) ([]*capture.Packet, error) {
+	packets := make([]*capture.Packet, 0)
+	for len(sets) >= 4 {
+		id := binary.BigEndian.Uint16(sets[0:2])
+		length := int(binary.BigEndian.Uint16(sets[2:4]))
+		if length < 4 || length > len(sets) {
+			return packets, errors.New("invalid set length")
+		}
+
+		set := sets[4:length]
+		sets = sets[length:]
+		switch {
+		case id == templateSetId:
+			if err := p.parseTemplates(set, exporter, domain, templateSetId == 2); err != nil {
+				return packets, err
+			}
+		case id == templateSetId + 1:
+			if err := p.parseOptionsTemplates(set, exporter, domain, templateSetId == 2); err != nil {
+				return packets, err
+			}
+		case id >= 256:
+			key := templateKey{ exporter, domain, id }
+			if fields, ok := p.optionsTemplates[key]; ok {
+				samplingRate, err := parseOptionsDataSet(set, fields)
+				if samplingRate != 0 {
+					p.samplingRates[exporterKey{ exporter, domain }] = samplingRate
+				}
+				if err != nil {
+					return packets, err
+				}
+				continue
+			}
+
+			fields, ok := p.templates[key]
+			if !ok {
+				continue
+			}
+
+			setPackets, err := p.parseDataSet(set, fields, p.samplingRates[exporterKey{ exporter, domain }], now)
+			packets = append(packets, setPackets...)
+			if err != nil {
+				return packets, err
+			}
+		}
+	}
+
+	return packets, nil
+}
@@ -0,0 +1,65 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"github.com/google/gopacket/layers"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
+)
+
+//Decodes the datagrams of the supported protocols. Keeps the templates and sampling intervals of NetFlow v9 and IPFIX,
+//so it must not be shared between gorutines.
+type parser struct {
+	templates map[templateKey][]templateField
+	optionsTemplates map[templateKey][]templateField
+	samplingRates map[exporterKey]uint32
+	ethernet *decoder.Decoder
+	raw *decoder.Decoder
+}
+
+func newParser(options decoder.Options) *parser {
+	raw, _ := decoder.NewForLinkType(layers.LinkTypeRaw, options)
+	return &parser{
+		templates: make(map[templateKey][]templateField),
+		optionsTemplates: make(map[templateKey][]templateField),
+		samplingRates: make(map[exporterKey]uint32),
+		ethernet: decoder.New(options),
+		raw: raw,
+	}
+}
+
+//Decodes the datagram sent by the exporter, guessing the protocol from the version in the header. When the datagram is
+//not valid, returns the packets decoded until the error.
+func (p *parser) parse(datagram []byte, exporter string, now time.Time) ([]*capture.Packet, error) {
+	if len(datagram) < 4 {
+		return nil, errors.New("datagram too short")
+	}
+
+	//sFlow has a 32 bit version, the rest a 16 bit one
+	if binary.BigEndian.Uint32(datagram) == 5 {
+		return p.parseSFlow(datagram, now)
+	}
+
+	switch version := binary.BigEndian.Uint16(datagram); version {
+	case 5:
+		return p.parseNetFlow5(datagram, now)
+	case 9:
+		return p.parseNetFlow9(datagram, exporter, now)
+	case 10:
+		return p.parseIPFIX(datagram, exporter, now)
+	default:
+		return nil, fmt.Errorf("unknown flow protocol version %d", version)
+	}
+}
+
+//Reads a big endian unsigned integer of up to 8 bytes (the flow protocols can send integers with less bytes).
+func readUint(b []byte) uint64 {
+	value := uint64(0)
+	for _, x := range b {
+		value = value << 8 | uint64(x)
+	}
+	return value
+}
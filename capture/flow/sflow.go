@@ -0,0 +1,131 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+	"github.com/google/gopacket"
+	"github.com/melchor629/speedy/capture"
+)
+
+const (
+	sFlowFlowSample = 1
+	sFlowExpandedFlowSample = 3
+	sFlowRawPacketHeader = 1
+	sFlowHeaderEthernet = 1
+	sFlowHeaderIPv4 = 11
+	sFlowHeaderIPv6 = 12
+)
+
+//Decodes a sFlow v5 datagram. Only the raw packet headers of the flow samples are used: they are decoded as if they
+//were captured, and they represent as many packets as the sampling rate tells. The counter samples are ignored.
+func (p *parser) parseSFlow(datagram []byte, now time.Time) ([]*capture.Packet, error) {
+	r := reader{ datagram, nil }
+	r.uint32() //Version
+	switch r.uint32() { //Agent address type
+	case 1:
+		r.bytes(4)
+	case 2:
+		r.bytes(16)
+	default:
+		return nil, errors.New("invalid sFlow agent address type")
+	}
+	r.bytes(12) //Sub agent ID, sequence and uptime
+
+	packets := make([]*capture.Packet, 0)
+	samples := r.uint32()
+	for i := uint32(0); i < samples && r.err == nil; i++ {
+		format := r.uint32() //The enterprise is in the upper bits, the standard formats have 0
+		sample := reader{ r.bytes(int(r.uint32())), nil }
+		switch format {
+		case sFlowFlowSample:
+			sample.bytes(8) //Sequence and source ID
+		case sFlowExpandedFlowSample:
+			sample.bytes(12) //Sequence, source ID type and index
+		default:
+			continue
+		}
+
+		samplingRate := sample.uint32()
+		sample.bytes(12) //Sample pool, drops and input
+		if format == sFlowExpandedFlowSample {
+			sample.bytes(12) //Input value, output format and value
+		} else {
+			sample.bytes(4) //Output
+		}
+
+		records := sample.uint32()
+		for j := uint32(0); j < records && sample.err == nil; j++ {
+			recordFormat := sample.uint32()
+			record := reader{ sample.bytes(int(sample.uint32())), nil }
+			if recordFormat != sFlowRawPacketHeader {
+				continue
+			}
+
+			packet := p.parseRawPacketHeader(&record, now)
+			if record.err != nil {
+				return packets, record.err
+			}
+			if packet != nil {
+				packet.SamplingRate = samplingRate
+				packets = append(packets, packet)
+			}
+		}
+
+		if sample.err != nil {
+			return packets, sample.err
+		}
+	}
+
+	return packets, r.err
+}
+
+//Decodes the header of a sampled packet. The length of the frame is the original one, without the stripped bytes.
+func (p *parser) parseRawPacketHeader(record *reader, now time.Time) *capture.Packet {
+	protocol := record.uint32()
+	frameLength := int(record.uint32())
+	stripped := int(record.uint32())
+	header := record.bytes(int(record.uint32()))
+	if record.err != nil {
+		return nil
+	}
+
+	ci := gopacket.CaptureInfo{ Timestamp: now, CaptureLength: len(header), Length: frameLength - stripped }
+	switch protocol {
+	case sFlowHeaderEthernet:
+		return p.ethernet.Decode(header, ci)
+	case sFlowHeaderIPv4, sFlowHeaderIPv6:
+		return p.raw.Decode(header, ci)
+	default:
+		return nil
+	}
+}
+
+//Reads the XDR encoded data of sFlow. After the first error, it returns zeros and the error is kept.
+type reader struct {
+	data []byte
+	err error
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+//Reads the next bytes. The opaque data is padded to 4 bytes, so the padding is skipped too.
+func (r *reader) bytes(length int) []byte {
+	padded := (length + 3) &^ 3
+	if r.err != nil || length < 0 || padded > len(r.data) {
+		if r.err == nil {
+			r.err = errors.New("sFlow data too short")
+		}
+		return nil
+	}
+
+	b := r.data[:length]
+	r.data = r.data[padded:]
+	return b
+}
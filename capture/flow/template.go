@@ -0,0 +1,269 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Information elements (the same numbers in NetFlow v9 and IPFIX) used to build the packets
+const (
+	fieldOctetDeltaCount = 1
+	fieldPacketDeltaCount = 2
+	fieldProtocolIdentifier = 4
+	fieldSourceTransportPort = 7
+	fieldSourceIPv4Address = 8
+	fieldDestinationTransportPort = 11
+	fieldDestinationIPv4Address = 12
+	fieldSourceIPv6Address = 27
+	fieldDestinationIPv6Address = 28
+	fieldSamplingInterval = 34
+	fieldSamplerRandomInterval = 50
+	fieldSourceMacAddress = 56
+	fieldVlanId = 58
+	fieldDestinationMacAddress = 80
+	fieldOctetTotalCount = 85
+	fieldPacketTotalCount = 86
+	fieldSamplingPacketInterval = 305
+
+	//Length of the fields whose length is in the data record (only IPFIX)
+	variableLength = 65535
+)
+
+//Templates are scoped to the exporter and its source ID (v9) or observation domain (IPFIX).
+type templateKey struct {
+	exporter string
+	domain uint32
+	id uint16
+}
+
+type templateField struct {
+	id uint16
+	length uint16
+	enterprise bool
+	//The scope fields of the options templates say what the options are about, their IDs can be of another namespace
+	scope bool
+}
+
+//Exporters send the sampling interval in options records, for all the flows of its source ID or observation domain
+type exporterKey struct {
+	exporter string
+	domain uint32
+}
+
+//Reads the templates of a template set (or flowset) and stores them. In IPFIX, the fields can be enterprise specific.
+func (p *parser) parseTemplates(set []byte, exporter string, domain uint32, ipfix bool) error {
+	for len(set) >= 4 {
+		id := binary.BigEndian.Uint16(set[0:2])
+		count := int(binary.BigEndian.Uint16(set[2:4]))
+		set = set[4:]
+		if id < 256 {
+			//Padding at the end of the set
+			return nil
+		}
+
+		fields, rest, err := readTemplateFields(set, count, ipfix)
+		if err != nil {
+			return err
+		}
+		set = rest
+
+		key := templateKey{ exporter, domain, id }
+		p.templates[key] = fields
+		delete(p.optionsTemplates, key)
+	}
+
+	return nil
+}
+
+//Reads the options templates of an options template set. In NetFlow v9 the header has the length in bytes of the scope
+//and option fields, in IPFIX the count of all the fields and of the scope ones (that come first).
+func (p *parser) parseOptionsTemplates(set []byte, exporter string, domain uint32, ipfix bool) error {
+	for len(set) >= 6 {
+		id := binary.BigEndian.Uint16(set[0:2])
+		scopeCount := 0
+		count := 0
+		if ipfix {
+			count = int(binary.BigEndian.Uint16(set[2:4]))
+			scopeCount = int(binary.BigEndian.Uint16(set[4:6]))
+		} else {
+			scopeCount = int(binary.BigEndian.Uint16(set[2:4])) / 4
+			count = scopeCount + int(binary.BigEndian.Uint16(set[4:6])) / 4
+		}
+		set = set[6:]
+		if id < 256 {
+			//Padding at the end of the set
+			return nil
+		}
+		if scopeCount > count {
+			return errors.New("invalid options template")
+		}
+
+		fields, rest, err := readTemplateFields(set, count, ipfix)
+		if err != nil {
+			return err
+		}
+		set = rest
+		for i := 0; i < scopeCount; i++ {
+			fields[i].scope = true
+		}
+
+		key := templateKey{ exporter, domain, id }
+		p.optionsTemplates[key] = fields
+		delete(p.templates, key)
+	}
+
+	return nil
+}
+
+func readTemplateFields(set []byte, count int, ipfix bool) ([]templateField, []byte, error) {
+	fields := make([]templateField, 0, count)
+	for i := 0; i < count; i++ {
+		if len(set) < 4 {
+			return nil, set, errors.New("template too short")
+		}
+
+		field := templateField{ id: binary.BigEndian.Uint16(set[0:2]), length: binary.BigEndian.Uint16(set[2:4]) }
+		set = set[4:]
+		if ipfix && field.id & 0x8000 != 0 {
+			if len(set) < 4 {
+				return nil, set, errors.New("template too short")
+			}
+			field.id &= 0x7FFF
+			field.enterprise = true
+			set = set[4:]
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, set, nil
+}
+
+//Decodes the records of a data set using its template. Every record is a flow, turned into a packet with all its bytes.
+//The records without a sampling field take the sampling rate of the exporter.
+func (p *parser) parseDataSet(set []byte, fields []templateField, samplingRate uint32, now time.Time) ([]*capture.Packet, error) {
+	packets := make([]*capture.Packet, 0)
+	err := readRecords(set, fields, func(values [][]byte) {
+		packet := &capture.Packet{ Timestamp: now }
+		for i, field := range fields {
+			if !field.enterprise {
+				setField(packet, field.id, values[i])
+			}
+		}
+		if packet.SamplingRate == 0 {
+			packet.SamplingRate = samplingRate
+		}
+
+		//Records without bytes are not traffic (or are not understood)
+		if packet.Bytes != 0 {
+			packet.FrameBytes = packet.Bytes
+			packets = append(packets, packet)
+		}
+	})
+
+	return packets, err
+}
+
+//Decodes the records of an options data set and returns the sampling interval they have, or 0 if there is none.
+func parseOptionsDataSet(set []byte, fields []templateField) (uint32, error) {
+	samplingRate := uint32(0)
+	err := readRecords(set, fields, func(values [][]byte) {
+		for i, field := range fields {
+			if field.scope || field.enterprise {
+				continue
+			}
+
+			switch field.id {
+			case fieldSamplingInterval, fieldSamplerRandomInterval, fieldSamplingPacketInterval:
+				if rate := uint32(readUint(values[i])); rate != 0 {
+					samplingRate = rate
+				}
+			}
+		}
+	})
+
+	return samplingRate, err
+}
+
+//Splits the records of a data set into the values of their fields, calling record with the values of each one. The
+//slice of values is reused between records.
+func readRecords(set []byte, fields []templateField, record func(values [][]byte)) error {
+	minLength := 0
+	for _, field := range fields {
+		if field.length != variableLength {
+			minLength += int(field.length)
+		}
+	}
+	if minLength == 0 {
+		return errors.New("empty template")
+	}
+
+	values := make([][]byte, len(fields))
+	//The rest of the set (shorter than a record) is padding
+	for len(set) >= minLength {
+		for i, field := range fields {
+			length := int(field.length)
+			if field.length == variableLength {
+				if len(set) < 1 {
+					return errors.New("data record too short")
+				}
+				length = int(set[0])
+				set = set[1:]
+				if length == 255 {
+					if len(set) < 2 {
+						return errors.New("data record too short")
+					}
+					length = int(binary.BigEndian.Uint16(set[0:2]))
+					set = set[2:]
+				}
+			}
+			if len(set) < length {
+				return errors.New("data record too short")
+			}
+
+			values[i] = set[:length]
+			set = set[length:]
+		}
+		record(values)
+	}
+
+	return nil
+}
+
+func setField(packet *capture.Packet, id uint16, value []byte) {
+	switch id {
+	case fieldOctetDeltaCount, fieldOctetTotalCount:
+		packet.Bytes = readUint(value)
+	case fieldPacketDeltaCount, fieldPacketTotalCount:
+		packet.Packets = readUint(value)
+	case fieldProtocolIdentifier:
+		packet.Protocol = uint8(readUint(value))
+	case fieldSourceTransportPort:
+		packet.SrcPort = uint16(readUint(value))
+	case fieldDestinationTransportPort:
+		packet.DstPort = uint16(readUint(value))
+	case fieldSourceIPv4Address, fieldSourceIPv6Address:
+		packet.SrcIp = net.IP(value)
+		packet.IpType = ipType(value)
+	case fieldDestinationIPv4Address, fieldDestinationIPv6Address:
+		packet.DstIp = net.IP(value)
+		packet.IpType = ipType(value)
+	case fieldSamplingInterval, fieldSamplingPacketInterval:
+		packet.SamplingRate = uint32(readUint(value))
+	case fieldSourceMacAddress:
+		packet.SrcMac = net.HardwareAddr(value)
+	case fieldDestinationMacAddress:
+		packet.DstMac = net.HardwareAddr(value)
+	case fieldVlanId:
+		packet.VlanID = uint16(readUint(value)) & 0x0FFF
+	}
+}
+
+func ipType(ip []byte) uint8 {
+	if len(ip) == net.IPv6len {
+		return 6
+	}
+	return 4
+}
@@ -0,0 +1,47 @@
+package capture
+
+import "time"
+
+//Size of the buffer of the gap markers channels. The markers are not sent when it is full (see SendGap).
+const GapsBufferSize = 16
+
+const (
+	//Waits between the tries to reopen a failed capture, doubled after every failed try
+	MinReopenBackoff = 1 * time.Second
+	MaxReopenBackoff = 1 * time.Minute
+)
+
+//Marks the beginning or the end of a period in which an interface could not be captured (the interface went down, the
+//capture handle failed...). During that period there is no data, which is not the same as no traffic.
+type Gap struct {
+	Interface string //Name of the interface
+	Timestamp time.Time //When the capture went down or came back
+	Down bool //True when the capture went down, false when it came back
+	Err error //Why the capture went down (nil when it came back)
+}
+
+//Context that recovers from the errors of the capture, telling when the capture goes down and when it comes back.
+type GapContext interface {
+	Context
+	//Gets the channel of the gap markers. It is closed when the context is closed.
+	Gaps() chan Gap
+}
+
+//Gets the channel of the gap markers of the context. If the context cannot tell them, returns nil (receiving from it
+//blocks forever).
+func Gaps(c Context) chan Gap {
+	if gapContext, ok := c.(GapContext); ok {
+		return gapContext.Gaps()
+	}
+	return nil
+}
+
+//Sends the marker without blocking the capture. If nobody is reading the markers, it is dropped.
+func SendGap(gaps chan Gap, gap Gap) bool {
+	select {
+	case gaps <- gap:
+		return true
+	default:
+		return false
+	}
+}
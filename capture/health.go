@@ -0,0 +1,29 @@
+package capture
+
+import "time"
+
+//Statistics of a capture session, to know if the numbers are reliable. When the machine cannot keep up with the
+//traffic, the packets are dropped before they reach the utility and the usage is under-reported. The counters are
+//accumulated since the capture session started.
+type Health struct {
+	Interface string //Name of the captured interface
+	Timestamp time.Time //When the statistics were taken
+	Received uint64 //Packets received by the capture session
+	DroppedByKernel uint64 //Packets dropped because the capture buffer was full
+	DroppedByInterface uint64 //Packets dropped by the network interface or its driver
+}
+
+//Context that can tell the statistics of the capture session.
+type HealthContext interface {
+	Context
+	//Gets the statistics of every captured interface.
+	Health() ([]Health, error)
+}
+
+//Gets the statistics of the capture session. If the context cannot tell them, returns nil.
+func GetHealth(c Context) ([]Health, error) {
+	if healthContext, ok := c.(HealthContext); ok {
+		return healthContext.Health()
+	}
+	return nil, nil
+}
@@ -0,0 +1,288 @@
+//Captures the interfaces that match some patterns while they are up, starting and stopping their capture as they
+//appear and disappear (USB adapters, bridges created late in the boot...)
+package hotplug
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Creates the capture context of an interface when it comes up.
+type Factory func(device string) (capture.Context, error)
+
+//Tells that an interface is up (it has appeared or has been brought up) or down (it has disappeared or has been
+//brought down).
+type Event struct {
+	Name string
+	Up bool
+}
+
+//Source of the events of the interfaces. The channel is closed when the source is closed.
+type eventSource interface {
+	Events() chan Event
+	Close()
+}
+
+//Context that captures the interfaces that match the patterns while they are up, joining the packets of all of them
+//in one channel. Every packet is tagged with the name of the interface where it was captured.
+type Context struct {
+	patterns []string
+	factory Factory
+	source eventSource
+	mutex sync.Mutex
+	contexts map[string]capture.Context
+	gapsMutex sync.Mutex
+	down map[string]bool
+	filter string
+	sampler *capture.Sampler
+	wg sync.WaitGroup
+	done chan bool
+	packetsChan chan *capture.Packet
+	gapsChan chan capture.Gap
+	logger *log.Logger
+}
+
+//Checks that the patterns are valid globs (see path.Match), like br-* or eth0.
+func validatePatterns(patterns []string) error {
+	if len(patterns) == 0 {
+		return errors.New("no interfaces to wait for")
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New("invalid interface pattern " + pattern)
+		}
+	}
+	return nil
+}
+
+func newContext(patterns []string, factory Factory, source eventSource) *Context {
+	return &Context{
+		patterns: patterns,
+		factory: factory,
+		source: source,
+		contexts: make(map[string]capture.Context),
+		down: make(map[string]bool),
+		done: make(chan bool),
+		packetsChan: make(chan *capture.Packet),
+		gapsChan: make(chan capture.Gap, capture.GapsBufferSize),
+		logger: log.New(os.Stdout, "[HotplugContext]: ", log.LstdFlags),
+	}
+}
+
+//Starts waiting for the interfaces. The ones that are already up are captured from now.
+func (c *Context) StartCapturing() {
+	c.logger.Println("Waiting for the interfaces", c.patterns)
+	go c.watch()
+}
+
+func (c *Context) watch() {
+	for event := range c.source.Events() {
+		if !c.matches(event.Name) {
+			continue
+		}
+		if event.Up {
+			c.startInterface(event.Name)
+		} else {
+			c.stopInterface(event.Name)
+		}
+	}
+
+	c.mutex.Lock()
+	names := make([]string, 0, len(c.contexts))
+	for name := range c.contexts {
+		names = append(names, name)
+	}
+	c.mutex.Unlock()
+	for _, name := range names {
+		c.closeInterface(name)
+	}
+	close(c.done)
+}
+
+func (c *Context) matches(name string) bool {
+	for _, pattern := range c.patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+//Creates the context of the interface and starts capturing it, if it is not being captured yet. If the context cannot
+//be created, it is tried again the next time the interface changes.
+func (c *Context) startInterface(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.contexts[name]; ok {
+		return
+	}
+
+	context, err := c.factory(name)
+	if err != nil {
+		c.logger.Println("Could not capture", name + ":", err)
+		return
+	}
+
+	if c.filter != "" {
+		if filterable, ok := context.(capture.FilterableContext); !ok {
+			c.logger.Println("The capture of", name, "does not support filters, all the traffic will be captured")
+		} else if err := filterable.SetFilter(c.filter); err != nil {
+			c.logger.Println("Could not apply the filter to", name + ":", err)
+		}
+	}
+	if samplingContext, ok := context.(capture.SamplingContext); ok && c.sampler != nil {
+		samplingContext.SetSampler(c.sampler.Copy())
+	}
+
+	c.logger.Println("Starting the capture of", name)
+	c.contexts[name] = context
+	go context.StartCapturing()
+	c.wg.Add(1)
+	go c.forward(name, context)
+	if gaps := capture.Gaps(context); gaps != nil {
+		c.wg.Add(1)
+		go c.forwardGaps(name, gaps)
+	}
+
+	//If the interface went down before, there was a gap until now
+	c.sendGap(capture.Gap{ Interface: name, Timestamp: time.Now() })
+}
+
+//Stops the capture of the interface (if it was being captured), marking the beginning of a gap.
+func (c *Context) stopInterface(name string) {
+	if c.closeInterface(name) {
+		err := errors.New("the interface is down or has disappeared")
+		c.sendGap(capture.Gap{ Interface: name, Timestamp: time.Now(), Down: true, Err: err })
+	}
+}
+
+//Sends the marker only if it changes the state of the interface. The context of an interface may tell that its
+//capture failed when the interface goes down, which is told here too, so there is only one marker for every gap.
+func (c *Context) sendGap(gap capture.Gap) {
+	c.gapsMutex.Lock()
+	defer c.gapsMutex.Unlock()
+	if c.down[gap.Interface] == gap.Down {
+		return
+	}
+	c.down[gap.Interface] = gap.Down
+	capture.SendGap(c.gapsChan, gap)
+}
+
+func (c *Context) closeInterface(name string) bool {
+	c.mutex.Lock()
+	context, ok := c.contexts[name]
+	delete(c.contexts, name)
+	c.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	c.logger.Println("Stopping the capture of", name)
+	context.Close()
+	return true
+}
+
+func (c *Context) forward(name string, context capture.Context) {
+	for packet := range context.Packets() {
+		packet.Interface = name
+		c.packetsChan <- packet
+	}
+	c.wg.Done()
+}
+
+func (c *Context) forwardGaps(name string, gaps chan capture.Gap) {
+	for gap := range gaps {
+		if gap.Interface == "" {
+			gap.Interface = name
+		}
+		c.sendGap(gap)
+	}
+	c.wg.Done()
+}
+
+//Stops waiting for the interfaces and ends the capture of all of them.
+func (c *Context) Close() {
+	c.logger.Println("Closing...")
+	c.source.Close()
+	<- c.done
+	c.wg.Wait()
+	close(c.packetsChan)
+	close(c.gapsChan)
+}
+
+//Returns the channel where the packets of all the interfaces will be passed through.
+func (c *Context) Packets() chan *capture.Packet {
+	return c.packetsChan
+}
+
+//Returns the channel where the gap markers are passed through when an interface disappears and when it comes back,
+//and the gap markers of the contexts of the interfaces.
+func (c *Context) Gaps() chan capture.Gap {
+	return c.gapsChan
+}
+
+//There is no single MAC for all the interfaces, so it returns nil. Use GetInterfaceMAC instead.
+func (c *Context) GetMAC() net.HardwareAddr {
+	return nil
+}
+
+//Gets the MAC address of the given interface, or nil if the interface is not being captured.
+func (c *Context) GetInterfaceMAC(iface string) net.HardwareAddr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	context, ok := c.contexts[iface]
+	if !ok {
+		return nil
+	}
+	return context.GetMAC()
+}
+
+//Gets the networks of the interfaces that are being captured right now.
+func (c *Context) GetNetworks() []*net.IPNet {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	networks := make([]*net.IPNet, 0)
+	for _, context := range c.contexts {
+		networks = append(networks, capture.Networks(context)...)
+	}
+	return networks
+}
+
+//Gets the statistics of the interfaces that are being captured right now.
+func (c *Context) Health() ([]capture.Health, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	health := make([]capture.Health, 0)
+	for name, context := range c.contexts {
+		contextHealth, err := capture.GetHealth(context)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range contextHealth {
+			if h.Interface == "" {
+				h.Interface = name
+			}
+			health = append(health, h)
+		}
+	}
+	return health, nil
+}
+
+//Stores the filter, which is applied to every interface when its capture starts. Must be called before
+//StartCapturing.
+func (c *Context) SetFilter(expr string) error {
+	c.filter = expr
+	return nil
+}
+
+//Stores the sampler, a copy of it is set to every interface when its capture starts. Must be called before
+//StartCapturing.
+func (c *Context) SetSampler(sampler *capture.Sampler) {
+	c.sampler = sampler
+}
@@ -0,0 +1,158 @@
+package hotplug
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+type dumbSource struct {
+	events chan Event
+}
+
+func (s *dumbSource) Events() chan Event { return s.events }
+func (s *dumbSource) Close() { close(s.events) }
+
+type dumbContext struct {
+	name string
+	p chan *capture.Packet
+	closed chan bool
+}
+
+func (c *dumbContext) GetMAC() net.HardwareAddr { return net.HardwareAddr{ 0x12, 0, 0, 0, 0, byte(len(c.name)) } }
+func (c *dumbContext) Packets() chan *capture.Packet { return c.p }
+func (c *dumbContext) StartCapturing() {}
+func (c *dumbContext) Close() {
+	close(c.p)
+	c.closed <- true
+}
+
+//Creates contexts that are sent to the channel, so the test can use them.
+func dumbFactory(created chan *dumbContext) Factory {
+	return func(device string) (capture.Context, error) {
+		if device == "broken" {
+			return nil, errors.New("broken")
+		}
+		context := &dumbContext{ device, make(chan *capture.Packet), make(chan bool, 1) }
+		created <- context
+		return context, nil
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := validatePatterns([]string{ "eth0", "br-*" }); err != nil {
+		t.Error("The patterns should be valid:", err)
+	}
+	if err := validatePatterns([]string{ "br-[" }); err == nil {
+		t.Error("The pattern br-[ should be invalid")
+	}
+	if err := validatePatterns(nil); err == nil {
+		t.Error("Without patterns, there should be an error")
+	}
+}
+
+func TestContextCapturesTheInterfacesThatMatch(t *testing.T) {
+	source := &dumbSource{ make(chan Event) }
+	created := make(chan *dumbContext, 4)
+	c := newContext([]string{ "br-*", "eth0" }, dumbFactory(created), source)
+
+	c.StartCapturing()
+	source.events <- Event{ "wlan0", true }
+	source.events <- Event{ "broken", true }
+	source.events <- Event{ "br-lan", true }
+	brLan := <- created
+	brLan.p <- &capture.Packet{}
+	packet := <- c.Packets()
+
+	if brLan.name != "br-lan" || packet.Interface != "br-lan" {
+		t.Error("The packet should come from br-lan, but comes from", packet.Interface)
+	}
+	if mac := c.GetInterfaceMAC("br-lan"); mac.String() != brLan.GetMAC().String() {
+		t.Error("The MAC of br-lan is not the expected one:", mac)
+	}
+	if len(created) != 0 {
+		t.Error("Only br-lan should be captured")
+	}
+
+	c.Close()
+	if _, ok := <- c.Packets(); ok {
+		t.Error("Packets channel should be closed")
+	}
+	if len(brLan.closed) != 1 {
+		t.Error("The context of br-lan should be closed")
+	}
+}
+
+func TestContextStopsAndRestartsTheInterfaces(t *testing.T) {
+	source := &dumbSource{ make(chan Event) }
+	created := make(chan *dumbContext, 4)
+	c := newContext([]string{ "eth0" }, dumbFactory(created), source)
+
+	c.StartCapturing()
+	source.events <- Event{ "eth0", true }
+	first := <- created
+	source.events <- Event{ "eth0", true }
+	source.events <- Event{ "eth0", false }
+	<- first.closed
+	down := <- c.Gaps()
+	source.events <- Event{ "eth0", true }
+	<- created
+	up := <- c.Gaps()
+	c.Close()
+
+	if down.Interface != "eth0" || !down.Down || down.Err == nil {
+		t.Error("The gap should begin when eth0 goes down:", down)
+	}
+	if up.Interface != "eth0" || up.Down {
+		t.Error("The gap should end when eth0 comes back:", up)
+	}
+	if len(created) != 0 {
+		t.Error("The context of eth0 should only be created once for every time it comes up")
+	}
+}
+
+//Context that fails when its interface goes down, as the libpcap one does.
+type failingContext struct {
+	dumbContext
+	gaps chan capture.Gap
+}
+
+func (c *failingContext) Gaps() chan capture.Gap { return c.gaps }
+func (c *failingContext) Close() {
+	close(c.gaps)
+	c.dumbContext.Close()
+}
+
+func TestContextTellsEveryGapOnce(t *testing.T) {
+	source := &dumbSource{ make(chan Event) }
+	created := make(chan *failingContext, 4)
+	c := newContext([]string{ "eth0" }, func(device string) (capture.Context, error) {
+		context := &failingContext{ dumbContext{ device, make(chan *capture.Packet), make(chan bool, 1) }, make(chan capture.Gap, 1) }
+		created <- context
+		return context, nil
+	}, source)
+
+	c.StartCapturing()
+	source.events <- Event{ "eth0", true }
+	first := <- created
+	first.gaps <- capture.Gap{ Timestamp: time.Now(), Down: true, Err: errors.New("read error") }
+	down := <- c.Gaps()
+	source.events <- Event{ "eth0", false }
+	<- first.closed
+	source.events <- Event{ "eth0", true }
+	<- created
+	up := <- c.Gaps()
+	c.Close()
+
+	if down.Interface != "eth0" || !down.Down {
+		t.Error("The gap should begin when the capture of eth0 fails:", down)
+	}
+	if up.Interface != "eth0" || up.Down {
+		t.Error("The gap should end when eth0 comes back, but the marker is", up)
+	}
+	if gap, ok := <- c.Gaps(); ok {
+		t.Error("There should be no more markers, but there is", gap)
+	}
+}
@@ -0,0 +1,132 @@
+// +build linux
+
+package hotplug
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+	"golang.org/x/sys/unix"
+)
+
+//How long a read of the netlink socket waits for messages, so the watcher can be stopped
+const receiveTimeout = 500 //ms
+
+//Watches the interfaces of the system using netlink link notifications (RTM_NEWLINK and RTM_DELLINK).
+type watcher struct {
+	fd int
+	stop chan bool
+	events chan Event
+}
+
+//Creates a context that captures the interfaces that match the patterns (globs like br-* or exact names) while they
+//are up. The context of every interface is created by the factory when it comes up and closed when it goes down.
+func New(patterns []string, factory Factory) (*Context, error) {
+	if err := validatePatterns(patterns); err != nil {
+		return nil, err
+	}
+
+	w, err := newWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return newContext(patterns, factory, w), nil
+}
+
+//Subscribes to the link notifications and then sends an event for every interface that is already up, so no
+//interface is missed between both steps.
+func newWatcher() (*watcher, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW | unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrNetlink{ Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK })
+	if err == nil {
+		tv := unix.NsecToTimeval(receiveTimeout * 1000000)
+		err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	}
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	w := &watcher{ fd, make(chan bool), make(chan Event) }
+	go w.run()
+	return w, nil
+}
+
+func (w *watcher) run() {
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags & net.FlagUp != 0 {
+			w.events <- Event{ iface.Name, true }
+		}
+	}
+
+	buffer := make([]byte, unix.Getpagesize() * 4)
+	for {
+		select {
+		case <- w.stop:
+			_ = unix.Close(w.fd)
+			close(w.events)
+			return
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(w.fd, buffer, 0)
+		if err != nil {
+			//The timeout expired, or the kernel dropped notifications (ENOBUFS) that will be known in the next change
+			continue
+		}
+		for _, event := range parseLinkMessages(buffer[:n]) {
+			w.events <- event
+		}
+	}
+}
+
+func (w *watcher) Events() chan Event {
+	return w.events
+}
+
+func (w *watcher) Close() {
+	close(w.stop)
+}
+
+//Gets the events of the link messages. A new link is up if it has the IFF_UP flag, a deleted link is always down.
+func parseLinkMessages(data []byte) []Event {
+	messages, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil
+	}
+
+	events := make([]Event, 0, len(messages))
+	for i := range messages {
+		message := &messages[i]
+		if message.Header.Type != unix.RTM_NEWLINK && message.Header.Type != unix.RTM_DELLINK {
+			continue
+		}
+		if len(message.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+
+		info := (*unix.IfInfomsg)(unsafe.Pointer(&message.Data[0]))
+		attributes, err := syscall.ParseNetlinkRouteAttr(message)
+		if err != nil {
+			continue
+		}
+		for _, attribute := range attributes {
+			if attribute.Attr.Type != unix.IFLA_IFNAME {
+				continue
+			}
+
+			name := string(attribute.Value)
+			for len(name) > 0 && name[len(name) - 1] == 0 {
+				name = name[:len(name) - 1]
+			}
+			up := message.Header.Type == unix.RTM_NEWLINK && info.Flags & unix.IFF_UP != 0
+			events = append(events, Event{ name, up })
+		}
+	}
+	return events
+}
@@ -0,0 +1,48 @@
+// +build linux
+
+package hotplug
+
+import (
+	"encoding/binary"
+	"testing"
+	"golang.org/x/sys/unix"
+)
+
+//Builds a link message with the given type, flags and name, as the kernel sends them.
+func linkMessage(messageType uint16, flags uint32, name string) []byte {
+	attribute := make([]byte, unix.SizeofRtAttr + len(name) + 1)
+	binary.LittleEndian.PutUint16(attribute[0:2], uint16(len(attribute)))
+	binary.LittleEndian.PutUint16(attribute[2:4], unix.IFLA_IFNAME)
+	copy(attribute[unix.SizeofRtAttr:], name)
+	for len(attribute) % 4 != 0 {
+		attribute = append(attribute, 0)
+	}
+
+	info := make([]byte, unix.SizeofIfInfomsg)
+	binary.LittleEndian.PutUint32(info[8:12], flags)
+
+	message := make([]byte, unix.SizeofNlMsghdr)
+	message = append(message, info...)
+	message = append(message, attribute...)
+	binary.LittleEndian.PutUint32(message[0:4], uint32(len(message)))
+	binary.LittleEndian.PutUint16(message[4:6], messageType)
+	return message
+}
+
+func TestParseLinkMessages(t *testing.T) {
+	data := linkMessage(unix.RTM_NEWLINK, unix.IFF_UP | unix.IFF_RUNNING, "br-lan")
+	data = append(data, linkMessage(unix.RTM_NEWLINK, 0, "eth1")...)
+	data = append(data, linkMessage(unix.RTM_DELLINK, unix.IFF_UP, "usb0")...)
+
+	events := parseLinkMessages(data)
+
+	expected := []Event{{ "br-lan", true }, { "eth1", false }, { "usb0", false }}
+	if len(events) != len(expected) {
+		t.Fatal("Expected", expected, "but got", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Error("Event", i, "should be", expected[i], "but is", events[i])
+		}
+	}
+}
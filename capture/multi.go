@@ -0,0 +1,145 @@
+package capture
+
+import (
+	"net"
+	"sync"
+)
+
+//Context that captures from several interfaces at once, joining the packets of all of them in one channel. Every
+//packet is tagged with the name of the interface where it was captured.
+type MultiContext struct {
+	contexts map[string]Context
+	packetsChan chan *Packet
+	gapsChan chan Gap
+	wg sync.WaitGroup
+	gapsWg sync.WaitGroup
+}
+
+//Context that captures from several interfaces. The MAC that tells if a packet is reversed depends on the interface
+//where it was captured (see Packet.Interface).
+type MultiInterfaceContext interface {
+	Context
+	//Gets the MAC address of the given interface.
+	GetInterfaceMAC(iface string) net.HardwareAddr
+}
+
+//Creates a capture context that joins the given contexts. The key of the map is the name of the interface that
+//captures the context.
+func NewMulti(contexts map[string]Context) *MultiContext {
+	return &MultiContext{
+		contexts: contexts,
+		packetsChan: make(chan *Packet),
+		gapsChan: make(chan Gap, GapsBufferSize),
+	}
+}
+
+//Starts the capture session of all the contexts.
+func (m *MultiContext) StartCapturing() {
+	for iface, context := range m.contexts {
+		m.wg.Add(1)
+		go context.StartCapturing()
+		go m.forward(iface, context)
+		if gaps := Gaps(context); gaps != nil {
+			m.gapsWg.Add(1)
+			go m.forwardGaps(iface, gaps)
+		}
+	}
+}
+
+func (m *MultiContext) forward(iface string, context Context) {
+	for packet := range context.Packets() {
+		packet.Interface = iface
+		m.packetsChan <- packet
+	}
+	m.wg.Done()
+}
+
+func (m *MultiContext) forwardGaps(iface string, gaps chan Gap) {
+	for gap := range gaps {
+		if gap.Interface == "" {
+			gap.Interface = iface
+		}
+		SendGap(m.gapsChan, gap)
+	}
+	m.gapsWg.Done()
+}
+
+//Ends the capture session of all the contexts.
+func (m *MultiContext) Close() {
+	for _, context := range m.contexts {
+		context.Close()
+	}
+	m.wg.Wait()
+	m.gapsWg.Wait()
+	close(m.packetsChan)
+	close(m.gapsChan)
+}
+
+//Returns the channel where the packets of all the contexts will be passed through.
+func (m *MultiContext) Packets() chan *Packet {
+	return m.packetsChan
+}
+
+//There is no single MAC for all the interfaces, so it returns nil. Use GetInterfaceMAC instead.
+func (m *MultiContext) GetMAC() net.HardwareAddr {
+	return nil
+}
+
+//Gets the MAC address of the given interface, or nil if the interface is not being captured.
+func (m *MultiContext) GetInterfaceMAC(iface string) net.HardwareAddr {
+	context, ok := m.contexts[iface]
+	if !ok {
+		return nil
+	}
+	return context.GetMAC()
+}
+
+//Gets the networks of all the contexts.
+func (m *MultiContext) GetNetworks() []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+	for _, context := range m.contexts {
+		networks = append(networks, Networks(context)...)
+	}
+	return networks
+}
+
+//Returns the channel where the gap markers of all the contexts that can tell them will be passed through.
+func (m *MultiContext) Gaps() chan Gap {
+	return m.gapsChan
+}
+
+//Gets the statistics of all the contexts that can tell them.
+func (m *MultiContext) Health() ([]Health, error) {
+	health := make([]Health, 0)
+	for iface, context := range m.contexts {
+		contextHealth, err := GetHealth(context)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range contextHealth {
+			if h.Interface == "" {
+				h.Interface = iface
+			}
+			health = append(health, h)
+		}
+	}
+	return health, nil
+}
+
+//Sets a copy of the sampler to every context, so every interface adapts its own rate. Contexts that cannot sample are
+//left untouched.
+func (m *MultiContext) SetSampler(sampler *Sampler) {
+	for _, context := range m.contexts {
+		if samplingContext, ok := context.(SamplingContext); ok {
+			samplingContext.SetSampler(sampler.Copy())
+		}
+	}
+}
+
+//Gets the MAC address of the interface where the packet was captured.
+func InterfaceMAC(c Context, p *Packet) net.HardwareAddr {
+	if multi, ok := c.(MultiInterfaceContext); ok {
+		return multi.GetInterfaceMAC(p.Interface)
+	}
+	return c.GetMAC()
+}
@@ -0,0 +1,117 @@
+package capture
+
+import (
+	"net"
+	"testing"
+)
+
+type dumbContext struct {
+	mac net.HardwareAddr
+	p chan *Packet
+}
+
+func (c *dumbContext) GetMAC() net.HardwareAddr { return c.mac }
+func (c *dumbContext) Packets() chan *Packet { return c.p }
+func (c *dumbContext) StartCapturing() {}
+func (c *dumbContext) Close() { close(c.p) }
+
+func TestMultiContextTagsPacketsWithTheInterface(t *testing.T) {
+	eth0 := &dumbContext{ []byte{ 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 }, make(chan *Packet) }
+	multi := NewMulti(map[string]Context{ "eth0": eth0 })
+
+	multi.StartCapturing()
+	eth0.p <- &Packet{}
+	packet := <- multi.Packets()
+	multi.Close()
+
+	if packet.Interface != "eth0" {
+		t.Error("Interface should be eth0, but is", packet.Interface)
+	}
+}
+
+func TestMultiContextClosesWhenAllContextsAreClosed(t *testing.T) {
+	eth0 := &dumbContext{ nil, make(chan *Packet) }
+	eth1 := &dumbContext{ nil, make(chan *Packet) }
+	multi := NewMulti(map[string]Context{ "eth0": eth0, "eth1": eth1 })
+
+	multi.StartCapturing()
+	multi.Close()
+
+	if _, ok := <- multi.Packets(); ok {
+		t.Error("Packets channel should be closed")
+	}
+}
+
+func TestInterfaceMACReturnsTheMACOfTheInterfaceOfThePacket(t *testing.T) {
+	eth0 := &dumbContext{ []byte{ 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 }, make(chan *Packet) }
+	eth1 := &dumbContext{ []byte{ 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff }, make(chan *Packet) }
+	multi := NewMulti(map[string]Context{ "eth0": eth0, "eth1": eth1 })
+
+	mac := InterfaceMAC(multi, &Packet{ Interface: "eth1" })
+
+	if mac.String() != "aa:bb:cc:dd:ee:ff" {
+		t.Error("MAC should be aa:bb:cc:dd:ee:ff, but is", mac)
+	}
+}
+
+func TestInterfaceMACReturnsTheMACOfTheContextIfItIsNotMulti(t *testing.T) {
+	eth0 := &dumbContext{ []byte{ 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 }, make(chan *Packet) }
+
+	mac := InterfaceMAC(eth0, &Packet{ Interface: "eth1" })
+
+	if mac.String() != "11:22:33:44:55:66" {
+		t.Error("MAC should be 11:22:33:44:55:66, but is", mac)
+	}
+}
+
+type dumbHealthContext struct {
+	dumbContext
+}
+
+func (c *dumbHealthContext) Health() ([]Health, error) {
+	return []Health{{ Received: 10 }}, nil
+}
+
+func TestMultiContextHealthOfTheContextsThatTellIt(t *testing.T) {
+	eth0 := &dumbHealthContext{ dumbContext{ nil, make(chan *Packet) } }
+	eth1 := &dumbContext{ nil, make(chan *Packet) }
+	multi := NewMulti(map[string]Context{ "eth0": eth0, "eth1": eth1 })
+
+	health, err := multi.Health()
+
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(health) != 1 || health[0].Interface != "eth0" || health[0].Received != 10 {
+		t.Error("Health should only have eth0 with 10 received packets, but is", health)
+	}
+}
+
+type dumbGapContext struct {
+	dumbContext
+	gaps chan Gap
+}
+
+func (c *dumbGapContext) Gaps() chan Gap { return c.gaps }
+func (c *dumbGapContext) Close() {
+	close(c.p)
+	close(c.gaps)
+}
+
+func TestMultiContextForwardsTheGapsWithTheInterface(t *testing.T) {
+	eth0 := &dumbGapContext{ dumbContext{ nil, make(chan *Packet) }, make(chan Gap, 1) }
+	eth1 := &dumbContext{ nil, make(chan *Packet) }
+	multi := NewMulti(map[string]Context{ "eth0": eth0, "eth1": eth1 })
+
+	multi.StartCapturing()
+	eth0.gaps <- Gap{ Down: true }
+	gap := <- multi.Gaps()
+	multi.Close()
+
+	if gap.Interface != "eth0" || !gap.Down {
+		t.Error("The gap is not the expected one:", gap)
+	}
+	if _, ok := <- multi.Gaps(); ok {
+		t.Error("Gaps channel should be closed")
+	}
+}
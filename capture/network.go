@@ -0,0 +1,59 @@
+package capture
+
+import "net"
+
+//Context that knows the IP networks of the captured interface. On links without MAC addresses (tunnels, PPP...), the
+//networks are used to know which side of the packet is the local device.
+type NetworkContext interface {
+	Context
+	//Gets the networks of the addresses assigned to the captured interface.
+	GetNetworks() []*net.IPNet
+}
+
+//Gets the networks of the context, or nil if the context does not know them.
+func Networks(c Context) []*net.IPNet {
+	if networkContext, ok := c.(NetworkContext); ok {
+		return networkContext.GetNetworks()
+	}
+	return nil
+}
+
+//Gets the networks of the addresses assigned to the interface. For the pseudo-interface "any", returns the networks of
+//all the interfaces.
+func InterfaceNetworks(name string) ([]*net.IPNet, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make([]*net.IPNet, 0)
+	for _, i := range interfaces {
+		if name != "any" && i.Name != name {
+			continue
+		}
+
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				networks = append(networks, ipNet)
+			}
+		}
+	}
+	return networks, nil
+}
+
+//Returns true if the IP is inside any of the networks.
+func ContainsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,19 +1,115 @@
 package capture
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 //Filtered/processed packet from a capture context. Holds the necessary information for the app to show the data.
 type Packet struct {
-	Bytes uint16 //The length of the packet (without ethernet header)
-	DataBytes uint16 //If possible, the length of the application layer (TCP/UDP's payload)
+	FrameBytes uint64 //The length of the frame in the wire (with the link layer headers)
+	Bytes uint64 //The length of the packet (without the link layer headers, VLAN tags nor PPPoE)
+	DataBytes uint64 //If possible, the length of the application layer (TCP/UDP's payload without their headers)
 	SrcMac net.HardwareAddr //The source MAC address
 	SrcIp net.IP //The source IP address (if available), could be IPv4 or IPv6
 	DstMac net.HardwareAddr //The destination MAC address
 	DstIp net.IP //The destination IP address (if available), could be IPv4 or IPv6
 	IpType uint8 //Type of IP: 4, 6 or 0 (for nothing)
+	Protocol uint8 //The IP protocol number of the payload (6 for TCP, 17 for UDP...), or 0 if there is no IP
+	SrcPort uint16 //The source TCP/UDP port (if available)
+	DstPort uint16 //The destination TCP/UDP port (if available)
+	TcpFlags uint8 //The flags of the TCP header (see TcpSyn, TcpFin...), or 0 if it is not TCP
+	Packets uint64 //Number of packets that this one stands for (a flow record has several), 0 or 1 for one packet
+	Timestamp time.Time //When the packet was captured
+	Interface string //Name of the interface where the packet was captured (if known)
+	VlanID uint16 //The 802.1Q VLAN ID (the inner one with QinQ), or 0 if the frame was not tagged
+	OuterVlanID uint16 //With QinQ, the outer (service) VLAN ID, or 0 otherwise
+	SamplingRate uint32 //If sampled, this packet represents SamplingRate packets (0 or 1 if not sampled)
+	DnsAnswers []DnsAnswer //If the packet is a DNS (or mDNS) response, the addresses of the names in it
+	ServerName string //If the packet is a TLS ClientHello or a QUIC Initial packet of a client, the server name (SNI)
 	reversed bool //Stores if Reverse() was called
 }
 
+//An address of a name, taken from a DNS response. The name is the one that was asked for, not the one of the CNAME that
+//has the address.
+type DnsAnswer struct {
+	Name string //Lowercase, without the final dot
+	Ip net.IP
+	Ttl uint32 //Seconds
+}
+
+const (
+	TcpFin = 0x01 //FIN flag of the TCP header
+	TcpSyn = 0x02 //SYN flag of the TCP header
+	TcpRst = 0x04 //RST flag of the TCP header
+	TcpAck = 0x10 //ACK flag of the TCP header
+)
+
+//Transport protocol of a packet, as counted in the breakdown of the traffic.
+type L4Protocol uint8
+
+const (
+	L4Other L4Protocol = iota //Any other protocol, or no IP
+	L4Tcp
+	L4Udp
+	L4Icmp
+	L4Icmpv6
+	L4ProtocolCount //Number of protocols, not a protocol
+)
+
+func (p L4Protocol) String() string {
+	switch p {
+	case L4Tcp:
+		return "tcp"
+	case L4Udp:
+		return "udp"
+	case L4Icmp:
+		return "icmp"
+	case L4Icmpv6:
+		return "icmpv6"
+	default:
+		return "other"
+	}
+}
+
+//Gets the transport protocol of the packet from its IP protocol number.
+func (p Packet) L4() L4Protocol {
+	return L4ProtocolOf(p.Protocol)
+}
+
+//Gets the transport protocol of an IP protocol number.
+func L4ProtocolOf(number uint8) L4Protocol {
+	switch number {
+	case 6:
+		return L4Tcp
+	case 17:
+		return L4Udp
+	case 1:
+		return L4Icmp
+	case 58:
+		return L4Icmpv6
+	default:
+		return L4Other
+	}
+}
+
+//Gets the port of the remote end: the destination port, as the packet is reversed when the device is the destination
+//(see Reverse). It is 0 if the packet is not TCP nor UDP.
+func (p Packet) RemotePort() uint16 {
+	if p.Protocol != 6 && p.Protocol != 17 {
+		return 0
+	}
+	return p.DstPort
+}
+
+//Gets the number of packets that this one stands for, without the sampling (see SamplingRate).
+func (p Packet) PacketCount() uint64 {
+	if p.Packets < 1 {
+		return 1
+	}
+	return p.Packets
+}
+
 //Returns true if the packet was an IPv4 one.
 func (p Packet) IsIP4() bool {
 	return p.IpType == 4
@@ -24,8 +120,12 @@ func (p Packet) IsIP6() bool {
 	return p.IpType == 6
 }
 
-//If the SrcMac is the same as dstMac, then is reversed. dstMac should be the MAC of the capturing device.
+//If the SrcMac is the same as dstMac, then is reversed. dstMac should be the MAC of the capturing device. If any of the
+//MACs is unknown, it is not reversed.
 func (p Packet) IsReversed(dstMac net.HardwareAddr) bool {
+	if len(dstMac) == 0 || len(p.SrcMac) < len(dstMac) {
+		return false
+	}
 	for i := 0; i < len(dstMac); i++  {
 		if dstMac[i] != p.SrcMac[i] {
 			return false
@@ -43,14 +143,15 @@ func (p *Packet) Reverse() {
 	tmp2 := p.DstIp
 	p.DstIp = p.SrcIp
 	p.SrcIp = tmp2
+	p.SrcPort, p.DstPort = p.DstPort, p.SrcPort
 }
 
 //Returns true if the packet is IPv6 multicast.
 func (p Packet) IsIPv6Multicast() bool {
-	return p.SrcMac[0] == 0x33 && p.SrcMac[1] == 0x33
+	return len(p.SrcMac) >= 2 && p.SrcMac[0] == 0x33 && p.SrcMac[1] == 0x33
 }
 
 //Returns true if the packet is ethernet broadcast.
 func (p Packet) IsBroadcast() bool {
-	return p.SrcMac[0] == 0xFF
+	return len(p.SrcMac) >= 1 && p.SrcMac[0] == 0xFF
 }
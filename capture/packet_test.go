@@ -68,6 +68,16 @@ func TestIsReversedShouldReturnTrueWhenDstMacIsTheDifferentOfTheArgument(t *test
 	}
 }
 
+func TestIsReversedShouldReturnFalseWhenThereIsNoMac(t *testing.T) {
+	packet := Packet{}
+
+	isReversed := packet.IsReversed(nil)
+
+	if isReversed {
+		t.Error("Should be false")
+	}
+}
+
 func TestReversedChangesDstAndSrcMac(t *testing.T) {
 	packet := Packet{
 		SrcMac: []byte { 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 },
@@ -89,6 +99,19 @@ func TestReversedChangesDstAndSrcMac(t *testing.T) {
 	}
 }
 
+func TestReversedChangesDstAndSrcPort(t *testing.T) {
+	packet := Packet{
+		SrcPort: 50000,
+		DstPort: 443,
+	}
+
+	packet.Reverse()
+
+	if packet.SrcPort != 443 || packet.DstPort != 50000 {
+		t.Error("Ports are not reversed")
+	}
+}
+
 func TestReversedChangesDstAndSrcMacIfIsReversedAlready(t *testing.T) {
 	packet := Packet{
 		SrcMac: []byte { 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 },
@@ -157,4 +180,37 @@ func TestIsBroadcastShouldReturnFalseWhenItIsNot(t *testing.T) {
 	if is {
 		t.Error("Should return false")
 	}
-}
\ No newline at end of file
+}
+
+func TestIsBroadcastShouldReturnFalseWhenThereIsNoMac(t *testing.T) {
+	packet := Packet{}
+
+	if packet.IsBroadcast() || packet.IsIPv6Multicast() {
+		t.Error("Should return false")
+	}
+}
+
+func TestL4OfTheIPProtocols(t *testing.T) {
+	protocols := map[uint8]string{ 6: "tcp", 17: "udp", 1: "icmp", 58: "icmpv6", 47: "other", 0: "other" }
+
+	for number, name := range protocols {
+		if l4 := (Packet{ Protocol: number }).L4(); l4.String() != name {
+			t.Error("Protocol", number, "should be", name, "but is", l4)
+		}
+	}
+}
+
+func TestRemotePortFollowsTheDirection(t *testing.T) {
+	packet := Packet{ Protocol: 17, SrcPort: 50000, DstPort: 443 }
+
+	if packet.RemotePort() != 443 {
+		t.Error("The remote port should be 443, but is", packet.RemotePort())
+	}
+	packet.Reverse()
+	if packet.RemotePort() != 50000 {
+		t.Error("The remote port of the reversed packet should be 50000, but is", packet.RemotePort())
+	}
+	if (Packet{ Protocol: 1, DstPort: 8 }).RemotePort() != 0 {
+		t.Error("ICMP has no ports")
+	}
+}
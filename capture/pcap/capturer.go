@@ -2,88 +2,277 @@
 package pcap
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"sync"
+	"time"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
 )
 
-//Capture the trafic using libpcap
+//How long a read waits for packets, so the capture can be stopped
+const readTimeout = 500 * time.Millisecond
+
+//Capture the trafic using libpcap. If the capture fails (the interface went down, for example), the device is reopened
+//when it comes back and the period without capture is told using gap markers.
 type CaptureContext struct {
 	device string
 	handle *pcap.Handle
+	handleMutex sync.Mutex
+	linkType layers.LinkType
+	filter string
 	stop chan bool
+	done chan bool
 	packetsChan chan *capture.Packet
+	gapsChan chan capture.Gap
 	mac net.HardwareAddr
+	networks []*net.IPNet
+	sampler *capture.Sampler
+	decoderOptions decoder.Options
+	workers int
 	logger *log.Logger
+	startMutex sync.Mutex
+	started bool
+	closed bool
 }
 
-type layersPack struct {
-	parser *gopacket.DecodingLayerParser
-	decoded []gopacket.LayerType
-	eth layers.Ethernet
-	ip4 layers.IPv4
-	ip6 layers.IPv6
-	tcp layers.TCP
-	udp layers.UDP
+//A packet read by libpcap that has been sampled, waiting for a decoding gorutine.
+type rawPacket struct {
+	data []byte
+	ci gopacket.CaptureInfo
+	rate uint32
 }
 
-//Creates a capture context using libpcap implementation and opens the device to capture. Ensure that the process has
-//permission con capture traffic through the device.
-func New(device string) (*CaptureContext, error) {
-	handle, err := pcap.OpenLive(device, 65535, true, 0)
+//Creates a capture context using libpcap implementation and opens the device to capture. The packets are decoded by
+//the given number of gorutines (at least one). Ensure that the process has permission con capture traffic through the
+//device.
+func New(device string, workers int) (*CaptureContext, error) {
+	handle, err := pcap.OpenLive(device, 65535, true, readTimeout)
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err := decoder.NewForLinkType(handle.LinkType(), decoder.Options{}); err != nil {
+		handle.Close()
+		return nil, err
+	}
+
 	mac, err := getMacAddr(device)
 	if err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	networks, err := capture.InterfaceNetworks(device)
+	if err != nil {
+		handle.Close()
 		return nil, err
 	}
 
+	if workers < 1 {
+		workers = 1
+	}
+
 	return &CaptureContext{
-		device,
-		handle,
-		make(chan bool),
-		make(chan *capture.Packet),
-		mac,
-		log.New(os.Stdout, "[Context]: ", log.LstdFlags),
+		device: device,
+		handle: handle,
+		linkType: handle.LinkType(),
+		stop: make(chan bool),
+		done: make(chan bool),
+		packetsChan: make(chan *capture.Packet, capture.PacketsBufferSize),
+		gapsChan: make(chan capture.Gap, capture.GapsBufferSize),
+		mac: mac,
+		networks: networks,
+		workers: workers,
+		logger: log.New(os.Stdout, "[Context]: ", log.LstdFlags),
 	}, nil
 }
 
-//Ends with the capture session
+//Ends with the capture session. If the capture was never started, it will not start anymore.
 func (c *CaptureContext) Close() {
 	c.logger.Println("Closing...")
-	c.stop <- true
-	<- c.stop
-	c.handle.Close()
-	close(c.packetsChan)
+	c.startMutex.Lock()
+	started := c.started
+	c.closed = true
+	c.startMutex.Unlock()
+
 	close(c.stop)
+	if started {
+		<- c.done
+	}
+	c.handleMutex.Lock()
+	if c.handle != nil {
+		c.handle.Close()
+		c.handle = nil
+	}
+	c.handleMutex.Unlock()
+	close(c.packetsChan)
+	close(c.gapsChan)
 }
 
-//Starts the capture session. Use Packets() to grab the packets channel.
+//Starts the capture session. libpcap is read from this gorutine and the packets are decoded by the workers, each one
+//with its own decoder. When the capture fails, the device is reopened with exponential backoff. Use Packets() to grab
+//the packets channel.
 func (c *CaptureContext) StartCapturing() {
-	c.logger.Println("Starting capture gorutine")
+	c.startMutex.Lock()
+	if c.closed {
+		c.startMutex.Unlock()
+		return
+	}
+	c.started = true
+	c.startMutex.Unlock()
+
+	c.logger.Println("Starting capture gorutine with", c.workers, "decoding gorutine(s)")
 	c.logger.Println("Capturing", c.device, "with MAC", c.mac.String())
-	packetSource := gopacket.NewPacketSource(c.handle, c.handle.LinkType())
-	lp := layersPack{}
-	lp.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &lp.eth, &lp.ip4, &lp.ip6, &lp.tcp, &lp.udp)
+	raw := make(chan rawPacket, capture.PacketsBufferSize)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go c.decode(raw, &wg)
+	}
 
-	itsTimeToStop := false
-	for !itsTimeToStop {
+	for {
+		err := c.read(raw)
+		if err == nil {
+			c.logger.Println("Stopping capturer gorutine")
+			break
+		}
+
+		c.logger.Println("Capture of", c.device, "failed:", err)
+		capture.SendGap(c.gapsChan, capture.Gap{ Interface: c.device, Timestamp: time.Now(), Down: true, Err: err })
+		if !c.reopen() {
+			c.logger.Println("Stopping capturer gorutine")
+			break
+		}
+		c.logger.Println("Capture of", c.device, "is back")
+		capture.SendGap(c.gapsChan, capture.Gap{ Interface: c.device, Timestamp: time.Now() })
+	}
+
+	close(raw)
+	wg.Wait()
+	close(c.done)
+}
+
+//Reads the packets of the handle until the capture is stopped (returns nil) or the handle fails (returns the error).
+func (c *CaptureContext) read(raw chan rawPacket) error {
+	for {
 		select {
 		case <- c.stop:
-			c.logger.Println("Stopping capturer gorutine")
-			itsTimeToStop = true
-		case packet := <- packetSource.Packets():
-			c.packetsChan <- parsePacket(packet, &lp)
+			return nil
+		default:
+		}
+
+		data, ci, err := c.handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err == io.EOF || err == pcap.NextErrorNoMorePackets {
+			return errors.New("the capture has ended")
+		} else if err != nil {
+			return err
+		}
+
+		rate, take := c.sampler.Sample(len(c.packetsChan) + len(raw), cap(c.packetsChan) + cap(raw))
+		if take {
+			raw <- rawPacket{ data, ci, rate }
+		}
+	}
+}
+
+//Closes the failed handle and opens the device again, waiting more time after every failed try. Returns false if the
+//capture was stopped while waiting.
+func (c *CaptureContext) reopen() bool {
+	c.handleMutex.Lock()
+	c.handle.Close()
+	c.handle = nil
+	c.handleMutex.Unlock()
+
+	backoff := capture.MinReopenBackoff
+	for {
+		select {
+		case <- c.stop:
+			return false
+		case <- time.After(backoff):
+		}
+
+		handle, err := c.open()
+		if err == nil {
+			c.handleMutex.Lock()
+			c.handle = handle
+			c.handleMutex.Unlock()
+			return true
+		}
+
+		c.logger.Println("Could not reopen", c.device + ":", err)
+		backoff *= 2
+		if backoff > capture.MaxReopenBackoff {
+			backoff = capture.MaxReopenBackoff
 		}
 	}
+}
 
-	c.stop <- true
+//Opens the device with the same filter and link type as the failed handle.
+func (c *CaptureContext) open() (*pcap.Handle, error) {
+	handle, err := pcap.OpenLive(c.device, 65535, true, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if handle.LinkType() != c.linkType {
+		handle.Close()
+		return nil, fmt.Errorf("the link type has changed from %s to %s", c.linkType, handle.LinkType())
+	}
+
+	if c.filter != "" {
+		if err := handle.SetBPFFilter(c.filter); err != nil {
+			handle.Close()
+			return nil, err
+		}
+	}
+
+	return handle, nil
+}
+
+//Decodes the packets read by StartCapturing until the channel is closed.
+func (c *CaptureContext) decode(raw chan rawPacket, wg *sync.WaitGroup) {
+	defer wg.Done()
+	d, _ := decoder.NewForLinkType(c.linkType, c.decoderOptions) //Checked in New
+	for r := range raw {
+		ppacket := d.Decode(r.data, r.ci)
+		ppacket.SamplingRate = r.rate
+		c.packetsChan <- ppacket
+	}
+}
+
+//Applies a BPF filter to the handle, so only the traffic that matches the expression will be captured. The filter is
+//applied again when the device is reopened.
+func (c *CaptureContext) SetFilter(expr string) error {
+	if err := c.handle.SetBPFFilter(expr); err != nil {
+		return err
+	}
+	c.filter = expr
+	return nil
+}
+
+//Sets the sampler that decides which packets are decoded. Must be called before StartCapturing.
+func (c *CaptureContext) SetSampler(sampler *capture.Sampler) {
+	c.sampler = sampler
+}
+
+//Sets what the decoders leave out of the packets. Must be called before StartCapturing.
+func (c *CaptureContext) SetDecoderOptions(options decoder.Options) {
+	c.decoderOptions = options
+}
+
+//Returns the channel where the gap markers are passed through when the capture fails and when it comes back.
+func (c *CaptureContext) Gaps() chan capture.Gap {
+	return c.gapsChan
 }
 
 //Returns the packets channel where all the packets will be passed through.
@@ -91,40 +280,37 @@ func (c *CaptureContext) Packets() chan *capture.Packet {
 	return c.packetsChan
 }
 
-//Gets the MAC Address of the device being captured.
+//Gets the MAC Address of the device being captured (nil if the link has no MAC addresses).
 func (c *CaptureContext) GetMAC() net.HardwareAddr {
 	return c.mac
 }
 
-func parsePacket(packet gopacket.Packet, lp *layersPack) *capture.Packet {
-	lp.parser.DecodeLayers(packet.Data(), &lp.decoded)
-	var ppacket capture.Packet
-	for _, layerType := range lp.decoded {
-		switch layerType {
-		case layers.LayerTypeEthernet:
-			ppacket.Bytes = uint16(len(lp.eth.Payload))
-			ppacket.SrcMac = lp.eth.SrcMAC
-			ppacket.DstMac = lp.eth.DstMAC
-		case layers.LayerTypeIPv4:
-			ppacket.SrcIp = lp.ip4.SrcIP
-			ppacket.DstIp = lp.ip4.DstIP
-			ppacket.IpType = 4
-		case layers.LayerTypeIPv6:
-			ppacket.SrcIp = lp.ip6.SrcIP
-			ppacket.DstIp = lp.ip6.DstIP
-			ppacket.IpType = 6
-		case layers.LayerTypeTCP:
-			if ppacket.IpType == 4 {
-				ppacket.DataBytes = uint16(len(lp.ip4.Payload)) - uint16(lp.tcp.DataOffset * 4)
-			} else {
-				ppacket.DataBytes = uint16(len(lp.ip6.Payload)) - uint16(lp.tcp.DataOffset * 4)
-			}
-		case layers.LayerTypeUDP:
-			ppacket.DataBytes = lp.udp.Length
-		}
+//Gets the statistics of libpcap for the device being captured. While the device is being reopened, there are none and
+//after it is reopened, they start again from zero.
+func (c *CaptureContext) Health() ([]capture.Health, error) {
+	c.handleMutex.Lock()
+	defer c.handleMutex.Unlock()
+	if c.handle == nil {
+		return nil, nil
 	}
 
-	return &ppacket
+	stats, err := c.handle.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	return []capture.Health{{
+		Interface: c.device,
+		Timestamp: time.Now(),
+		Received: uint64(stats.PacketsReceived),
+		DroppedByKernel: uint64(stats.PacketsDropped),
+		DroppedByInterface: uint64(stats.PacketsIfDropped),
+	}}, nil
+}
+
+//Gets the networks of the addresses of the device being captured.
+func (c *CaptureContext) GetNetworks() []*net.IPNet {
+	return c.networks
 }
 
 //Based on https://gist.github.com/rucuriousyet/ab2ab3dc1a339de612e162512be39283
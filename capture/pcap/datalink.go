@@ -0,0 +1,86 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	pcapngSectionHeader = 0x0A0D0D0A
+	pcapngInterfaceDescription = 1
+	pcapngByteOrderMagic = 0x1A2B3C4D
+)
+
+//Reads the link type of a pcap or pcapng file (of its first interface with pcapng). libpcap gives it as a byte through
+//gopacket, which is not enough for the link types above 255 (like the Linux cooked capture v2).
+func fileDatalink(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return readDatalink(bufio.NewReader(file))
+}
+
+func readDatalink(reader io.Reader) (int, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader, header[:12]); err != nil {
+		return 0, err
+	}
+
+	if binary.LittleEndian.Uint32(header[0:4]) == pcapngSectionHeader {
+		return readPcapngDatalink(reader, header[:12])
+	}
+
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(header[0:4]) {
+	case 0xA1B2C3D4, 0xA1B23C4D:
+		order = binary.LittleEndian
+	case 0xD4C3B2A1, 0x4D3CB2A1:
+		order = binary.BigEndian
+	default:
+		return 0, errors.New("not a pcap nor a pcapng file")
+	}
+	if _, err := io.ReadFull(reader, header[12:]); err != nil {
+		return 0, err
+	}
+	//The upper bits may have the FCS length
+	return int(order.Uint32(header[20:24]) & 0xFFFF), nil
+}
+
+//Looks for the first interface description block, after the section header whose beginning is given.
+func readPcapngDatalink(reader io.Reader, sectionHeader []byte) (int, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if binary.BigEndian.Uint32(sectionHeader[8:12]) == pcapngByteOrderMagic {
+		order = binary.BigEndian
+	} else if binary.LittleEndian.Uint32(sectionHeader[8:12]) != pcapngByteOrderMagic {
+		return 0, errors.New("invalid pcapng section header")
+	}
+
+	blockLength := order.Uint32(sectionHeader[4:8])
+	read := uint32(12)
+	header := make([]byte, 12)
+	for {
+		if blockLength < read || blockLength % 4 != 0 {
+			return 0, errors.New("invalid pcapng block")
+		}
+		if _, err := io.CopyN(ioutil.Discard, reader, int64(blockLength - read)); err != nil {
+			return 0, err
+		}
+
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return 0, errors.New("the pcapng file has no interfaces")
+			}
+			return 0, err
+		}
+		blockLength, read = order.Uint32(header[4:8]), 12
+		if order.Uint32(header[0:4]) == pcapngInterfaceDescription {
+			return int(order.Uint16(header[8:10])), nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func pcapHeader(order binary.ByteOrder, datalink uint32) []byte {
+	header := make([]byte, 24)
+	order.PutUint32(header[0:4], 0xA1B2C3D4)
+	order.PutUint16(header[4:6], 2)
+	order.PutUint16(header[6:8], 4)
+	order.PutUint32(header[16:20], 65535)
+	order.PutUint32(header[20:24], datalink)
+	return header
+}
+
+func pcapngBlock(order binary.ByteOrder, blockType uint32, body []byte) []byte {
+	block := make([]byte, 12 + len(body))
+	order.PutUint32(block[0:4], blockType)
+	order.PutUint32(block[4:8], uint32(len(block)))
+	copy(block[8:], body)
+	order.PutUint32(block[len(block) - 4:], uint32(len(block)))
+	return block
+}
+
+func pcapng(order binary.ByteOrder, datalink uint16) []byte {
+	section := make([]byte, 16)
+	order.PutUint32(section[0:4], pcapngByteOrderMagic)
+	order.PutUint16(section[4:6], 1)
+	binary.BigEndian.PutUint64(section[8:16], 0xFFFFFFFFFFFFFFFF)
+	description := make([]byte, 8)
+	order.PutUint16(description[0:2], datalink)
+	order.PutUint32(description[4:8], 65535)
+
+	file := pcapngBlock(order, pcapngSectionHeader, section)
+	//Some writers put other blocks before the interfaces
+	file = append(file, pcapngBlock(order, 0x0BAD, make([]byte, 20))...)
+	return append(file, pcapngBlock(order, pcapngInterfaceDescription, description)...)
+}
+
+func TestReadDatalink(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		datalink int
+	}{
+		{ "pcap little endian", pcapHeader(binary.LittleEndian, 276), 276 },
+		{ "pcap big endian", pcapHeader(binary.BigEndian, 1), 1 },
+		{ "pcap with FCS length", pcapHeader(binary.LittleEndian, 0x14000000 | 113), 113 },
+		{ "pcapng little endian", pcapng(binary.LittleEndian, 276), 276 },
+		{ "pcapng big endian", pcapng(binary.BigEndian, 276), 276 },
+	}
+	for _, test := range tests {
+		datalink, err := readDatalink(bytes.NewReader(test.file))
+		if err != nil || datalink != test.datalink {
+			t.Errorf("%s: the link type should be %d, but it is %d (%v)", test.name, test.datalink, datalink, err)
+		}
+	}
+}
+
+func TestReadDatalinkInvalid(t *testing.T) {
+	noInterfaces := pcapng(binary.LittleEndian, 1)
+	invalid := [][]byte{
+		[]byte("not a capture file"),
+		pcapHeader(binary.LittleEndian, 1)[:20],
+		noInterfaces[:len(noInterfaces) - 24],
+	}
+	for _, file := range invalid {
+		if _, err := readDatalink(bytes.NewReader(file)); err == nil {
+			t.Errorf("%x should not be valid", file)
+		}
+	}
+}
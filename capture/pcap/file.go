@@ -0,0 +1,148 @@
+package pcap
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
+)
+
+//Replays the traffic stored in a pcap or pcapng file using libpcap
+type FileCaptureContext struct {
+	file string
+	handle *pcap.Handle
+	datalink int
+	stop chan bool
+	done chan bool
+	packetsChan chan *capture.Packet
+	mac net.HardwareAddr
+	sampler *capture.Sampler
+	decoderOptions decoder.Options
+	logger *log.Logger
+	startMutex sync.Mutex
+	started bool
+	closed bool
+}
+
+//Creates a capture context that reads the packets from a pcap or pcapng file. As there is no interface to ask for the
+//MAC address, the MAC of the device that captured the traffic must be given to know the direction of the packets.
+func NewFile(file string, mac net.HardwareAddr) (*FileCaptureContext, error) {
+	handle, err := pcap.OpenOffline(file)
+	if err != nil {
+		return nil, err
+	}
+
+	//gopacket gives the link type as a byte, so the whole value is read from the file
+	datalink, err := fileDatalink(file)
+	if err != nil {
+		datalink = int(handle.LinkType())
+	}
+	if _, err := decoder.NewForDatalink(datalink, decoder.Options{}); err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	return &FileCaptureContext{
+		file: file,
+		handle: handle,
+		datalink: datalink,
+		stop: make(chan bool, 1),
+		done: make(chan bool),
+		packetsChan: make(chan *capture.Packet),
+		mac: mac,
+		logger: log.New(os.Stdout, "[FileContext]: ", log.LstdFlags),
+	}, nil
+}
+
+//Ends with the replay, if it has not ended yet. If the replay was never started, it will not start anymore.
+func (c *FileCaptureContext) Close() {
+	c.logger.Println("Closing...")
+	c.startMutex.Lock()
+	started := c.started
+	c.closed = true
+	c.startMutex.Unlock()
+
+	if started {
+		c.stop <- true
+		<- c.done
+	} else {
+		close(c.packetsChan)
+	}
+	c.handle.Close()
+}
+
+//Starts reading the file. When the end of the file is reached, the packets channel will be closed.
+func (c *FileCaptureContext) StartCapturing() {
+	c.startMutex.Lock()
+	if c.closed {
+		c.startMutex.Unlock()
+		return
+	}
+	c.started = true
+	c.startMutex.Unlock()
+
+	c.logger.Println("Starting replay gorutine")
+	c.logger.Println("Replaying", c.file, "with MAC", c.mac.String())
+	packetSource := gopacket.NewPacketSource(c.handle, c.handle.LinkType())
+	packetSource.DecodeOptions = gopacket.DecodeOptions{ Lazy: true, NoCopy: true }
+	d, _ := decoder.NewForDatalink(c.datalink, c.decoderOptions) //Checked in NewFile
+
+	itsTimeToStop := false
+	for !itsTimeToStop {
+		select {
+		case <- c.stop:
+			c.logger.Println("Stopping replay gorutine")
+			itsTimeToStop = true
+		case packet, ok := <- packetSource.Packets():
+			if !ok {
+				c.logger.Println("Reached the end of", c.file)
+				itsTimeToStop = true
+			} else if rate, take := c.sampler.Sample(0, 0); take {
+				ppacket := d.Decode(packet.Data(), packet.Metadata().CaptureInfo)
+				ppacket.SamplingRate = rate
+				c.packetsChan <- ppacket
+			}
+		}
+	}
+
+	close(c.packetsChan)
+	close(c.done)
+}
+
+//Applies a BPF filter to the handle, so only the traffic that matches the expression will be captured.
+func (c *FileCaptureContext) SetFilter(expr string) error {
+	return c.handle.SetBPFFilter(expr)
+}
+
+//Sets the sampler that decides which packets are decoded. As the replay waits for the packets to be processed, the
+//adaptive mode has no effect. Must be called before StartCapturing.
+func (c *FileCaptureContext) SetSampler(sampler *capture.Sampler) {
+	c.sampler = sampler
+}
+
+//Sets what the decoder leaves out of the packets. Must be called before StartCapturing.
+func (c *FileCaptureContext) SetDecoderOptions(options decoder.Options) {
+	c.decoderOptions = options
+}
+
+//Returns the packets channel where all the packets will be passed through.
+func (c *FileCaptureContext) Packets() chan *capture.Packet {
+	return c.packetsChan
+}
+
+//Gets the MAC Address given when the context was created, or nil if the frames of the file have no MAC addresses.
+func (c *FileCaptureContext) GetMAC() net.HardwareAddr {
+	if !decoder.HasMacs(c.datalink) {
+		return nil
+	}
+	return c.mac
+}
+
+//The packets come from a file, so it is always offline.
+func (c *FileCaptureContext) IsOffline() bool {
+	return true
+}
@@ -0,0 +1,109 @@
+package capture
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+const (
+	//Size of the packets channel of the contexts that capture live traffic, so the adaptive sampling can see when the
+	//packets are not being processed fast enough
+	PacketsBufferSize = 1024
+	//The adaptive sampling does not count less than 1 of every maxAdaptiveRate packets
+	maxAdaptiveRate = 4096
+	//Sampled packets with the channel backed up before raising the rate
+	busyPackets = 64
+	//Sampled packets with the channel almost empty before lowering the rate
+	calmPackets = 4096
+)
+
+//Decides which packets are decoded and counted when the traffic is too much to process all of it. 1 of every N packets
+//is taken, either counting them or randomly (every packet has a probability of 1/N). In adaptive mode, N is doubled when
+//the packets channel backs up and halved (down to the initial rate) when it is almost empty again. It can be shared
+//between gorutines.
+type Sampler struct {
+	minRate uint32
+	random bool
+	adaptive bool
+	rate uint32
+	counter uint32
+	busy uint32
+	calm uint32
+}
+
+//Creates a sampler that takes 1 of every rate packets. A rate of 0 or 1 takes all the packets (until the adaptive mode
+//raises it).
+func NewSampler(rate uint32, random bool, adaptive bool) *Sampler {
+	if rate < 1 {
+		rate = 1
+	}
+	return &Sampler{ minRate: rate, random: random, adaptive: adaptive, rate: rate }
+}
+
+//Creates a new sampler with the same configuration but its own state.
+func (s *Sampler) Copy() *Sampler {
+	return NewSampler(s.minRate, s.random, s.adaptive)
+}
+
+//Gets the current rate: 1 of every Rate() packets is taken.
+func (s *Sampler) Rate() uint32 {
+	return atomic.LoadUint32(&s.rate)
+}
+
+//Tells if the next packet must be taken, and the rate used to take it. The pending packets and the capacity of the
+//packets channel are used by the adaptive mode. A nil sampler takes all the packets.
+func (s *Sampler) Sample(pending int, capacity int) (uint32, bool) {
+	if s == nil {
+		return 1, true
+	}
+
+	rate := atomic.LoadUint32(&s.rate)
+	take := true
+	if rate > 1 {
+		if s.random {
+			take = rand.Int63n(int64(rate)) == 0
+		} else {
+			take = atomic.AddUint32(&s.counter, 1) % rate == 0
+		}
+	}
+
+	if take && s.adaptive && capacity > 0 {
+		s.adapt(rate, pending, capacity)
+	}
+	return rate, take
+}
+
+func (s *Sampler) adapt(rate uint32, pending int, capacity int) {
+	switch {
+	case pending * 4 >= capacity * 3:
+		//The channel is backing up, take less packets
+		atomic.StoreUint32(&s.calm, 0)
+		if atomic.AddUint32(&s.busy, 1) >= busyPackets && rate < maxAdaptiveRate {
+			if atomic.CompareAndSwapUint32(&s.rate, rate, rate * 2) {
+				atomic.StoreUint32(&s.busy, 0)
+			}
+		}
+	case pending * 4 <= capacity:
+		atomic.StoreUint32(&s.busy, 0)
+		if atomic.AddUint32(&s.calm, 1) >= calmPackets && rate > s.minRate {
+			newRate := rate / 2
+			if newRate < s.minRate {
+				newRate = s.minRate
+			}
+			if atomic.CompareAndSwapUint32(&s.rate, rate, newRate) {
+				atomic.StoreUint32(&s.calm, 0)
+			}
+		}
+	default:
+		atomic.StoreUint32(&s.busy, 0)
+		atomic.StoreUint32(&s.calm, 0)
+	}
+}
+
+//Context that can sample the traffic, so only some of the packets are decoded. Every packet tells the rate used to
+//sample it (see Packet.SamplingRate), so the numbers can be scaled back.
+type SamplingContext interface {
+	Context
+	//Sets the sampler used to decide which packets are decoded.
+	SetSampler(sampler *Sampler)
+}
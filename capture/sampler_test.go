@@ -0,0 +1,51 @@
+package capture
+
+import "testing"
+
+func TestNilSamplerTakesAllThePackets(t *testing.T) {
+	var sampler *Sampler
+
+	rate, take := sampler.Sample(0, 0)
+
+	if rate != 1 || !take {
+		t.Error("Should take the packet with rate 1, but is", take, rate)
+	}
+}
+
+func TestSamplerCountsOneOfEveryN(t *testing.T) {
+	sampler := NewSampler(4, false, false)
+
+	taken := 0
+	for i := 0; i < 100; i++ {
+		if rate, take := sampler.Sample(0, 0); take {
+			taken++
+			if rate != 4 {
+				t.Error("Rate should be 4, but is", rate)
+			}
+		}
+	}
+
+	if taken != 25 {
+		t.Error("Should take 25 packets, but took", taken)
+	}
+}
+
+func TestAdaptiveSamplerRaisesTheRateWhenTheChannelBacksUp(t *testing.T) {
+	sampler := NewSampler(1, false, true)
+
+	for i := 0; i < busyPackets; i++ {
+		sampler.Sample(1000, 1024)
+	}
+
+	if sampler.Rate() != 2 {
+		t.Error("Rate should be 2, but is", sampler.Rate())
+	}
+
+	for i := 0; i < calmPackets * 2; i++ {
+		sampler.Sample(0, 1024)
+	}
+
+	if sampler.Rate() != 1 {
+		t.Error("Rate should be back to 1, but is", sampler.Rate())
+	}
+}
@@ -0,0 +1,23 @@
+// +build linux
+
+package main
+
+import (
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/afpacket"
+)
+
+func init() {
+	captureImpl["afpacket"] = captureImplementation{ newAFPacketContext, afpacket.GetActiveInterfaces }
+	if defaultCaptureImpl == "" {
+		defaultCaptureImpl = "afpacket"
+	}
+}
+
+func newAFPacketContext(device string, fanout int) (capture.Context, error) {
+	context, err := afpacket.New(device, fanout)
+	if err != nil {
+		return nil, err
+	}
+	return context, nil
+}
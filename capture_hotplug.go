@@ -0,0 +1,20 @@
+// +build linux
+
+package main
+
+import (
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/hotplug"
+)
+
+func init() {
+	hotplugImpl = newHotplugContext
+}
+
+func newHotplugContext(patterns []string, factory func(device string) (capture.Context, error)) (capture.Context, error) {
+	context, err := hotplug.New(patterns, factory)
+	if err != nil {
+		return nil, err
+	}
+	return context, nil
+}
@@ -0,0 +1,31 @@
+// +build !nopcap
+
+package main
+
+import (
+	"net"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/pcap"
+)
+
+func init() {
+	captureImpl["pcap"] = captureImplementation{ newPcapContext, pcap.GetActiveInterfaces }
+	defaultCaptureImpl = "pcap"
+	fileImpl = newPcapFileContext
+}
+
+func newPcapContext(device string, workers int) (capture.Context, error) {
+	context, err := pcap.New(device, workers)
+	if err != nil {
+		return nil, err
+	}
+	return context, nil
+}
+
+func newPcapFileContext(file string, mac net.HardwareAddr) (capture.Context, error) {
+	context, err := pcap.NewFile(file, mac)
+	if err != nil {
+		return nil, err
+	}
+	return context, nil
+}
@@ -1,14 +1,58 @@
 package database
 
-import "net"
+import (
+	"net"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
 
 //Entry with the information to store in the database.
 type Entry interface {
+	Interface() string
+	Vlan() uint16
 	Ipv6() net.IP
 	Ipv4() net.IP
 	Mac() net.HardwareAddr
 	GetDownloadSpeed() uint64
 	GetUploadSpeed() uint64
+	GetLocalDownloadSpeed() uint64
+	GetLocalUploadSpeed() uint64
+	GetDownloadPackets() uint64
+	GetUploadPackets() uint64
+	GetNewFlows() uint64
+	SamplingRate() uint32
+	SamplingError() float64
+	//Traffic of every transport protocol (only the ones with traffic)
+	GetProtocols() []BreakdownItem
+	//Traffic of the remote ports with more traffic, sorted from the most used one
+	GetTopPorts() []BreakdownItem
+	//Traffic of the remote domains with more traffic, sorted from the most used one
+	GetTopDomains() []BreakdownItem
+	//Traffic of every remote country (only the ones with traffic), sorted from the most used one
+	GetCountries() []BreakdownItem
+	//Traffic of the remote autonomous systems with more traffic, sorted from the most used one
+	GetTopAsns() []BreakdownItem
+	//Traffic of every category of applications (only the ones with traffic), sorted from the most used one
+	GetCategories() []BreakdownItem
+	//Beginning of the interval that was measured
+	IntervalStart() time.Time
+	//End of the interval that was measured
+	Timestamp() time.Time
+}
+
+//Traffic of a device in one transport protocol, with one remote port of a protocol, with one remote domain, with one
+//remote country, with one remote autonomous system or of one category of applications, in the interval. It has both the
+//Internet and the local traffic.
+type BreakdownItem struct {
+	Protocol string //tcp, udp, icmp, icmpv6 or other (only in the breakdown by protocol and by port)
+	Port uint16 //The remote TCP/UDP port (only in the breakdown by port)
+	Domain string //The name of the remote end, as resolved by the device (only in the breakdown by domain)
+	Country string //ISO 3166-1 code of the country of the remote end (only in the breakdown by country)
+	Asn uint32 //Number of the autonomous system of the remote end (only in the breakdown by AS)
+	AsOrganization string //Organization of the autonomous system of the remote end (only in the breakdown by AS)
+	Category string //Category of the application of the traffic, like video-streaming (only in the breakdown by category)
+	Download uint64
+	Upload uint64
 }
 
 //How a database should look like.
@@ -17,3 +61,66 @@ type Database interface {
 	StoreMetadata(entry Entry)
 	Close()
 }
+
+//A database that can also store the statistics of the capture, so a dashboard can show when the numbers are not
+//reliable (the packets were dropped before being counted).
+type HealthDatabase interface {
+	Database
+	StoreHealth(health []capture.Health)
+}
+
+//A database that can also store the gap markers of the capture, so a dashboard can show that there is no data (instead
+//of zero usage) while the capture of an interface is down.
+type GapDatabase interface {
+	Database
+	StoreGap(gap capture.Gap)
+}
+
+//A database that can also store the breakdown of the traffic of every device by protocol, by remote port, by remote
+//domain, by remote country, by remote autonomous system and by category of applications, so a dashboard can show what
+//kind of traffic a device has.
+type BreakdownDatabase interface {
+	Database
+	StoreBreakdown(entries []Entry)
+}
+
+//Why a flow record was stored.
+const (
+	FlowEndIdle = "idle" //No packets for the idle timeout
+	FlowEndActive = "active" //The flow lasted the active timeout, it goes on in the next record
+	FlowEndFin = "fin" //Both ends closed the TCP connection
+	FlowEndRst = "rst" //The TCP connection was reset
+	FlowEndShutdown = "shutdown" //The capture ended
+)
+
+//The traffic of one flow (5-tuple and VLAN) in both directions. The source is the end that opened the flow (the sender
+//of the first packet seen or of the TCP SYN).
+type FlowRecord struct {
+	Interface string
+	Vlan uint16
+	Protocol uint8 //The IP protocol number (6 for TCP, 17 for UDP...)
+	SrcIp net.IP
+	SrcPort uint16
+	DstIp net.IP
+	DstPort uint16
+	Start time.Time //When the first packet of the record was seen
+	End time.Time //When the last packet of the record was seen
+	Bytes uint64 //From the source to the destination
+	Packets uint64 //From the source to the destination
+	ReplyBytes uint64 //From the destination to the source
+	ReplyPackets uint64 //From the destination to the source
+	TcpFlags uint8 //The TCP flags seen in both directions (see capture.TcpSyn, capture.TcpFin...)
+	ServerName string //The server name (SNI) of the TLS or QUIC connection, or empty if it was not seen
+	Country string //ISO 3166-1 code of the country of the remote end (the destination, or the source if it is not known)
+	Asn uint32 //Number of the autonomous system of the remote end, or 0 if it is not known
+	AsOrganization string //Organization of the autonomous system of the remote end
+	Application string //Application of the flow, like netflix, or empty if no rule matches it
+	Category string //Category of the application of the flow, like video-streaming
+	Reason string //Why the record was stored (see FlowEndIdle...)
+}
+
+//A database that can also store the records of the flows, so it can tell which connection used the bandwidth.
+type FlowDatabase interface {
+	Database
+	StoreFlows(flows []FlowRecord)
+}
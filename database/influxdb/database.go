@@ -2,8 +2,11 @@ package influxdb
 
 import (
 	"github.com/influxdata/influxdb1-client/v2"
+	"github.com/melchor629/speedy/capture"
 	"github.com/melchor629/speedy/database"
+	"hash/fnv"
 	"log"
+	"strconv"
 	"time"
 )
 
@@ -39,7 +42,8 @@ func (d *Database) Close() {
 	_ = d.client.Close()
 }
 
-//Store a list of entries in a batch. Supposes no error will occur. If so, the app will stop.
+//Store a list of entries in a batch, every point at the beginning of the interval that was measured (so it falls in the
+//right bucket when grouping by time). Supposes no error will occur. If so, the app will stop.
 func (d *Database) Store(entries []database.Entry) {
 	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
 		Database: d.name,
@@ -52,13 +56,20 @@ func (d *Database) Store(entries []database.Entry) {
 	}
 
 	for _, entry := range entries {
-		tags := map[string]string{"mac": entry.Mac().String()}
+		tags := tagsOf(entry)
 		fields := map[string]interface{}{
 			"download": int64(entry.GetDownloadSpeed()),
 			"upload":   int64(entry.GetUploadSpeed()),
+			"local_download": int64(entry.GetLocalDownloadSpeed()),
+			"local_upload": int64(entry.GetLocalUploadSpeed()),
+			"download_packets": int64(entry.GetDownloadPackets()),
+			"upload_packets": int64(entry.GetUploadPackets()),
+			"new_flows": int64(entry.GetNewFlows()),
+			"sampling_rate": int64(entry.SamplingRate()),
+			"sampling_error": entry.SamplingError(),
 		}
 
-		pt, err := client.NewPoint("measures", tags, fields, time.Now())
+		pt, err := client.NewPoint("measures", tags, fields, entry.IntervalStart())
 
 		if err != nil {
 			log.Fatal(err)
@@ -85,7 +96,7 @@ func (d *Database) StoreMetadata(entry database.Entry) {
 		return
 	}
 
-	tags := map[string]string{"mac": entry.Mac().String()}
+	tags := tagsOf(entry)
 	fields := map[string]interface{}{
 		"ipv4": entry.Ipv4(),
 		"ipv6": entry.Ipv6(),
@@ -104,4 +115,252 @@ func (d *Database) StoreMetadata(entry database.Entry) {
 		log.Fatal(err)
 		return
 	}
-}
\ No newline at end of file
+}
+
+//Stores the breakdown of the traffic of the entries in measures_protocols (tagged by protocol), measures_ports (tagged by
+//protocol and remote port), measures_domains (tagged by remote domain), measures_countries (tagged by remote country),
+//measures_asns (tagged by remote AS number and organization) and measures_categories (tagged by category), at the
+//beginning of the interval like the measures.
+func (d *Database) StoreBreakdown(entries []database.Entry) {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database: d.name,
+		Precision: "ns",
+	})
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	for _, entry := range entries {
+		for _, item := range entry.GetProtocols() {
+			tags := tagsOf(entry)
+			tags["protocol"] = item.Protocol
+			addBreakdownPoint(bp, "measures_protocols", tags, item, entry)
+		}
+		for _, item := range entry.GetTopPorts() {
+			tags := tagsOf(entry)
+			tags["protocol"] = item.Protocol
+			tags["port"] = strconv.Itoa(int(item.Port))
+			addBreakdownPoint(bp, "measures_ports", tags, item, entry)
+		}
+		for _, item := range entry.GetTopDomains() {
+			tags := tagsOf(entry)
+			tags["domain"] = item.Domain
+			addBreakdownPoint(bp, "measures_domains", tags, item, entry)
+		}
+		for _, item := range entry.GetCountries() {
+			tags := tagsOf(entry)
+			tags["country"] = item.Country
+			addBreakdownPoint(bp, "measures_countries", tags, item, entry)
+		}
+		for _, item := range entry.GetTopAsns() {
+			tags := tagsOf(entry)
+			tags["asn"] = strconv.FormatUint(uint64(item.Asn), 10)
+			tags["organization"] = item.AsOrganization
+			addBreakdownPoint(bp, "measures_asns", tags, item, entry)
+		}
+		for _, item := range entry.GetCategories() {
+			tags := tagsOf(entry)
+			tags["category"] = item.Category
+			addBreakdownPoint(bp, "measures_categories", tags, item, entry)
+		}
+	}
+	if len(bp.Points()) == 0 {
+		return
+	}
+
+	err = d.client.Write(bp)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+}
+
+func addBreakdownPoint(bp client.BatchPoints, measurement string, tags map[string]string, item database.BreakdownItem, entry database.Entry) {
+	fields := map[string]interface{}{
+		"download": int64(item.Download),
+		"upload": int64(item.Upload),
+	}
+
+	pt, err := client.NewPoint(measurement, tags, fields, entry.IntervalStart())
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	bp.AddPoint(pt)
+}
+
+//Stores the records of the flows in measures_flows, at the beginning of every record. Only the protocol, the interface
+//and the VLAN are tags, the IPs, the ports and the reason are fields to keep the series few (see flowTime for how the
+//records of different flows that start at the same time are different points).
+func (d *Database) StoreFlows(flows []database.FlowRecord) {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database: d.name,
+		Precision: "ns",
+	})
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	used := make(map[int64]bool, len(flows))
+	for _, f := range flows {
+		tags := map[string]string{
+			"protocol": strconv.Itoa(int(f.Protocol)),
+		}
+		if f.Interface != "" {
+			tags["interface"] = f.Interface
+		}
+		if f.Vlan != 0 {
+			tags["vlan"] = strconv.Itoa(int(f.Vlan))
+		}
+		fields := map[string]interface{}{
+			"src_ip": f.SrcIp.String(),
+			"dst_ip": f.DstIp.String(),
+			"reason": f.Reason,
+			"src_port": int64(f.SrcPort),
+			"dst_port": int64(f.DstPort),
+			"duration": f.End.Sub(f.Start).Seconds(),
+			"bytes": int64(f.Bytes),
+			"packets": int64(f.Packets),
+			"reply_bytes": int64(f.ReplyBytes),
+			"reply_packets": int64(f.ReplyPackets),
+			"tcp_flags": int64(f.TcpFlags),
+		}
+		if f.ServerName != "" {
+			fields["server_name"] = f.ServerName
+		}
+		if f.Country != "" {
+			fields["country"] = f.Country
+		}
+		if f.Asn != 0 {
+			fields["asn"] = int64(f.Asn)
+			fields["as_organization"] = f.AsOrganization
+		}
+		if f.Application != "" {
+			fields["application"] = f.Application
+			fields["category"] = f.Category
+		}
+
+		pt, err := client.NewPoint("measures_flows", tags, fields, flowTime(f, used))
+
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		bp.AddPoint(pt)
+	}
+
+	err = d.client.Write(bp)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+}
+
+//Stores the statistics of the capture in measures_health, one point for every interface.
+func (d *Database) StoreHealth(health []capture.Health) {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database: d.name,
+		Precision: "ns",
+	})
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	for _, h := range health {
+		tags := map[string]string{ "interface": h.Interface }
+		fields := map[string]interface{}{
+			"received": int64(h.Received),
+			"dropped_by_kernel": int64(h.DroppedByKernel),
+			"dropped_by_interface": int64(h.DroppedByInterface),
+		}
+
+		pt, err := client.NewPoint("measures_health", tags, fields, h.Timestamp)
+
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		bp.AddPoint(pt)
+	}
+
+	err = d.client.Write(bp)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+}
+
+//Stores the gap marker in measures_gaps, with down=true when the capture went down and down=false when it came back.
+func (d *Database) StoreGap(gap capture.Gap) {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database: d.name,
+		Precision: "ns",
+	})
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	tags := map[string]string{ "interface": gap.Interface }
+	fields := map[string]interface{}{ "down": gap.Down }
+	if gap.Err != nil {
+		fields["error"] = gap.Err.Error()
+	}
+
+	pt, err := client.NewPoint("measures_gaps", tags, fields, gap.Timestamp)
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	bp.AddPoint(pt)
+
+	err = d.client.Write(bp)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+}
+
+//Gets the tags that identify the entry. Entries from links without MAC addresses are identified by their IP.
+func tagsOf(entry database.Entry) map[string]string {
+	tags := map[string]string{}
+	if len(entry.Mac()) != 0 {
+		tags["mac"] = entry.Mac().String()
+	} else if entry.Ipv4() != nil {
+		tags["ip"] = entry.Ipv4().String()
+	} else if entry.Ipv6() != nil {
+		tags["ip"] = entry.Ipv6().String()
+	}
+	if entry.Interface() != "" {
+		tags["interface"] = entry.Interface()
+	}
+	if entry.Vlan() != 0 {
+		tags["vlan"] = strconv.Itoa(int(entry.Vlan()))
+	}
+	return tags
+}
+
+//Gets the time of the point of the flow record. influxdb overwrites the points with the same tags and time, so the
+//nanoseconds of the beginning of the record are replaced by a hash of the IPs and ports of the flow, and moved further
+//if another record of the same batch already uses that time. The real duration is kept in a field.
+func flowTime(f database.FlowRecord, used map[int64]bool) time.Time {
+	hash := fnv.New32a()
+	hash.Write(f.SrcIp)
+	hash.Write(f.DstIp)
+	hash.Write([]byte{ byte(f.SrcPort >> 8), byte(f.SrcPort), byte(f.DstPort >> 8), byte(f.DstPort) })
+	t := f.Start.Truncate(time.Microsecond).Add(time.Duration(hash.Sum32() % 1000))
+	for used[t.UnixNano()] {
+		t = t.Add(time.Nanosecond)
+	}
+	used[t.UnixNano()] = true
+	return t
+}
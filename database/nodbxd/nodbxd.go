@@ -2,6 +2,7 @@ package nodbxd
 
 import (
 	"fmt"
+	"github.com/melchor629/speedy/capture"
 	"github.com/melchor629/speedy/database"
 	"time"
 )
@@ -10,13 +11,97 @@ type NoDBxD struct {}
 
 func (n NoDBxD) Store(entries []database.Entry) {
 	for _, entry := range entries {
-		fmt.Printf("\n[%s] New data:\n", time.Now().Format(time.Stamp))
-		fmt.Printf(" - %s %s %s %d %d\n",
+		fmt.Printf("\n[%s - %s] New data:\n", entry.IntervalStart().Format(time.Stamp), entry.Timestamp().Format(time.Stamp))
+		fmt.Printf(" - %s %d %s %s %s %d %d %d %d %d/%d pkts %d new flows (1:%d ±%.1f%%)\n",
+			entry.Interface(),
+			entry.Vlan(),
 			entry.Mac().String(),
 			entry.Ipv4().String(),
 			entry.Ipv6().String(),
 			entry.GetDownloadSpeed(),
-			entry.GetUploadSpeed())
+			entry.GetUploadSpeed(),
+			entry.GetLocalDownloadSpeed(),
+			entry.GetLocalUploadSpeed(),
+			entry.GetDownloadPackets(),
+			entry.GetUploadPackets(),
+			entry.GetNewFlows(),
+			entry.SamplingRate(),
+			entry.SamplingError() * 100)
+	}
+}
+
+func (n NoDBxD) StoreBreakdown(entries []database.Entry) {
+	for _, entry := range entries {
+		for _, item := range entry.GetProtocols() {
+			fmt.Printf(" - %s %d %s %s: %d %d\n", entry.Interface(), entry.Vlan(), entry.Mac().String(), item.Protocol,
+				item.Download, item.Upload)
+		}
+		for _, item := range entry.GetTopPorts() {
+			fmt.Printf(" - %s %d %s %s/%d: %d %d\n", entry.Interface(), entry.Vlan(), entry.Mac().String(), item.Protocol,
+				item.Port, item.Download, item.Upload)
+		}
+		for _, item := range entry.GetTopDomains() {
+			fmt.Printf(" - %s %d %s %s: %d %d\n", entry.Interface(), entry.Vlan(), entry.Mac().String(), item.Domain,
+				item.Download, item.Upload)
+		}
+		for _, item := range entry.GetCountries() {
+			fmt.Printf(" - %s %d %s %s: %d %d\n", entry.Interface(), entry.Vlan(), entry.Mac().String(), item.Country,
+				item.Download, item.Upload)
+		}
+		for _, item := range entry.GetTopAsns() {
+			fmt.Printf(" - %s %d %s AS%d %s: %d %d\n", entry.Interface(), entry.Vlan(), entry.Mac().String(), item.Asn,
+				item.AsOrganization, item.Download, item.Upload)
+		}
+		for _, item := range entry.GetCategories() {
+			fmt.Printf(" - %s %d %s %s: %d %d\n", entry.Interface(), entry.Vlan(), entry.Mac().String(), item.Category,
+				item.Download, item.Upload)
+		}
+	}
+}
+
+func (n NoDBxD) StoreFlows(flows []database.FlowRecord) {
+	for _, f := range flows {
+		fmt.Printf("\n[%s - %s] Flow %s %d %d %s:%d -> %s:%d: %d bytes/%d pkts, %d bytes/%d pkts back, flags %#02x %s %s AS%d %s %s/%s (%s)\n",
+			f.Start.Format(time.Stamp),
+			f.End.Format(time.Stamp),
+			f.Interface,
+			f.Vlan,
+			f.Protocol,
+			f.SrcIp.String(),
+			f.SrcPort,
+			f.DstIp.String(),
+			f.DstPort,
+			f.Bytes,
+			f.Packets,
+			f.ReplyBytes,
+			f.ReplyPackets,
+			f.TcpFlags,
+			f.ServerName,
+			f.Country,
+			f.Asn,
+			f.AsOrganization,
+			f.Application,
+			f.Category,
+			f.Reason)
+	}
+}
+
+func (n NoDBxD) StoreHealth(health []capture.Health) {
+	for _, h := range health {
+		fmt.Printf("\n[%s] Capture health of %s: %d received, %d dropped by kernel, %d dropped by interface\n",
+			h.Timestamp.Format(time.Stamp),
+			h.Interface,
+			h.Received,
+			h.DroppedByKernel,
+			h.DroppedByInterface)
+	}
+}
+
+func (n NoDBxD) StoreGap(gap capture.Gap) {
+	if gap.Down {
+		fmt.Printf("\n[%s] Capture of %s is down: %v\n", gap.Timestamp.Format(time.Stamp), gap.Interface, gap.Err)
+	} else {
+		fmt.Printf("\n[%s] Capture of %s is back\n", gap.Timestamp.Format(time.Stamp), gap.Interface)
 	}
 }
 
@@ -3,8 +3,10 @@ package timescaledb
 import (
 	"database/sql"
 	"fmt"
+	"github.com/melchor629/speedy/capture"
 	"github.com/melchor629/speedy/database"
 	"log"
+	"net"
 
 	_ "github.com/lib/pq"
 )
@@ -36,7 +38,8 @@ func (d *Database) Close() {
 	_ = d.client.Close()
 }
 
-//Store a list of entries in a batch. Supposes no error will occur. If so, the app will stop.
+//Store a list of entries in a batch, with the interval that was measured in time and time_end. Supposes no error will
+//occur. If so, the app will stop.
 func (d *Database) Store(entries []database.Entry) {
 	if len(entries) == 0 {
 		return
@@ -48,16 +51,29 @@ func (d *Database) Store(entries []database.Entry) {
 	}
 
 	//From https://stackoverflow.com/questions/21108084/golang-mysql-insert-multiple-data-at-once
-	sqlStr := fmt.Sprintf("INSERT INTO %s(time, mac, download, upload) VALUES\n" +
-		"(NOW(), $1, $2, $3);", d.table)
+	sqlStr := fmt.Sprintf("INSERT INTO %s(time, time_end, interface, vlan, mac, ip, download, upload, local_download,\n" +
+		"local_upload, download_packets, upload_packets, new_flows, sampling_rate, sampling_error) VALUES ($1, $2, $3, $4, $5,\n" +
+		"$6, $7, $8, $9, $10, $11, $12, $13, $14, $15);", d.table)
 
 	stmt, _ := txn.Prepare(sqlStr)
 
 	for _, entry := range entries {
 		_, err = stmt.Exec(
+			entry.IntervalStart(),
+			entry.Timestamp(),
+			toNullString(entry.Interface()),
+			toNullInt(int64(entry.Vlan())),
 			toString(entry.Mac()),
+			toString(ipOf(entry)),
 			entry.GetDownloadSpeed(),
 			entry.GetUploadSpeed(),
+			entry.GetLocalDownloadSpeed(),
+			entry.GetLocalUploadSpeed(),
+			entry.GetDownloadPackets(),
+			entry.GetUploadPackets(),
+			entry.GetNewFlows(),
+			entry.SamplingRate(),
+			entry.SamplingError(),
 		)
 
 		if err != nil {
@@ -71,6 +87,154 @@ func (d *Database) Store(entries []database.Entry) {
 	stmt.Close()
 }
 
+//Stores the breakdown of the traffic of the entries in the table with the _breakdown suffix, one row for every protocol
+//(with NULL port) and one for every remote port of the top ones, in the table with the _domains suffix, one row for
+//every remote domain of the top ones, in the table with the _countries suffix, one row for every remote country, in the
+//table with the _asns suffix, one row for every remote autonomous system of the top ones, and in the table with the
+//_categories suffix, one row for every category of applications.
+func (d *Database) StoreBreakdown(entries []database.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	var ports, domains, countries, asns, categories [][]interface{}
+	for _, entry := range entries {
+		for _, item := range append(entry.GetProtocols(), entry.GetTopPorts()...) {
+			ports = append(ports, append(entryColumns(entry), item.Protocol, toNullInt(int64(item.Port)), item.Download,
+				item.Upload))
+		}
+		for _, item := range entry.GetTopDomains() {
+			domains = append(domains, append(entryColumns(entry), item.Domain, item.Download, item.Upload))
+		}
+		for _, item := range entry.GetCountries() {
+			countries = append(countries, append(entryColumns(entry), item.Country, item.Download, item.Upload))
+		}
+		for _, item := range entry.GetTopAsns() {
+			asns = append(asns, append(entryColumns(entry), int64(item.Asn), toNullString(item.AsOrganization),
+				item.Download, item.Upload))
+		}
+		for _, item := range entry.GetCategories() {
+			categories = append(categories, append(entryColumns(entry), item.Category, item.Download, item.Upload))
+		}
+	}
+
+	txn, err := d.client.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	insertRows(txn, fmt.Sprintf("INSERT INTO %s_breakdown(time, time_end, interface, vlan, mac, ip, protocol, port,\n" +
+		"download, upload) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);", d.table), ports)
+	insertRows(txn, fmt.Sprintf("INSERT INTO %s_domains(time, time_end, interface, vlan, mac, ip, domain, download,\n" +
+		"upload) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);", d.table), domains)
+	insertRows(txn, fmt.Sprintf("INSERT INTO %s_countries(time, time_end, interface, vlan, mac, ip, country, download,\n" +
+		"upload) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);", d.table), countries)
+	insertRows(txn, fmt.Sprintf("INSERT INTO %s_asns(time, time_end, interface, vlan, mac, ip, asn, organization,\n" +
+		"download, upload) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);", d.table), asns)
+	insertRows(txn, fmt.Sprintf("INSERT INTO %s_categories(time, time_end, interface, vlan, mac, ip, category,\n" +
+		"download, upload) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);", d.table), categories)
+
+	if err = txn.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//The columns that identify the entry and its interval in the tables of the breakdown.
+func entryColumns(entry database.Entry) []interface{} {
+	return []interface{}{
+		entry.IntervalStart(),
+		entry.Timestamp(),
+		toNullString(entry.Interface()),
+		toNullInt(int64(entry.Vlan())),
+		toString(entry.Mac()),
+		toString(ipOf(entry)),
+	}
+}
+
+//Inserts the rows with the statement in the transaction. The statement is only prepared if there are rows. If something
+//fails, the transaction is rolled back and the app will stop.
+func insertRows(txn *sql.Tx, sqlStr string, rows [][]interface{}) {
+	if len(rows) == 0 {
+		return
+	}
+
+	stmt, err := txn.Prepare(sqlStr)
+	if err != nil {
+		txn.Rollback()
+		log.Fatal(err)
+	}
+
+	for _, row := range rows {
+		if _, err = stmt.Exec(row...); err != nil {
+			stmt.Close()
+			txn.Rollback()
+			log.Fatal(err)
+		}
+	}
+	stmt.Close()
+}
+
+//Stores the records of the flows in the table with the _flows suffix, with the beginning of every record in time and its
+//end in time_end.
+func (d *Database) StoreFlows(flows []database.FlowRecord) {
+	txn, err := d.client.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sqlStr := fmt.Sprintf("INSERT INTO %s_flows(time, time_end, interface, vlan, protocol, src_ip, src_port, dst_ip,\n" +
+		"dst_port, bytes, packets, reply_bytes, reply_packets, tcp_flags, server_name, country, asn, as_organization,\n" +
+		"application, category, reason) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,\n" +
+		"$18, $19, $20, $21);", d.table)
+
+	stmt, _ := txn.Prepare(sqlStr)
+
+	for _, f := range flows {
+		_, err = stmt.Exec(
+			f.Start,
+			f.End,
+			toNullString(f.Interface),
+			toNullInt(int64(f.Vlan)),
+			f.Protocol,
+			toString(f.SrcIp),
+			toNullInt(int64(f.SrcPort)),
+			toString(f.DstIp),
+			toNullInt(int64(f.DstPort)),
+			f.Bytes,
+			f.Packets,
+			f.ReplyBytes,
+			f.ReplyPackets,
+			f.TcpFlags,
+			toNullString(f.ServerName),
+			toNullString(f.Country),
+			toNullInt(int64(f.Asn)),
+			toNullString(f.AsOrganization),
+			toNullString(f.Application),
+			toNullString(f.Category),
+			f.Reason,
+		)
+
+		if err != nil {
+			stmt.Close()
+			txn.Rollback()
+			log.Fatal(err)
+		}
+	}
+
+	txn.Commit()
+	stmt.Close()
+}
+
+//Entries from links without MAC addresses are identified by their IP, the rest have the IPs in the metadata table.
+func ipOf(entry database.Entry) net.IP {
+	if len(entry.Mac()) != 0 {
+		return nil
+	} else if entry.Ipv4() != nil {
+		return entry.Ipv4()
+	}
+	return entry.Ipv6()
+}
+
 func (d *Database) StoreMetadata(entry database.Entry) {
 	sqlStr2 := fmt.Sprintf("INSERT INTO %s_metadata(mac, ipv4, ipv6) VALUES ($1, $2, $3)\n" +
 		"ON CONFLICT (mac) DO\n" +
@@ -93,6 +257,73 @@ func (d *Database) StoreMetadata(entry database.Entry) {
 	}
 }
 
+//Stores the statistics of the capture in the table with the _health suffix, one row for every interface.
+func (d *Database) StoreHealth(health []capture.Health) {
+	if len(health) == 0 {
+		return
+	}
+
+	txn, err := d.client.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sqlStr := fmt.Sprintf("INSERT INTO %s_health(time, interface, received, dropped_by_kernel, dropped_by_interface)\n" +
+		"VALUES ($1, $2, $3, $4, $5);", d.table)
+
+	stmt, _ := txn.Prepare(sqlStr)
+
+	for _, h := range health {
+		_, err = stmt.Exec(
+			h.Timestamp,
+			h.Interface,
+			h.Received,
+			h.DroppedByKernel,
+			h.DroppedByInterface,
+		)
+
+		if err != nil {
+			stmt.Close()
+			txn.Rollback()
+			log.Fatal(err)
+		}
+	}
+
+	txn.Commit()
+	stmt.Close()
+}
+
+//Stores the gap marker in the table with the _gaps suffix, with down=true when the capture went down and down=false
+//when it came back.
+func (d *Database) StoreGap(gap capture.Gap) {
+	errorStr := ""
+	if gap.Err != nil {
+		errorStr = gap.Err.Error()
+	}
+
+	sqlStr := fmt.Sprintf("INSERT INTO %s_gaps(time, interface, down, error) VALUES ($1, $2, $3, $4);", d.table)
+	_, err := d.client.Exec(sqlStr, gap.Timestamp, gap.Interface, gap.Down, toNullString(errorStr))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+//Converts a string into a NullString for database, being the empty string NULL
+func toNullString(str string) sql.NullString {
+	return sql.NullString{
+		String: str,
+		Valid:  str != "",
+	}
+}
+
+//Converts a number into a NullInt64 for database, being 0 NULL
+func toNullInt(value int64) sql.NullInt64 {
+	return sql.NullInt64{
+		Int64: value,
+		Valid: value != 0,
+	}
+}
+
 //Converts an object with .String() method into a NullString for database
 func toString(a interface{ String() string }) sql.NullString {
 	if a == nil {
@@ -2,9 +2,20 @@ CREATE EXTENSION IF NOT EXISTS timescaledb CASCADE;
 
 CREATE TABLE speedy (
   time        TIMESTAMPTZ       NOT NULL,
-  mac         MACADDR           NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
   download    BIGINT            NOT NULL,
-  upload      BIGINT            NOT NULL
+  upload      BIGINT            NOT NULL,
+  local_download BIGINT         NOT NULL,
+  local_upload BIGINT           NOT NULL,
+  download_packets BIGINT       NOT NULL,
+  upload_packets BIGINT         NOT NULL,
+  new_flows   BIGINT            NOT NULL,
+  sampling_rate INTEGER         NOT NULL,
+  sampling_error DOUBLE PRECISION NOT NULL
 );
 
 CREATE TABLE speedy_metadata (
@@ -13,6 +24,115 @@ CREATE TABLE speedy_metadata (
   ipv6        INET              NULL
 );
 
+CREATE TABLE speedy_health (
+  time        TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NOT NULL,
+  received    BIGINT            NOT NULL,
+  dropped_by_kernel BIGINT      NOT NULL,
+  dropped_by_interface BIGINT   NOT NULL
+);
+
+CREATE TABLE speedy_gaps (
+  time        TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NOT NULL,
+  down        BOOLEAN           NOT NULL,
+  error       TEXT              NULL
+);
+
+CREATE TABLE speedy_breakdown (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  protocol    TEXT              NOT NULL,
+  port        INTEGER           NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE speedy_domains (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  domain      TEXT              NOT NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE speedy_countries (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  country     TEXT              NOT NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE speedy_asns (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  asn         BIGINT            NOT NULL,
+  organization TEXT             NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE speedy_categories (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  category    TEXT              NOT NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE speedy_flows (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  protocol    SMALLINT          NOT NULL,
+  src_ip      INET              NOT NULL,
+  src_port    INTEGER           NULL,
+  dst_ip      INET              NOT NULL,
+  dst_port    INTEGER           NULL,
+  bytes       BIGINT            NOT NULL,
+  packets     BIGINT            NOT NULL,
+  reply_bytes BIGINT            NOT NULL,
+  reply_packets BIGINT          NOT NULL,
+  tcp_flags   SMALLINT          NOT NULL,
+  server_name TEXT              NULL,
+  country     TEXT              NULL,
+  asn         BIGINT            NULL,
+  as_organization TEXT          NULL,
+  application TEXT              NULL,
+  category    TEXT              NULL,
+  reason      TEXT              NOT NULL
+);
+
 SELECT create_hypertable('speedy', 'time');
+SELECT create_hypertable('speedy_health', 'time');
+SELECT create_hypertable('speedy_gaps', 'time');
+SELECT create_hypertable('speedy_breakdown', 'time');
+SELECT create_hypertable('speedy_domains', 'time');
+SELECT create_hypertable('speedy_countries', 'time');
+SELECT create_hypertable('speedy_asns', 'time');
+SELECT create_hypertable('speedy_categories', 'time');
+SELECT create_hypertable('speedy_flows', 'time');
 
 CREATE INDEX ON speedy (mac, time DESC);
\ No newline at end of file
@@ -0,0 +1,128 @@
+-- Upgrades the tables created by older versions to the current schema. It can be run again (and after 01-create.sql)
+-- without changing anything. The rows stored before were measured every second, so their end is their beginning plus
+-- one second, and the new counters are 0 in them.
+
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS time_end TIMESTAMPTZ NULL;
+UPDATE speedy SET time_end = time + INTERVAL '1 second' WHERE time_end IS NULL;
+ALTER TABLE speedy ALTER COLUMN time_end SET NOT NULL;
+ALTER TABLE speedy ALTER COLUMN mac DROP NOT NULL;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS interface TEXT NULL DEFAULT NULL;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS vlan INTEGER NULL DEFAULT NULL;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS ip INET NULL DEFAULT NULL;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS local_download BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS local_upload BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS download_packets BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS upload_packets BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS new_flows BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS sampling_rate INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE speedy ADD COLUMN IF NOT EXISTS sampling_error DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS speedy_health (
+  time        TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NOT NULL,
+  received    BIGINT            NOT NULL,
+  dropped_by_kernel BIGINT      NOT NULL,
+  dropped_by_interface BIGINT   NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speedy_gaps (
+  time        TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NOT NULL,
+  down        BOOLEAN           NOT NULL,
+  error       TEXT              NULL
+);
+
+CREATE TABLE IF NOT EXISTS speedy_breakdown (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  protocol    TEXT              NOT NULL,
+  port        INTEGER           NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speedy_domains (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  domain      TEXT              NOT NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speedy_countries (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  country     TEXT              NOT NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speedy_asns (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  asn         BIGINT            NOT NULL,
+  organization TEXT             NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speedy_categories (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  mac         MACADDR           NULL,
+  ip          INET              NULL,
+  category    TEXT              NOT NULL,
+  download    BIGINT            NOT NULL,
+  upload      BIGINT            NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speedy_flows (
+  time        TIMESTAMPTZ       NOT NULL,
+  time_end    TIMESTAMPTZ       NOT NULL,
+  interface   TEXT              NULL,
+  vlan        INTEGER           NULL,
+  protocol    SMALLINT          NOT NULL,
+  src_ip      INET              NOT NULL,
+  src_port    INTEGER           NULL,
+  dst_ip      INET              NOT NULL,
+  dst_port    INTEGER           NULL,
+  bytes       BIGINT            NOT NULL,
+  packets     BIGINT            NOT NULL,
+  reply_bytes BIGINT            NOT NULL,
+  reply_packets BIGINT          NOT NULL,
+  tcp_flags   SMALLINT          NOT NULL,
+  server_name TEXT              NULL,
+  country     TEXT              NULL,
+  asn         BIGINT            NULL,
+  as_organization TEXT          NULL,
+  application TEXT              NULL,
+  category    TEXT              NULL,
+  reason      TEXT              NOT NULL
+);
+
+SELECT create_hypertable('speedy_health', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('speedy_gaps', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('speedy_breakdown', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('speedy_domains', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('speedy_countries', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('speedy_asns', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('speedy_categories', 'time', if_not_exists => TRUE);
+SELECT create_hypertable('speedy_flows', 'time', if_not_exists => TRUE);
@@ -0,0 +1,243 @@
+//Exports the captured traffic as flows to a NetFlow v9 or IPFIX collector (nfdump, pmacct...), so the utility can also
+//work as a flow probe
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Version of the protocol used to export the flows.
+type Protocol uint16
+
+const (
+	ProtocolNetFlow9 Protocol = 9
+	ProtocolIPFIX Protocol = 10
+)
+
+//Parses the name of the protocol: ipfix or netflow9.
+func ParseProtocol(name string) (Protocol, error) {
+	switch name {
+	case "ipfix":
+		return ProtocolIPFIX, nil
+	case "netflow9":
+		return ProtocolNetFlow9, nil
+	default:
+		return 0, fmt.Errorf("invalid export protocol %s", name)
+	}
+}
+
+//Parts of the flows, each one with its own lock, so the workers seldom wait for each other to add their packets.
+const flowShards = 64
+
+//A flow is identified by the 5-tuple and the VLAN.
+type flowKey struct {
+	srcIp [16]byte
+	dstIp [16]byte
+	srcPort uint16
+	dstPort uint16
+	vlan uint16
+	protocol uint8
+	ipType uint8
+}
+
+type flow struct {
+	key flowKey
+	srcMac [6]byte
+	dstMac [6]byte
+	first time.Time
+	last time.Time
+	bytes uint64
+	packets uint64
+}
+
+//Keeps the state of the flows seen in the captured packets and sends them to the collector when they expire: after
+//the active timeout since the first packet of the flow (long flows are exported several times) or after the inactive
+//timeout since its last packet. The templates are sent with the first message and every templateRefresh. The flows are
+//split in shards by their key (see flowShards).
+type Exporter struct {
+	conn net.Conn
+	protocol Protocol
+	activeTimeout time.Duration
+	inactiveTimeout time.Duration
+	templateRefresh time.Duration
+	domain uint32
+	shards [flowShards]exporterShard
+	mutex sync.Mutex //Held while the flows are exported, for the sequence, the start and the templates
+	sequence uint32
+	started time.Time
+	lastTemplate time.Time
+	nextExpire int64 //When the packets export the expired flows again, in Unix nanoseconds (used atomically)
+	stop chan bool
+	wg sync.WaitGroup
+	logger *log.Logger
+}
+
+type exporterShard struct {
+	mutex sync.Mutex
+	flows map[flowKey]*flow
+}
+
+//Creates an exporter that sends the flows through UDP to the collector in the given address (host:port).
+func New(addr string, protocol Protocol, activeTimeout time.Duration, inactiveTimeout time.Duration, templateRefresh time.Duration) (*Exporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{
+		conn: conn,
+		protocol: protocol,
+		activeTimeout: activeTimeout,
+		inactiveTimeout: inactiveTimeout,
+		templateRefresh: templateRefresh,
+		domain: uint32(os.Getpid()),
+		started: time.Now(),
+		stop: make(chan bool),
+		logger: log.New(os.Stdout, "[Exporter]: ", log.LstdFlags),
+	}
+	for i := range e.shards {
+		e.shards[i].flows = make(map[flowKey]*flow)
+	}
+	return e, nil
+}
+
+//Gets the shard of the flow, with a FNV-1a hash of its key.
+func (e *Exporter) shardOf(key flowKey) *exporterShard {
+	hash := uint32(2166136261)
+	mix := func(b byte) {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	for i := range key.srcIp {
+		mix(key.srcIp[i])
+		mix(key.dstIp[i])
+	}
+	mix(byte(key.srcPort))
+	mix(byte(key.srcPort >> 8))
+	mix(byte(key.dstPort))
+	mix(byte(key.dstPort >> 8))
+	mix(byte(key.vlan))
+	mix(key.protocol)
+	return &e.shards[hash % flowShards]
+}
+
+//Adds the packet to its flow. Must be called before the packet is modified (reversed), so the flows have the direction
+//of the traffic. Packets without IP are not exported. The time of the flows is the one of the packets, so replays of
+//captures are exported with their times.
+func (e *Exporter) Observe(p *capture.Packet) {
+	if p.IpType == 0 {
+		return
+	}
+
+	key := flowKey{ srcPort: p.SrcPort, dstPort: p.DstPort, vlan: p.VlanID, protocol: p.Protocol, ipType: p.IpType }
+	copy(key.srcIp[:], p.SrcIp.To16())
+	copy(key.dstIp[:], p.DstIp.To16())
+	rate := uint64(1)
+	if p.SamplingRate > 1 {
+		rate = uint64(p.SamplingRate)
+	}
+
+	sh := e.shardOf(key)
+	sh.mutex.Lock()
+	f, ok := sh.flows[key]
+	if !ok {
+		f = &flow{ key: key, first: p.Timestamp }
+		copy(f.srcMac[:], p.SrcMac)
+		copy(f.dstMac[:], p.DstMac)
+		sh.flows[key] = f
+	}
+	f.last = p.Timestamp
+	//Sampled packets represent several packets
+	f.bytes += p.Bytes * rate
+	f.packets += p.PacketCount() * rate
+	sh.mutex.Unlock()
+
+	//Only one of the packets of every second exports the expired flows
+	next := atomic.LoadInt64(&e.nextExpire)
+	if p.Timestamp.UnixNano() >= next {
+		if atomic.CompareAndSwapInt64(&e.nextExpire, next, p.Timestamp.Truncate(time.Second).Add(time.Second).UnixNano()) {
+			e.expire(p.Timestamp, false)
+		}
+	}
+}
+
+//Starts a gorutine that exports the expired flows every second, even if there are no packets. Only for live captures.
+func (e *Exporter) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		timer := time.NewTicker(1 * time.Second)
+		defer timer.Stop()
+		for {
+			select {
+			case <- e.stop:
+				return
+			case now := <- timer.C:
+				e.expire(now, false)
+			}
+		}
+	}()
+}
+
+//Exports all the flows, expired or not, and closes the connection to the collector.
+func (e *Exporter) Close() {
+	e.logger.Println("Closing...")
+	close(e.stop)
+	e.wg.Wait()
+	e.expire(time.Now(), true)
+	_ = e.conn.Close()
+}
+
+//Exports the flows that expired at the given time (or all of them).
+func (e *Exporter) expire(now time.Time, all bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	expired := make([]*flow, 0)
+	for i := range e.shards {
+		sh := &e.shards[i]
+		sh.mutex.Lock()
+		for key, f := range sh.flows {
+			if all || now.Sub(f.last) >= e.inactiveTimeout || now.Sub(f.first) >= e.activeTimeout {
+				expired = append(expired, f)
+				delete(sh.flows, key)
+			}
+		}
+		sh.mutex.Unlock()
+	}
+
+	//The uptime of NetFlow v9 starts with the first flow when replaying older captures
+	for _, f := range expired {
+		if f.first.Before(e.started) {
+			e.started = f.first
+		}
+	}
+
+	//The first templates are sent with the first flows, then they are refreshed even if there are no flows
+	templateDue := e.lastTemplate.IsZero() || now.Sub(e.lastTemplate) >= e.templateRefresh
+	if len(expired) == 0 && (e.lastTemplate.IsZero() || !templateDue) {
+		return
+	}
+
+	b := messageBuilder{ e: e, now: now }
+	if templateDue {
+		b.addTemplates()
+		e.lastTemplate = now
+	}
+	for _, f := range expired {
+		b.addFlow(f)
+	}
+	b.flush()
+}
+
+func (e *Exporter) write(message []byte) {
+	if _, err := e.conn.Write(message); err != nil {
+		e.logger.Println("Could not export the flows:", err)
+	}
+}
@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+func newTestExporter(t *testing.T, protocol Protocol) (*Exporter, *net.UDPConn) {
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{ IP: net.IP{ 127, 0, 0, 1 } })
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+
+	e, err := New(collector.LocalAddr().String(), protocol, 60 * time.Second, 15 * time.Second, 5 * time.Minute)
+	if err != nil {
+		t.Fatal("Could not create the exporter:", err)
+	}
+	return e, collector
+}
+
+func readMessage(t *testing.T, collector *net.UDPConn) []byte {
+	buffer := make([]byte, 65535)
+	_ = collector.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := collector.Read(buffer)
+	if err != nil {
+		t.Fatal("No message was received:", err)
+	}
+	return buffer[:n]
+}
+
+//Gets the sets of the message by their ID.
+func setsOf(message []byte, headerLength int) map[uint16][]byte {
+	sets := make(map[uint16][]byte)
+	message = message[headerLength:]
+	for len(message) >= 4 {
+		length := int(binary.BigEndian.Uint16(message[2:4]))
+		sets[binary.BigEndian.Uint16(message[0:2])] = message[4:length]
+		message = message[length:]
+	}
+	return sets
+}
+
+func packetAt(ts time.Time, srcPort uint16) *capture.Packet {
+	return &capture.Packet{
+		Bytes: 1000,
+		IpType: 4,
+		Protocol: 6,
+		SrcIp: net.IP{ 192, 168, 1, 10 },
+		DstIp: net.IP{ 1, 1, 1, 1 },
+		SrcPort: srcPort,
+		DstPort: 443,
+		Timestamp: ts,
+	}
+}
+
+func TestCloseExportsAllTheFlowsWithTemplates(t *testing.T) {
+	e, collector := newTestExporter(t, ProtocolIPFIX)
+	defer collector.Close()
+	now := time.Now()
+
+	e.Observe(packetAt(now, 50000))
+	e.Observe(packetAt(now, 50000))
+	e.Close()
+	message := readMessage(t, collector)
+
+	if binary.BigEndian.Uint16(message[0:2]) != 10 || int(binary.BigEndian.Uint16(message[2:4])) != len(message) {
+		t.Fatal("The IPFIX header is not the expected one:", message[:ipfixHeaderLength])
+	}
+
+	sets := setsOf(message, ipfixHeaderLength)
+	if _, ok := sets[2]; !ok {
+		t.Error("The templates were not sent")
+	}
+	record, ok := sets[templateIPv4]
+	if !ok {
+		t.Fatal("The IPv4 flow was not sent")
+	}
+	if !net.IP(record[0:4]).Equal(net.IP{ 192, 168, 1, 10 }) || binary.BigEndian.Uint16(record[9:11]) != 50000 {
+		t.Error("IP or port are not the expected ones:", record[:11])
+	}
+	//After IPs (8), protocol (1), ports (4), VLAN (2) and MACs (12)
+	if bytes := binary.BigEndian.Uint64(record[27:35]); bytes != 2000 {
+		t.Error("Bytes should be 2000, but are", bytes)
+	}
+	if packets := binary.BigEndian.Uint64(record[35:43]); packets != 2 {
+		t.Error("Packets should be 2, but are", packets)
+	}
+}
+
+func TestInactiveFlowsAreExported(t *testing.T) {
+	e, collector := newTestExporter(t, ProtocolNetFlow9)
+	defer collector.Close()
+	now := time.Now()
+
+	e.Observe(packetAt(now, 50000))
+	e.Observe(packetAt(now.Add(20 * time.Second), 50001))
+	message := readMessage(t, collector)
+	e.Close()
+
+	//Two templates and the flow that is inactive since 20 seconds
+	if count := binary.BigEndian.Uint16(message[2:4]); count != 3 {
+		t.Error("There should be 3 records, but there are", count)
+	}
+	record := setsOf(message, netFlow9HeaderLength)[templateIPv4]
+	if len(record) == 0 || binary.BigEndian.Uint16(record[9:11]) != 50000 {
+		t.Error("The inactive flow was not exported:", record)
+	}
+}
+
+func TestActiveFlowsAreExportedAfterTheActiveTimeout(t *testing.T) {
+	e, collector := newTestExporter(t, ProtocolNetFlow9)
+	defer collector.Close()
+	now := time.Now()
+
+	for i := 0; i <= 60; i++ {
+		e.Observe(packetAt(now.Add(time.Duration(i) * time.Second), 50000))
+	}
+	message := readMessage(t, collector)
+	e.Close()
+
+	record := setsOf(message, netFlow9HeaderLength)[templateIPv4]
+	if len(record) == 0 {
+		t.Fatal("The active flow was not exported")
+	}
+	//The last packet is the one that exports the flow
+	if packets := binary.BigEndian.Uint64(record[35:43]); packets != 61 {
+		t.Error("Packets should be 61, but are", packets)
+	}
+}
+
+func TestFlowsObservedFromSeveralGorutines(t *testing.T) {
+	e, collector := newTestExporter(t, ProtocolIPFIX)
+	defer collector.Close()
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := uint16(50000); port < 50010; port++ {
+				e.Observe(packetAt(now, port))
+			}
+		}()
+	}
+	wg.Wait()
+	e.Close()
+
+	//Every flow has the packets of all the gorutines
+	flows := 0
+	for flows < 10 {
+		record := setsOf(readMessage(t, collector), ipfixHeaderLength)[templateIPv4]
+		for ; len(record) >= 59; record = record[59:] {
+			flows++
+			if packets := binary.BigEndian.Uint64(record[35:43]); packets != 4 {
+				t.Error("Packets should be 4, but are", packets)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	templateIPv4 = 256
+	templateIPv6 = 257
+	//The messages are split so they fit in a datagram without fragments
+	maxMessageLength = 1400
+	netFlow9HeaderLength = 20
+	ipfixHeaderLength = 16
+)
+
+//Information elements of the records (the same numbers in NetFlow v9 and IPFIX)
+const (
+	fieldOctetDeltaCount = 1
+	fieldPacketDeltaCount = 2
+	fieldProtocolIdentifier = 4
+	fieldSourceTransportPort = 7
+	fieldSourceIPv4Address = 8
+	fieldDestinationTransportPort = 11
+	fieldDestinationIPv4Address = 12
+	fieldLastSwitched = 21
+	fieldFirstSwitched = 22
+	fieldSourceIPv6Address = 27
+	fieldDestinationIPv6Address = 28
+	fieldSourceMacAddress = 56
+	fieldVlanId = 58
+	fieldDestinationMacAddress = 80
+	fieldFlowStartMilliseconds = 152
+	fieldFlowEndMilliseconds = 153
+)
+
+type templateField struct {
+	id uint16
+	length uint16
+}
+
+//Gets the fields of the template for the type of IP. NetFlow v9 has the times relative to the uptime of the exporter.
+func (e *Exporter) templateFields(ipType uint8) []templateField {
+	fields := make([]templateField, 0, 12)
+	if ipType == 4 {
+		fields = append(fields, templateField{ fieldSourceIPv4Address, 4 }, templateField{ fieldDestinationIPv4Address, 4 })
+	} else {
+		fields = append(fields, templateField{ fieldSourceIPv6Address, 16 }, templateField{ fieldDestinationIPv6Address, 16 })
+	}
+	fields = append(fields,
+		templateField{ fieldProtocolIdentifier, 1 },
+		templateField{ fieldSourceTransportPort, 2 },
+		templateField{ fieldDestinationTransportPort, 2 },
+		templateField{ fieldVlanId, 2 },
+		templateField{ fieldSourceMacAddress, 6 },
+		templateField{ fieldDestinationMacAddress, 6 },
+		templateField{ fieldOctetDeltaCount, 8 },
+		templateField{ fieldPacketDeltaCount, 8 },
+	)
+	if e.protocol == ProtocolIPFIX {
+		fields = append(fields, templateField{ fieldFlowStartMilliseconds, 8 }, templateField{ fieldFlowEndMilliseconds, 8 })
+	} else {
+		fields = append(fields, templateField{ fieldFirstSwitched, 4 }, templateField{ fieldLastSwitched, 4 })
+	}
+	return fields
+}
+
+//Builds the messages for the collector, splitting them when they do not fit in a datagram.
+type messageBuilder struct {
+	e *Exporter
+	now time.Time
+	sets []byte
+	setStart int
+	setId uint16
+	records int
+	dataRecords int
+}
+
+func (b *messageBuilder) headerLength() int {
+	if b.e.protocol == ProtocolIPFIX {
+		return ipfixHeaderLength
+	}
+	return netFlow9HeaderLength
+}
+
+//Adds both templates in their own set. The set ID of the templates is 0 in NetFlow v9 and 2 in IPFIX.
+func (b *messageBuilder) addTemplates() {
+	setId := uint16(0)
+	if b.e.protocol == ProtocolIPFIX {
+		setId = 2
+	}
+
+	for _, ipType := range []uint8{ 4, 6 } {
+		fields := b.e.templateFields(ipType)
+		template := make([]byte, 4 + 4 * len(fields))
+		templateId := uint16(templateIPv4)
+		if ipType == 6 {
+			templateId = templateIPv6
+		}
+		binary.BigEndian.PutUint16(template[0:], templateId)
+		binary.BigEndian.PutUint16(template[2:], uint16(len(fields)))
+		for i, field := range fields {
+			binary.BigEndian.PutUint16(template[4 + 4 * i:], field.id)
+			binary.BigEndian.PutUint16(template[6 + 4 * i:], field.length)
+		}
+		b.add(setId, template)
+	}
+}
+
+//Adds the data record of the flow, in the set of its template.
+func (b *messageBuilder) addFlow(f *flow) {
+	setId := uint16(templateIPv4)
+	srcIp, dstIp := f.key.srcIp[12:], f.key.dstIp[12:]
+	if f.key.ipType != 4 {
+		setId = templateIPv6
+		srcIp, dstIp = f.key.srcIp[:], f.key.dstIp[:]
+	}
+	timeLength := 4
+	if b.e.protocol == ProtocolIPFIX {
+		timeLength = 8
+	}
+
+	//The IPs, the protocol, the ports, the VLAN, the MACs, the counters and the times
+	record := make([]byte, 2 * len(srcIp) + 35 + 2 * timeLength)
+	n := copy(record, srcIp)
+	n += copy(record[n:], dstIp)
+	record[n] = f.key.protocol
+	binary.BigEndian.PutUint16(record[n + 1:], f.key.srcPort)
+	binary.BigEndian.PutUint16(record[n + 3:], f.key.dstPort)
+	binary.BigEndian.PutUint16(record[n + 5:], f.key.vlan)
+	n += 7
+	n += copy(record[n:], f.srcMac[:])
+	n += copy(record[n:], f.dstMac[:])
+	binary.BigEndian.PutUint64(record[n:], f.bytes)
+	binary.BigEndian.PutUint64(record[n + 8:], f.packets)
+	n += 16
+	if b.e.protocol == ProtocolIPFIX {
+		binary.BigEndian.PutUint64(record[n:], uint64(f.first.UnixNano() / int64(time.Millisecond)))
+		binary.BigEndian.PutUint64(record[n + 8:], uint64(f.last.UnixNano() / int64(time.Millisecond)))
+	} else {
+		binary.BigEndian.PutUint32(record[n:], b.e.uptime(f.first))
+		binary.BigEndian.PutUint32(record[n + 4:], b.e.uptime(f.last))
+	}
+	b.add(setId, record)
+	b.dataRecords++
+}
+
+//Adds a record to the set with the ID, opening a new set (and a new message, if it does not fit) when needed.
+func (b *messageBuilder) add(setId uint16, record []byte) {
+	length := b.headerLength() + len(b.sets) + len(record)
+	if b.setId != setId || len(b.sets) == 0 {
+		length += 4
+	}
+	if length > maxMessageLength {
+		b.flush()
+	}
+
+	if b.setId != setId || len(b.sets) == 0 {
+		b.closeSet()
+		b.setStart = len(b.sets)
+		b.setId = setId
+		b.sets = append(b.sets, 0, 0, 0, 0) //The length is filled when closed
+		binary.BigEndian.PutUint16(b.sets[b.setStart:], setId)
+	}
+	b.sets = append(b.sets, record...)
+	b.records++
+}
+
+func (b *messageBuilder) closeSet() {
+	if len(b.sets) > b.setStart {
+		binary.BigEndian.PutUint16(b.sets[b.setStart + 2:], uint16(len(b.sets) - b.setStart))
+	}
+}
+
+//Sends the message with the sets added so far.
+func (b *messageBuilder) flush() {
+	if len(b.sets) == 0 {
+		return
+	}
+	b.closeSet()
+
+	e := b.e
+	message := make([]byte, b.headerLength(), b.headerLength() + len(b.sets))
+	if e.protocol == ProtocolIPFIX {
+		//The sequence is the number of data records sent before this message
+		binary.BigEndian.PutUint16(message[0:], uint16(ProtocolIPFIX))
+		binary.BigEndian.PutUint16(message[2:], uint16(ipfixHeaderLength + len(b.sets)))
+		binary.BigEndian.PutUint32(message[4:], uint32(b.now.Unix()))
+		binary.BigEndian.PutUint32(message[8:], e.sequence)
+		binary.BigEndian.PutUint32(message[12:], e.domain)
+		e.sequence += uint32(b.dataRecords)
+	} else {
+		//The sequence is the number of messages sent before this one
+		binary.BigEndian.PutUint16(message[0:], uint16(ProtocolNetFlow9))
+		binary.BigEndian.PutUint16(message[2:], uint16(b.records))
+		binary.BigEndian.PutUint32(message[4:], e.uptime(b.now))
+		binary.BigEndian.PutUint32(message[8:], uint32(b.now.Unix()))
+		binary.BigEndian.PutUint32(message[12:], e.sequence)
+		binary.BigEndian.PutUint32(message[16:], e.domain)
+		e.sequence++
+	}
+	e.write(append(message, b.sets...))
+
+	b.sets = b.sets[:0]
+	b.setStart = 0
+	b.records = 0
+	b.dataRecords = 0
+}
+
+//Milliseconds since the exporter started, used by NetFlow v9 for the times.
+func (e *Exporter) uptime(t time.Time) uint32 {
+	if t.Before(e.started) {
+		return 0
+	}
+	return uint32(t.Sub(e.started) / time.Millisecond)
+}
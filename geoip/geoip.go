@@ -0,0 +1,222 @@
+package geoip
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+//The files are checked for changes every this time
+const reloadCheckInterval = 30 * time.Second
+
+//Locations remembered at the same time. When it is full, some of them are forgotten to make room for the new ones.
+const maxCachedLocations = 1 << 16
+
+//The fields of the records of the country (or city) databases that are used.
+type countryRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+//The fields of the records of the ASN databases.
+type asnRecord struct {
+	Number uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+//Where an IP is, as known by the databases. The fields are empty (or 0) when they are not known.
+type Location struct {
+	Country string //ISO 3166-1 code, like ES
+	Asn uint32 //Number of the autonomous system, like 2906
+	AsOrganization string //Organization of the autonomous system, like NETFLIX-ASN
+}
+
+//A MaxMind DB file that is loaded again when it changes on disk.
+type watchedFile struct {
+	path string
+	mutex sync.RWMutex
+	db *maxminddb.Reader
+	modTime time.Time
+	size int64
+}
+
+//Looks up the country and the autonomous system of the IPs in local MaxMind DB files (GeoLite2 or DB-IP, Country or
+//City for the countries and ASN for the autonomous systems). The files are loaded in memory and loaded again when they
+//change (for example, when geoipupdate downloads a new version). Safe to use from several gorutines.
+type Database struct {
+	country *watchedFile
+	asn *watchedFile
+	cacheMutex sync.RWMutex
+	cache map[[16]byte]Location
+	stop chan bool
+	logger *log.Logger
+}
+
+//Loads the database of countries and the database of autonomous systems. Any of them can be empty, but not both.
+func Open(countryPath string, asnPath string) (*Database, error) {
+	if countryPath == "" && asnPath == "" {
+		return nil, errors.New("there are no databases to load")
+	}
+
+	d := &Database{
+		cache: make(map[[16]byte]Location),
+		stop: make(chan bool, 1),
+		logger: log.New(os.Stdout, "[GeoIP]: ", log.LstdFlags),
+	}
+	var err error
+	if d.country, err = d.open(countryPath); err != nil {
+		return nil, err
+	}
+	if d.asn, err = d.open(asnPath); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+//Loads the file, if there is one.
+func (d *Database) open(path string) (*watchedFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file := &watchedFile{ path: path }
+	if _, err := file.reload(); err != nil {
+		return nil, err
+	}
+	d.logger.Printf("Loaded %s (%s)", path, file.db.Metadata.DatabaseType)
+	return file, nil
+}
+
+//Starts checking the files for changes in a gorutine, until the database is closed.
+func (d *Database) Start() {
+	go func() {
+		ticker := time.NewTicker(reloadCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <- ticker.C:
+				d.reload()
+			case <- d.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stops checking the files for changes.
+func (d *Database) Close() {
+	select {
+	case d.stop <- true:
+	default:
+	}
+}
+
+//Loads again the files that have changed. If a file cannot be loaded (it may be being written), the previous version
+//is kept and it is tried again later.
+func (d *Database) reload() {
+	reloaded := false
+	for _, file := range []*watchedFile{ d.country, d.asn } {
+		if file == nil {
+			continue
+		}
+		changed, err := file.reload()
+		if err != nil {
+			d.logger.Println("Could not reload", file.path, "-", err)
+		} else if changed {
+			d.logger.Println("Reloaded", file.path)
+			reloaded = true
+		}
+	}
+
+	if reloaded {
+		d.cacheMutex.Lock()
+		d.cache = make(map[[16]byte]Location)
+		d.cacheMutex.Unlock()
+	}
+}
+
+//Gets the location of the IP. Private and unknown IPs have an empty location.
+func (d *Database) Lookup(ip net.IP) Location {
+	var key [16]byte
+	copy(key[:], ip.To16())
+	d.cacheMutex.RLock()
+	location, ok := d.cache[key]
+	d.cacheMutex.RUnlock()
+	if ok {
+		return location
+	}
+
+	var country countryRecord
+	if d.country.lookup(ip, &country) {
+		location.Country = country.Country.IsoCode
+		if location.Country == "" {
+			location.Country = country.RegisteredCountry.IsoCode
+		}
+	}
+	var asn asnRecord
+	if d.asn.lookup(ip, &asn) {
+		location.Asn = asn.Number
+		location.AsOrganization = asn.Organization
+	}
+
+	d.cacheMutex.Lock()
+	if len(d.cache) >= maxCachedLocations {
+		//The iteration of the maps starts at a random place, so a random location is forgotten
+		for cached := range d.cache {
+			delete(d.cache, cached)
+			break
+		}
+	}
+	d.cache[key] = location
+	d.cacheMutex.Unlock()
+	return location
+}
+
+//Loads the file if it has changed since the last time (or it was never loaded). Returns whether it was loaded.
+func (f *watchedFile) reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.mutex.RLock()
+	changed := f.db == nil || !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+	f.mutex.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return false, err
+	}
+
+	f.mutex.Lock()
+	f.db, f.modTime, f.size = db, info.ModTime(), info.Size()
+	f.mutex.Unlock()
+	return true, nil
+}
+
+//Reads the record of the IP in the loaded version of the file into the result, which is left empty if the IP is not
+//there. Returns false if there is no file or the record cannot be read.
+func (f *watchedFile) lookup(ip net.IP, result interface{}) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mutex.RLock()
+	db := f.db
+	f.mutex.RUnlock()
+	return db.Lookup(ip, result) == nil
+}
@@ -0,0 +1,299 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+//Marks the beginning of the metadata, at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+//Size of the separator between the search tree and the data section
+const dataSectionSeparator = 16
+
+//Types of the values of the data section that are written
+const (
+	typePointer = 1
+	typeString = 2
+	typeDouble = 3
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap = 7
+	typeArray = 11
+)
+
+//Pointer to a value already written in the data section, to test that they are followed
+type testPointer uint
+
+type testNetwork struct {
+	cidr string
+	record interface{}
+}
+
+type testNode struct {
+	children [2]*testNode
+	leaves [2]int //Offset in the data section + 1, or 0
+	index int
+}
+
+//Writes a MaxMind DB file with IPv6 tree (the IPv4 networks are in ::/96) and the given networks.
+func buildMmdb(t *testing.T, recordSize int, databaseType string, networks []testNetwork) []byte {
+	dataSection := make([]byte, 0)
+	root := &testNode{}
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, bits := ipNet.Mask.Size()
+		ip := ipNet.IP.To16()
+		if bits == 32 {
+			ip = append(make(net.IP, 12), ipNet.IP.To4()...)
+			ones += 96
+		}
+
+		offset := len(dataSection)
+		dataSection = encodeMmdbValue(dataSection, network.record)
+		node := root
+		for i := 0; i < ones - 1; i++ {
+			bit := (ip[i / 8] >> (7 - i % 8)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &testNode{}
+			}
+			node = node.children[bit]
+		}
+		node.leaves[(ip[(ones - 1) / 8] >> (7 - (ones - 1) % 8)) & 1] = offset + 1
+	}
+
+	nodes := []*testNode{ root }
+	for i := 0; i < len(nodes); i++ {
+		nodes[i].index = i
+		for _, child := range nodes[i].children {
+			if child != nil {
+				nodes = append(nodes, child)
+			}
+		}
+	}
+
+	tree := make([]byte, 0)
+	for _, node := range nodes {
+		records := [2]uint32{}
+		for bit := range records {
+			switch {
+			case node.children[bit] != nil:
+				records[bit] = uint32(node.children[bit].index)
+			case node.leaves[bit] != 0:
+				records[bit] = uint32(len(nodes) + dataSectionSeparator + node.leaves[bit] - 1)
+			default:
+				records[bit] = uint32(len(nodes))
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(records[0] >> 16), byte(records[0] >> 8), byte(records[0]))
+			tree = append(tree, byte(records[1] >> 16), byte(records[1] >> 8), byte(records[1]))
+		case 28:
+			tree = append(tree, byte(records[0] >> 16), byte(records[0] >> 8), byte(records[0]))
+			tree = append(tree, byte(records[0] >> 20) & 0xf0 | byte(records[1] >> 24) & 0x0f)
+			tree = append(tree, byte(records[1] >> 16), byte(records[1] >> 8), byte(records[1]))
+		default:
+			tree = append(tree, make([]byte, 8)...)
+			binary.BigEndian.PutUint32(tree[len(tree) - 8:], records[0])
+			binary.BigEndian.PutUint32(tree[len(tree) - 4:], records[1])
+		}
+	}
+
+	file := append(tree, make([]byte, dataSectionSeparator)...)
+	file = append(file, dataSection...)
+	file = append(file, metadataMarker...)
+	return encodeMmdbValue(file, map[string]interface{}{
+		"node_count": uint32(len(nodes)),
+		"record_size": uint16(recordSize),
+		"ip_version": uint16(6),
+		"database_type": databaseType,
+		"binary_format_major_version": uint16(2),
+	})
+}
+
+func encodeMmdbValue(data []byte, value interface{}) []byte {
+	header := func(kind int, size int) {
+		control := byte(size)
+		if size >= 29 {
+			control = 29
+		}
+		if kind > 7 {
+			data = append(data, control, byte(kind - 7))
+		} else {
+			data = append(data, byte(kind) << 5 | control)
+		}
+		if size >= 29 {
+			data = append(data, byte(size - 29))
+		}
+	}
+
+	switch v := value.(type) {
+	case testPointer:
+		data = append(data, typePointer << 5 | byte(v >> 8) & 0x07, byte(v))
+	case string:
+		header(typeString, len(v))
+		data = append(data, v...)
+	case uint16:
+		header(typeUint16, 2)
+		data = append(data, 0, 0)
+		binary.BigEndian.PutUint16(data[len(data) - 2:], v)
+	case uint32:
+		header(typeUint32, 4)
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data) - 4:], v)
+	case float64:
+		header(typeDouble, 8)
+		data = append(data, make([]byte, 8)...)
+	case []interface{}:
+		header(typeArray, len(v))
+		for _, item := range v {
+			data = encodeMmdbValue(data, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		header(typeMap, len(keys))
+		for _, key := range keys {
+			data = encodeMmdbValue(data, key)
+			data = encodeMmdbValue(data, v[key])
+		}
+	}
+	return data
+}
+
+func writeMmdb(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCountryRecord(code string) map[string]interface{} {
+	return map[string]interface{}{
+		"continent": map[string]interface{}{ "code": "EU", "names": map[string]interface{}{ "en": "Europe" } },
+		"country": map[string]interface{}{ "iso_code": code, "geoname_id": uint32(1) },
+		"location": map[string]interface{}{ "latitude": 40.0, "longitude": -3.0 },
+		"subdivisions": []interface{}{ map[string]interface{}{ "iso_code": "M" } },
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, recordSize := range []int{ 24, 28, 32 } {
+		dir := t.TempDir()
+		countryPath := filepath.Join(dir, "country.mmdb")
+		asnPath := filepath.Join(dir, "asn.mmdb")
+		writeMmdb(t, countryPath, buildMmdb(t, recordSize, "GeoLite2-City", []testNetwork{
+			{ "81.0.0.0/8", testCountryRecord("ES") },
+			{ "45.57.0.0/17", map[string]interface{}{ "registered_country": map[string]interface{}{ "iso_code": "US" } } },
+			{ "2a00::/16", testCountryRecord("DE") },
+		}))
+		writeMmdb(t, asnPath, buildMmdb(t, recordSize, "GeoLite2-ASN", []testNetwork{
+			{ "45.57.0.0/17", map[string]interface{}{
+				"autonomous_system_number": uint32(2906),
+				"autonomous_system_organization": "NETFLIX-ASN",
+			} },
+			//The name of the organization is a pointer to the one of the previous record (after the header of the map,
+			//2 keys and the number)
+			{ "2a00:1450::/32", map[string]interface{}{ "autonomous_system_number": uint16(15169), "autonomous_system_organization": testPointer(1 + 25 + 5 + 32) } },
+		}))
+
+		db, err := Open(countryPath, asnPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			ip string
+			location Location
+		}{
+			{ "81.45.1.2", Location{ Country: "ES" } },
+			{ "45.57.1.1", Location{ Country: "US", Asn: 2906, AsOrganization: "NETFLIX-ASN" } },
+			{ "45.57.200.1", Location{} },
+			{ "2a00:1450:4003::1", Location{ Country: "DE", Asn: 15169, AsOrganization: "NETFLIX-ASN" } },
+			{ "192.168.1.10", Location{} },
+			{ "::1", Location{} },
+		}
+		for _, test := range tests {
+			//The second time, the location comes from the cache
+			for i := 0; i < 2; i++ {
+				if location := db.Lookup(net.ParseIP(test.ip)); location != test.location {
+					t.Errorf("Record size %d: the location of %s should be %+v, but it is %+v", recordSize, test.ip, test.location, location)
+				}
+			}
+		}
+	}
+}
+
+func TestOpenFailsWithoutValidFiles(t *testing.T) {
+	if _, err := Open("", ""); err == nil {
+		t.Error("Opening without files should fail")
+	}
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeMmdb(t, path, []byte("not a database"))
+	if _, err := Open(path, ""); err == nil {
+		t.Error("Opening a file that is not a database should fail")
+	}
+	if _, err := Open("", filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("Opening a file that does not exist should fail")
+	}
+}
+
+func TestReloadsWhenTheFileChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeMmdb(t, path, buildMmdb(t, 24, "GeoLite2-Country", []testNetwork{ { "81.0.0.0/8", testCountryRecord("ES") } }))
+	db, err := Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("81.45.1.2")
+	if location := db.Lookup(ip); location.Country != "ES" {
+		t.Fatalf("The country should be ES, but it is %q", location.Country)
+	}
+
+	//A file that is being written is not loaded, the previous version is kept
+	writeMmdb(t, path, []byte("not yet"))
+	db.reload()
+	if location := db.Lookup(ip); location.Country != "ES" {
+		t.Errorf("The previous version should be kept, but the country is %q", location.Country)
+	}
+
+	writeMmdb(t, path, buildMmdb(t, 24, "GeoLite2-Country", []testNetwork{ { "81.0.0.0/8", testCountryRecord("PT") } }))
+	//The modification time may have the same value if the file is written fast
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	db.reload()
+	if location := db.Lookup(ip); location.Country != "PT" {
+		t.Errorf("The new version should be loaded, but the country is %q", location.Country)
+	}
+}
+
+func TestCacheIsBounded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeMmdb(t, path, buildMmdb(t, 24, "GeoLite2-Country", []testNetwork{ { "10.0.0.0/8", testCountryRecord("ES") } }))
+	db, err := Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxCachedLocations + 10; i++ {
+		if location := db.Lookup(net.IPv4(10, byte(i >> 16), byte(i >> 8), byte(i))); location.Country != "ES" {
+			t.Fatalf("The country should be ES, but it is %q", location.Country)
+		}
+	}
+	if len(db.cache) != maxCachedLocations {
+		t.Error("There should be", maxCachedLocations, "cached locations, but there are", len(db.cache))
+	}
+}
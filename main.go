@@ -7,11 +7,18 @@ import (
 	"os/signal"
 	"flag"
 	"fmt"
-	"github.com/melchor629/speedy/capture/pcap"
+	"net"
+	"strings"
+	"time"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/capture/decoder"
+	"github.com/melchor629/speedy/capture/flow"
 	"github.com/melchor629/speedy/storage"
 	"github.com/melchor629/speedy/database"
 	"github.com/melchor629/speedy/database/influxdb"
 	"github.com/melchor629/speedy/database/timescaledb"
+	"github.com/melchor629/speedy/exporter"
+	"github.com/melchor629/speedy/geoip"
 )
 
 type dbImplFactoryFunction func (host string, dbName string, user string, pass string) (database.Database, error)
@@ -20,8 +27,60 @@ var dbImpl = map[string]dbImplFactoryFunction{
 	"timescaledb": timescaledb.Factory,
 }
 
+type captureImplFactoryFunction func (device string, fanout int) (capture.Context, error)
+type captureImplementation struct {
+	factory captureImplFactoryFunction
+	interfaces func () ([]string, error)
+}
+
+//The capture implementations are registered by the capture_*.go files, as some of them are only available in some
+//platforms or builds (build with the nopcap tag to leave libpcap out).
+var captureImpl = map[string]captureImplementation{}
+var defaultCaptureImpl = ""
+
+type fileImplFactoryFunction func (file string, mac net.HardwareAddr) (capture.Context, error)
+var fileImpl fileImplFactoryFunction
+
+type hotplugImplFactoryFunction func (patterns []string, factory func(device string) (capture.Context, error)) (capture.Context, error)
+var hotplugImpl hotplugImplFactoryFunction
+
 func main() {
-	deviceArg := flag.String("device", "", "Selects the NIC where to listen to and grab statistics")
+	deviceArg := flag.String("device", "", "Selects the NIC (or a comma separated list of NICs) where to listen to and grab statistics")
+	fileArg := flag.String("file", "", "Replays the traffic of a pcap or pcapng file instead of capturing from a NIC")
+	collectorArg := flag.String("collector", "", "Collects sFlow, NetFlow and IPFIX in the given UDP address (:2055) instead of capturing from a NIC")
+	macArg := flag.String("mac", "", "MAC address of the device that captured the traffic of the file (only with -file)")
+	hotplugArg := flag.Bool("hotplug", false, "Waits for the interfaces of -device (which can be globs like br-*) and captures them while they are up")
+	captureImplArg := flag.String("capture", defaultCaptureImpl, "Type of the capture implementation")
+	fanoutArg := flag.Int("fanout", 1, "Number of decoding gorutines per NIC (and sockets for afpacket)")
+	workersArg := flag.Int("workers", 1, "Number of gorutines that count the decoded packets")
+	topPortsArg := flag.Int("top-ports", 5, "Number of remote ports of every device stored every second, the ones with more traffic")
+	filterArg := flag.String("filter", "", "BPF expression to restrict the captured traffic (tcpdump syntax)")
+	excludeNetArg := flag.String("exclude-net", "", "Comma separated list of CIDRs whose traffic will not be counted")
+	excludeMacArg := flag.String("exclude-mac", "", "Comma separated list of MACs whose traffic will not be counted")
+	excludePortArg := flag.String("exclude-port", "", "Comma separated list of TCP/UDP ports whose traffic will not be counted")
+	localNetArg := flag.String("local-net", "", "Comma separated list of CIDRs of the local devices, to know the direction of the traffic")
+	gatewayMacArg := flag.String("gateway-mac", "", "Comma separated list of MACs of the gateways, to know the direction of the traffic")
+	mirrorArg := flag.Bool("mirror", false, "The NIC is connected to a mirror (SPAN) port, both ends of the traffic are credited")
+	uplinkNetArg := flag.String("uplink-net", "", "Comma separated list of CIDRs of the Internet side (only with -mirror)")
+	uplinkMacArg := flag.String("uplink-mac", "", "Comma separated list of MACs of the uplinks to the Internet (only with -mirror)")
+	sampleRateArg := flag.Uint("sample-rate", 1, "Only decodes 1 of every N packets and scales the numbers back (1 to decode all of them)")
+	sampleModeArg := flag.String("sample-mode", "count", "How the packets are sampled: count (every Nth packet) or random (with probability 1/N)")
+	sampleAdaptiveArg := flag.Bool("sample-adaptive", false, "Raises the sample rate when the packets cannot be processed fast enough")
+	exportArg := flag.String("export", "", "Exports the traffic as flows to the collector in the given address (host:port)")
+	exportProtocolArg := flag.String("export-protocol", "ipfix", "Protocol of the exported flows: ipfix or netflow9")
+	exportActiveArg := flag.Duration("export-active-timeout", 60 * time.Second, "Long flows are exported every this time")
+	exportInactiveArg := flag.Duration("export-inactive-timeout", 15 * time.Second, "Flows without packets for this time are exported")
+	exportTemplateArg := flag.Duration("export-template-refresh", 5 * time.Minute, "The templates are sent again every this time")
+	dnsArg := flag.Bool("dns", false, "Reads the DNS responses and the TLS/QUIC server names to store the traffic of every device by remote domain")
+	topDomainsArg := flag.Int("top-domains", 5, "Number of remote domains of every device stored every second, the ones with more traffic (with -dns)")
+	geoipDbArg := flag.String("geoip-db", "", "MaxMind DB file (.mmdb) of countries, to store the traffic of every device by remote country")
+	asnDbArg := flag.String("asn-db", "", "MaxMind DB file (.mmdb) of autonomous systems, to store the traffic of every device by remote AS")
+	topAsnsArg := flag.Int("top-asns", 5, "Number of remote autonomous systems of every device stored every second, the ones with more traffic (with -asn-db)")
+	applicationsArg := flag.String("applications", "", "File with the rules that assign an application and a category to the traffic, to store it by category")
+	flowsArg := flag.Bool("flows", false, "Tracks every flow (5-tuple and VLAN) and stores its record when it ends")
+	flowIdleArg := flag.Duration("flow-idle-timeout", 15 * time.Second, "Flows without packets for this time end")
+	flowActiveArg := flag.Duration("flow-active-timeout", 60 * time.Second, "Long flows are stored every this time")
+	accountingArg := flag.String("accounting", "l3", "Which bytes are counted: l2 (whole frames), l3 (IP packets) or payload (TCP/UDP payload)")
 	dbHostArg := flag.String("db-url", "http://localhost:8086", "The URL to the database")
 	dbUserArg := flag.String("db-user", "", "The username to the database, empty for nothing")
 	dbPassArg := flag.String("db-pass", "", "The password to the database, empty for nothing")
@@ -30,16 +89,34 @@ func main() {
 	help := flag.String("help", "", "More help over a command")
 	flag.Parse()
 
-	nics, err := pcap.GetActiveInterfaces()
+	captureImplementation, captureImplFound := captureImpl[*captureImplArg]
+	if !captureImplFound {
+		fmt.Println("Invalid capture implementation:", *captureImplArg)
+		fmt.Println("Available ones are:")
+		for key := range captureImpl {
+			fmt.Println("  -", key)
+		}
+		os.Exit(1)
+	}
+
+	nics, err := captureImplementation.interfaces()
 
 	if help != nil && *help != "" {
 		switch *help {
 		case "device":
 			fmt.Println("Selects the network interface in which the utility will inspect to.")
+			fmt.Println("Several interfaces can be captured at once separating them with commas (eth0,wlan0).")
+			fmt.Println("With -hotplug, the interfaces are captured when they come up and they can be globs (br-*).")
 			fmt.Println("Here you have a list of network interfaces:")
 			for _, nic := range nics {
 				fmt.Println("  -", nic)
 			}
+		case "capture":
+			fmt.Println("Selects the implementation used to capture the traffic.")
+			fmt.Println("Available implementations are:")
+			for key := range captureImpl {
+				fmt.Println("  -", key)
+			}
 		case "db":
 			fmt.Println("Selects an implementation of a database.")
 			fmt.Println("Available implementations are:")
@@ -52,7 +129,50 @@ func main() {
 		os.Exit(0)
 	}
 
-	if deviceArg == nil || *deviceArg == "" {
+	if *sampleModeArg != "count" && *sampleModeArg != "random" {
+		log.Fatal("Invalid sample mode: ", *sampleModeArg)
+	}
+	var sampler *capture.Sampler
+	if *sampleRateArg > 1 || *sampleAdaptiveArg {
+		sampler = capture.NewSampler(uint32(*sampleRateArg), *sampleModeArg == "random", *sampleAdaptiveArg)
+	}
+
+	//The names are only read from the packets if they are stored
+	decoderOptions := decoder.Options{ IgnoreDnsAnswers: !*dnsArg, IgnoreServerNames: !*dnsArg && !*flowsArg }
+
+	var context capture.Context
+	if *collectorArg != "" {
+		//The flows have no MAC of the capture device, the direction is known by the local networks
+		if *localNetArg == "" && !*mirrorArg {
+			log.Fatal("The local networks must be given with -local-net to collect flows")
+		}
+
+		context, err = flow.New(*collectorArg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyDecoderOptions(context, decoderOptions)
+		applySampler(context, sampler)
+	} else if fileArg != nil && *fileArg != "" {
+		mac, err := net.ParseMAC(*macArg)
+		if err != nil {
+			log.Fatal("Invalid or missing MAC address for the file: ", err)
+		}
+
+		if fileImpl == nil {
+			log.Fatal("Replaying files is not available in this build")
+		}
+
+		//Creates the replayer
+		context, err = fileImpl(*fileArg, mac)
+		if err != nil {
+			log.Fatal(err)
+		}
+		checkLocalNetworks(*fileArg, context, *localNetArg, *mirrorArg)
+		applyDecoderOptions(context, decoderOptions)
+		applyFilter(context, *filterArg)
+		applySampler(context, sampler)
+	} else if deviceArg == nil || *deviceArg == "" {
 		fmt.Println("No device specified.")
 		if err != nil {
 			log.Fatalf("Could not retreive the network interfaces:\n%s", err)
@@ -63,23 +183,63 @@ func main() {
 			fmt.Println("  -", nic)
 		}
 		os.Exit(1)
+	} else if *hotplugArg {
+		if hotplugImpl == nil {
+			log.Fatal("Waiting for the interfaces is not available in this platform")
+		}
+
+		//The interfaces whose direction of the traffic cannot be known are not captured, as with -device
+		context, err = hotplugImpl(strings.Split(*deviceArg, ","), func(device string) (capture.Context, error) {
+			deviceContext, err := captureImplementation.factory(device, *fanoutArg)
+			if err != nil {
+				return nil, err
+			}
+			if err := localNetworksError(device, deviceContext, *localNetArg, *mirrorArg); err != nil {
+				deviceContext.Close()
+				return nil, err
+			}
+			applyDecoderOptions(deviceContext, decoderOptions)
+			return deviceContext, nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyFilter(context, *filterArg)
+		applySampler(context, sampler)
 	} else {
-		found := false
-		for _, nic := range nics {
-			if *deviceArg == nic {
-				found = true
+		devices := strings.Split(*deviceArg, ",")
+		for _, device := range devices {
+			found := false
+			for _, nic := range nics {
+				if device == nic {
+					found = true
+				}
+			}
+
+			if !found {
+				log.Fatal("The interface ", device, " doesn't exist or is inactive")
 			}
 		}
 
-		if !found {
-			log.Fatal("The interface", *deviceArg, "doesn't exist or is inactive")
+		//Creates the capturer, one for each device
+		contexts := make(map[string]capture.Context)
+		for _, device := range devices {
+			deviceContext, err := captureImplementation.factory(device, *fanoutArg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			checkLocalNetworks(device, deviceContext, *localNetArg, *mirrorArg)
+			applyDecoderOptions(deviceContext, decoderOptions)
+			applyFilter(deviceContext, *filterArg)
+			applySampler(deviceContext, sampler)
+			contexts[device] = deviceContext
 		}
-	}
 
-	//Creates the capturer using libpcap
-	context, err := pcap.New(*deviceArg)
-	if err != nil {
-		log.Fatal(err)
+		if len(devices) == 1 {
+			context = contexts[devices[0]]
+		} else {
+			context = capture.NewMulti(contexts)
+		}
 	}
 	defer context.Close() //Close the context, but only when we decide to end the main
 
@@ -99,15 +259,156 @@ func main() {
 	}
 	defer db.Close() //Same as before
 
+	exclusionRules, err := storage.ParseExclusionRules(*excludeNetArg, *excludeMacArg, *excludePortArg)
+	if err != nil {
+		log.Fatal("Invalid exclusion rules: ", err)
+	}
+
+	classifier, err := storage.ParseDirectionClassifier(*localNetArg, *gatewayMacArg)
+	if err != nil {
+		log.Fatal("Invalid local networks or gateway MACs: ", err)
+	}
+
+	accounting, err := storage.ParseAccounting(*accountingArg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	//Temporal storage
-	mem := storage.Storage{}
-	go mem.Start(context, db)
+	mem := storage.Storage{ Accounting: accounting, Workers: *workersArg, TopPorts: *topPortsArg }
+	if !exclusionRules.IsEmpty() {
+		mem.ExclusionRules = exclusionRules
+	}
+	if !classifier.IsEmpty() {
+		mem.Classifier = classifier
+	}
+	if *mirrorArg {
+		mirror, err := storage.ParseMirrorClassifier(*uplinkNetArg, *uplinkMacArg)
+		if err != nil {
+			log.Fatal("Invalid uplink networks or MACs: ", err)
+		}
+		if mirror.IsEmpty() {
+			log.Println("Mirror mode without -uplink-net nor -uplink-mac, all the traffic will be counted as local")
+		}
+		mem.Mirror = mirror
+	}
+	if *exportArg != "" {
+		protocol, err := exporter.ParseProtocol(*exportProtocolArg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		flowExporter, err := exporter.New(*exportArg, protocol, *exportActiveArg, *exportInactiveArg, *exportTemplateArg)
+		if err != nil {
+			log.Fatal("Could not create the flow exporter: ", err)
+		}
+		//When replaying, the flows expire with the time of the packets
+		if !capture.IsOffline(context) {
+			flowExporter.Start()
+		}
+		defer flowExporter.Close()
+		mem.Observer = flowExporter
+	}
+
+	if *dnsArg {
+		mem.Domains = storage.NewDomainCache()
+		mem.TopDomains = *topDomainsArg
+	}
+	if *flowsArg {
+		mem.Flows = storage.NewFlowTable(*flowIdleArg, *flowActiveArg)
+	}
+	if *applicationsArg != "" {
+		applications, err := storage.LoadApplicationRules(*applicationsArg)
+		if err != nil {
+			log.Fatal("Invalid application rules: ", err)
+		}
+		//The conditions that cannot match would leave that traffic without application, or in the wrong one
+		if applications.UsesAsns() && *asnDbArg == "" {
+			log.Fatal("The application rules have asn= conditions, but there is no ASN database (-asn-db)")
+		}
+		if applications.UsesDomains() && !*dnsArg {
+			log.Fatal("The application rules have domain= conditions, but the domains are not read (-dns)")
+		}
+		mem.Applications = applications
+	}
+	if *geoipDbArg != "" || *asnDbArg != "" {
+		locations, err := geoip.Open(*geoipDbArg, *asnDbArg)
+		if err != nil {
+			log.Fatal("Could not load the GeoIP databases: ", err)
+		}
+		locations.Start()
+		defer locations.Close()
+		mem.Locator = locations
+		mem.TopAsns = *topAsnsArg
+	}
+
+	done := make(chan bool, 1)
+	go func() {
+		mem.Start(context, db)
+		done <- true
+	}()
 
-	//Wait for SIGINT
+	//Wait for SIGINT or for the end of the replay
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
-	<- c
-	log.Println("Received SIGINT, closing...")
+	select {
+	case <- c:
+		log.Println("Received SIGINT, closing...")
+	case <- done:
+		log.Println("No more packets to process, closing...")
+	}
 
 	//Now all defer statements will be executed :)
 }
+
+//Without MAC addresses, the direction of the traffic is known by the networks of the interface. If there are none (the
+//interface has no addresses or the traffic comes from a file), all the traffic would be transit, so it is not started.
+func checkLocalNetworks(source string, context capture.Context, localNet string, mirror bool) {
+	if err := localNetworksError(source, context, localNet, mirror); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//Gets why the direction of the traffic of the context cannot be known (see checkLocalNetworks), or nil if it can.
+func localNetworksError(source string, context capture.Context, localNet string, mirror bool) error {
+	if len(context.GetMAC()) == 0 && len(capture.Networks(context)) == 0 && localNet == "" && !mirror {
+		return fmt.Errorf("%s has no MAC addresses nor networks to know the direction of the traffic, the local networks must be given with -local-net", source)
+	}
+	return nil
+}
+
+//Applies the BPF filter to the context (if there is a filter), or stops the utility if it cannot be done.
+func applyFilter(context capture.Context, filter string) {
+	if filter == "" {
+		return
+	}
+
+	filterable, ok := context.(capture.FilterableContext)
+	if !ok {
+		log.Fatal("The capture implementation does not support filters")
+	}
+
+	if err := filterable.SetFilter(filter); err != nil {
+		log.Fatal("Invalid filter: ", err)
+	}
+}
+
+//The contexts without decoders (or whose decoders cannot be configured) read everything, which is only slower
+func applyDecoderOptions(context capture.Context, options decoder.Options) {
+	if configurable, ok := context.(decoder.ConfigurableContext); ok {
+		configurable.SetDecoderOptions(options)
+	}
+}
+
+func applySampler(context capture.Context, sampler *capture.Sampler) {
+	if sampler == nil {
+		return
+	}
+
+	samplingContext, ok := context.(capture.SamplingContext)
+	if !ok {
+		log.Fatal("The capture implementation does not support sampling")
+	}
+
+	samplingContext.SetSampler(sampler.Copy())
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Which bytes of the packets are counted.
+type Accounting uint8
+
+const (
+	//The IP packet, without the link layer headers (the default)
+	AccountingL3 Accounting = iota
+	//The whole frame as seen in the wire, with the link layer headers
+	AccountingL2
+	//Only the TCP/UDP payload, the rest of the packets count as zero
+	AccountingPayload
+)
+
+//Parses the accounting mode: l2, l3 or payload.
+func ParseAccounting(mode string) (Accounting, error) {
+	switch mode {
+	case "l3", "":
+		return AccountingL3, nil
+	case "l2":
+		return AccountingL2, nil
+	case "payload":
+		return AccountingPayload, nil
+	default:
+		return AccountingL3, fmt.Errorf("invalid accounting mode %s", mode)
+	}
+}
+
+func (a Accounting) String() string {
+	switch a {
+	case AccountingL2:
+		return "l2"
+	case AccountingPayload:
+		return "payload"
+	default:
+		return "l3"
+	}
+}
+
+//Gets the bytes of the packet that are counted in this mode.
+func (a Accounting) BytesOf(p *capture.Packet) uint64 {
+	switch a {
+	case AccountingL2:
+		return p.FrameBytes
+	case AccountingPayload:
+		return p.DataBytes
+	default:
+		return p.Bytes
+	}
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"testing"
+	"github.com/melchor629/speedy/capture"
+)
+
+func TestParseAccounting(t *testing.T) {
+	for mode, expected := range map[string]Accounting{ "l2": AccountingL2, "l3": AccountingL3, "payload": AccountingPayload } {
+		accounting, err := ParseAccounting(mode)
+		if err != nil || accounting != expected {
+			t.Error("Mode", mode, "should be", expected, "but is", accounting, err)
+		}
+	}
+
+	if _, err := ParseAccounting("l7"); err == nil {
+		t.Error("An error was expected")
+	}
+}
+
+func TestAccountingBytesOf(t *testing.T) {
+	packet := capture.Packet{ FrameBytes: 1514, Bytes: 1500, DataBytes: 1448 }
+
+	if b := AccountingL2.BytesOf(&packet); b != 1514 {
+		t.Error("L2 bytes should be 1514, but are", b)
+	}
+	if b := AccountingL3.BytesOf(&packet); b != 1500 {
+		t.Error("L3 bytes should be 1500, but are", b)
+	}
+	if b := AccountingPayload.BytesOf(&packet); b != 1448 {
+		t.Error("Payload bytes should be 1448, but are", b)
+	}
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"github.com/melchor629/speedy/capture"
+)
+
+//The application and the category of some traffic, like netflix and video-streaming.
+type Application struct {
+	Name string
+	Category string
+}
+
+//A range of remote ports, of any protocol if anyProtocol.
+type portRange struct {
+	anyProtocol bool
+	protocol capture.L4Protocol
+	first uint16
+	last uint16
+}
+
+//A rule matches when all its conditions match, and a condition matches when any of its values matches. A rule without
+//conditions matches everything.
+type applicationRule struct {
+	application Application
+	protocols []capture.L4Protocol
+	ports []portRange
+	networks []*net.IPNet
+	asns []uint32
+	domains []string
+}
+
+//What is known of the remote end of some traffic, to classify it.
+type remoteEnd struct {
+	protocol capture.L4Protocol
+	ip net.IP
+	port uint16 //TCP/UDP port, or 0
+	asn uint32 //Number of the autonomous system, or 0 if it is not known
+	domain string //Name of the remote end (see DomainCache), or empty if it is not known
+}
+
+//The remote end as the key of the cache of the applications.
+type remoteEndKey struct {
+	protocol capture.L4Protocol
+	ip [16]byte
+	port uint16
+	asn uint32
+	domain string
+}
+
+//Applications remembered at the same time. When it is full, some of them are forgotten to make room for the new ones.
+const maxCachedApplications = 1 << 16
+
+//Rules that assign an application and a category to the traffic, by the remote end of the traffic. The first rule that
+//matches wins, and the traffic that matches none has no application. The application of every remote end is remembered,
+//so the rules are not checked for every packet. Safe to use from several gorutines.
+type ApplicationRules struct {
+	rules []applicationRule
+	cacheMutex sync.RWMutex
+	cache map[remoteEndKey]Application
+}
+
+//Reads the rules of the file (see ParseApplicationRules).
+func LoadApplicationRules(path string) (*ApplicationRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseApplicationRules(file)
+}
+
+//Parses the rules, one per line: the application, the category and the conditions, separated by spaces. Every condition
+//is a key and a comma separated list of values: protocol=tcp,udp (tcp, udp, icmp, icmpv6 or other), port=443,udp/3478,
+//27015-27050 (remote ports, of a protocol or of any of them), net=23.246.0.0/18 (remote networks), asn=2906,AS40027
+//(remote autonomous systems) and domain=netflix.com,nflxvideo.net (remote domains and their subdomains). Empty lines and
+//the ones that start with # are ignored.
+func ParseApplicationRules(reader io.Reader) (*ApplicationRules, error) {
+	rules := &ApplicationRules{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: the rule has no category", line)
+		}
+
+		rule := applicationRule{ application: Application{ fields[0], fields[1] } }
+		for _, condition := range fields[2:] {
+			if err := rule.parseCondition(condition); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		rules.rules = append(rules.rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *applicationRule) parseCondition(condition string) error {
+	key, list, ok := cut(condition, "=")
+	values := splitList(list)
+	if !ok || len(values) == 0 {
+		return fmt.Errorf("invalid condition %s", condition)
+	}
+
+	for _, value := range values {
+		switch key {
+		case "protocol":
+			protocol, ok := parseL4Protocol(value)
+			if !ok {
+				return fmt.Errorf("invalid protocol %s", value)
+			}
+			r.protocols = append(r.protocols, protocol)
+		case "port":
+			ports, err := parsePortRange(value)
+			if err != nil {
+				return err
+			}
+			r.ports = append(r.ports, ports)
+		case "net":
+			_, ipNet, err := net.ParseCIDR(value)
+			if err != nil {
+				return err
+			}
+			r.networks = append(r.networks, ipNet)
+		case "asn":
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+			if err != nil || asn == 0 {
+				return fmt.Errorf("invalid AS %s", value)
+			}
+			r.asns = append(r.asns, uint32(asn))
+		case "domain":
+			domain := strings.Trim(strings.TrimPrefix(strings.ToLower(value), "*."), ".")
+			if domain == "" {
+				return fmt.Errorf("invalid domain %s", value)
+			}
+			r.domains = append(r.domains, domain)
+		default:
+			return fmt.Errorf("unknown condition %s", key)
+		}
+	}
+	return nil
+}
+
+func parseL4Protocol(name string) (capture.L4Protocol, bool) {
+	for protocol := capture.L4Protocol(0); protocol < capture.L4ProtocolCount; protocol++ {
+		if protocol.String() == strings.ToLower(name) {
+			return protocol, true
+		}
+	}
+	return 0, false
+}
+
+//Parses a port (443) or a range (27015-27050), optionally with the protocol (udp/3478).
+func parsePortRange(value string) (portRange, error) {
+	ports := portRange{ anyProtocol: true }
+	if name, rest, ok := cut(value, "/"); ok {
+		protocol, ok := parseL4Protocol(name)
+		if !ok || (protocol != capture.L4Tcp && protocol != capture.L4Udp) {
+			return ports, fmt.Errorf("invalid protocol of the port %s", value)
+		}
+		ports.anyProtocol, ports.protocol, value = false, protocol, rest
+	}
+
+	first, last, isRange := cut(value, "-")
+	if !isRange {
+		last = first
+	}
+	firstPort, err := strconv.ParseUint(first, 10, 16)
+	if err != nil || firstPort == 0 {
+		return ports, fmt.Errorf("invalid port %s", value)
+	}
+	lastPort, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || lastPort < firstPort {
+		return ports, fmt.Errorf("invalid port %s", value)
+	}
+	ports.first, ports.last = uint16(firstPort), uint16(lastPort)
+	return ports, nil
+}
+
+//Splits the value around the first separator, if it has one.
+//Returns true if some rule has conditions on the autonomous systems, which are only known with the ASN database.
+func (r *ApplicationRules) UsesAsns() bool {
+	for i := range r.rules {
+		if len(r.rules[i].asns) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns true if some rule has conditions on the domains, which are only known when the DNS responses are read.
+func (r *ApplicationRules) UsesDomains() bool {
+	for i := range r.rules {
+		if len(r.rules[i].domains) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func cut(value string, separator string) (string, string, bool) {
+	if i := strings.Index(value, separator); i >= 0 {
+		return value[:i], value[i + len(separator):], true
+	}
+	return value, "", false
+}
+
+//Gets the application of the traffic with the remote end, or an empty one if no rule matches (or there are no rules).
+func (r *ApplicationRules) classify(remote remoteEnd) Application {
+	if r == nil {
+		return Application{}
+	}
+
+	key := remoteEndKey{ protocol: remote.protocol, port: remote.port, asn: remote.asn, domain: remote.domain }
+	copy(key.ip[:], remote.ip.To16())
+	r.cacheMutex.RLock()
+	application, ok := r.cache[key]
+	r.cacheMutex.RUnlock()
+	if ok {
+		return application
+	}
+
+	for i := range r.rules {
+		if r.rules[i].matches(remote) {
+			application = r.rules[i].application
+			break
+		}
+	}
+
+	r.cacheMutex.Lock()
+	if r.cache == nil {
+		r.cache = make(map[remoteEndKey]Application)
+	} else if len(r.cache) >= maxCachedApplications {
+		//The iteration of the maps starts at a random place, so a random application is forgotten
+		for cached := range r.cache {
+			delete(r.cache, cached)
+			break
+		}
+	}
+	r.cache[key] = application
+	r.cacheMutex.Unlock()
+	return application
+}
+
+func (r *applicationRule) matches(remote remoteEnd) bool {
+	if len(r.protocols) != 0 && !r.matchesProtocol(remote) {
+		return false
+	}
+	if len(r.ports) != 0 && !r.matchesPort(remote) {
+		return false
+	}
+	if len(r.networks) != 0 && !r.matchesNetwork(remote) {
+		return false
+	}
+	if len(r.asns) != 0 && !r.matchesAsn(remote) {
+		return false
+	}
+	return len(r.domains) == 0 || r.matchesDomain(remote)
+}
+
+func (r *applicationRule) matchesProtocol(remote remoteEnd) bool {
+	for _, protocol := range r.protocols {
+		if protocol == remote.protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *applicationRule) matchesPort(remote remoteEnd) bool {
+	if remote.port == 0 {
+		return false
+	}
+	for _, ports := range r.ports {
+		if (ports.anyProtocol || ports.protocol == remote.protocol) && remote.port >= ports.first && remote.port <= ports.last {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *applicationRule) matchesNetwork(remote remoteEnd) bool {
+	if remote.ip == nil {
+		return false
+	}
+	for _, network := range r.networks {
+		if network.Contains(remote.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *applicationRule) matchesAsn(remote remoteEnd) bool {
+	for _, asn := range r.asns {
+		if asn == remote.asn {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *applicationRule) matchesDomain(remote remoteEnd) bool {
+	if remote.domain == "" {
+		return false
+	}
+	for _, domain := range r.domains {
+		if remote.domain == domain || strings.HasSuffix(remote.domain, "." + domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"github.com/melchor629/speedy/capture"
+)
+
+const testApplicationRules = `
+# application  category         conditions
+netflix        video-streaming  asn=2906 domain=netflix.com,nflxvideo.net
+netflix        video-streaming  asn=AS40027
+steam          gaming           protocol=udp port=27015-27050
+backups        backups          net=192.168.1.10/32,fd00::10/128 port=tcp/22,tcp/873
+windows-update updates          domain=*.windowsupdate.com.
+`
+
+func TestClassifyUsesTheFirstRuleThatMatches(t *testing.T) {
+	rules, err := ParseApplicationRules(strings.NewReader(testApplicationRules))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	tests := []struct {
+		remote remoteEnd
+		application Application
+	}{
+		//All the conditions must match, but any of their values
+		{ remoteEnd{ capture.L4Tcp, net.IPv4(45, 57, 1, 1), 443, 2906, "occ-0-1.nflxvideo.net" }, Application{ "netflix", "video-streaming" } },
+		{ remoteEnd{ capture.L4Tcp, net.IPv4(45, 57, 1, 1), 443, 2906, "" }, Application{} },
+		{ remoteEnd{ capture.L4Udp, net.IPv4(45, 57, 1, 1), 443, 40027, "" }, Application{ "netflix", "video-streaming" } },
+		{ remoteEnd{ capture.L4Udp, net.IPv4(1, 2, 3, 4), 27020, 0, "" }, Application{ "steam", "gaming" } },
+		{ remoteEnd{ capture.L4Tcp, net.IPv4(1, 2, 3, 4), 27020, 0, "" }, Application{} },
+		{ remoteEnd{ capture.L4Tcp, net.IPv4(192, 168, 1, 10), 873, 0, "" }, Application{ "backups", "backups" } },
+		{ remoteEnd{ capture.L4Udp, net.IPv4(192, 168, 1, 10), 873, 0, "" }, Application{} },
+		{ remoteEnd{ capture.L4Tcp, net.ParseIP("fd00::10"), 22, 0, "" }, Application{ "backups", "backups" } },
+		//The domains match themselves and their subdomains, but not other domains that end the same
+		{ remoteEnd{ capture.L4Tcp, nil, 80, 0, "windowsupdate.com" }, Application{ "windows-update", "updates" } },
+		{ remoteEnd{ capture.L4Tcp, nil, 80, 0, "download.windowsupdate.com" }, Application{ "windows-update", "updates" } },
+		{ remoteEnd{ capture.L4Tcp, nil, 80, 0, "notwindowsupdate.com" }, Application{} },
+	}
+	for _, test := range tests {
+		if application := rules.classify(test.remote); application != test.application {
+			t.Errorf("%+v should be %+v, but it is %+v", test.remote, test.application, application)
+		}
+	}
+}
+
+func TestClassifyWithoutRules(t *testing.T) {
+	var rules *ApplicationRules
+	if application := rules.classify(remoteEnd{ port: 443 }); application != (Application{}) {
+		t.Error("Without rules, there should be no application, but there is", application)
+	}
+
+	rules, _ = ParseApplicationRules(strings.NewReader("web web protocol=tcp\nother other\n"))
+	if application := rules.classify(remoteEnd{ protocol: capture.L4Icmp }); application.Category != "other" {
+		t.Error("A rule without conditions should match everything, but the application is", application)
+	}
+}
+
+func TestClassifyRemembersTheApplicationOfTheRemoteEnds(t *testing.T) {
+	rules, _ := ParseApplicationRules(strings.NewReader(testApplicationRules))
+	remote := remoteEnd{ capture.L4Udp, net.IPv4(1, 2, 3, 4), 27020, 0, "" }
+
+	for i := 0; i < 2; i++ {
+		if application := rules.classify(remote); application.Name != "steam" {
+			t.Error("The application should be steam, but it is", application)
+		}
+	}
+	if len(rules.cache) != 1 {
+		t.Error("The application of the remote end should be remembered once, but there are", len(rules.cache))
+	}
+
+	for port := 0; port < maxCachedApplications + 10; port++ {
+		rules.classify(remoteEnd{ capture.L4Tcp, net.IPv4(1, 2, byte(port >> 8), byte(port)), uint16(port), 0, "" })
+	}
+	if len(rules.cache) != maxCachedApplications {
+		t.Error("There should be", maxCachedApplications, "remembered applications, but there are", len(rules.cache))
+	}
+}
+
+func TestParseApplicationRulesInvalid(t *testing.T) {
+	invalid := []string{
+		"netflix",
+		"netflix video-streaming asn",
+		"netflix video-streaming asn=",
+		"netflix video-streaming asn=ASxyz",
+		"netflix video-streaming color=red",
+		"steam gaming protocol=sctp",
+		"steam gaming port=70000",
+		"steam gaming port=27050-27015",
+		"steam gaming port=icmp/1",
+		"backups backups net=192.168.1.10",
+		"updates updates domain=.",
+	}
+	for _, rules := range invalid {
+		_, err := ParseApplicationRules(strings.NewReader("# comment\n\n" + rules))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+			t.Errorf("%q should fail in the line 3, but the error is %v", rules, err)
+		}
+	}
+}
+
+func TestApplicationRulesWithoutAsnsNorDomains(t *testing.T) {
+	rules, err := ParseApplicationRules(strings.NewReader("steam gaming protocol=udp port=27015-27050"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if rules.UsesAsns() || rules.UsesDomains() {
+		t.Error("The rules have no conditions on the autonomous systems nor the domains")
+	}
+}
+
+func TestLoadApplicationRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "applications.conf")
+	if err := os.WriteFile(path, []byte(testApplicationRules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := LoadApplicationRules(path)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(rules.rules) != 5 {
+		t.Error("There should be 5 rules, but there are", len(rules.rules))
+	}
+	if !rules.UsesAsns() || !rules.UsesDomains() {
+		t.Error("The rules have conditions on the autonomous systems and the domains")
+	}
+	if _, err := LoadApplicationRules(path + ".missing"); err == nil {
+		t.Error("Loading a file that does not exist should fail")
+	}
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"sort"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/database"
+	"github.com/melchor629/speedy/geoip"
+)
+
+//Remote ports counted per device and interval. When there are more, the port with less traffic leaves its place (its
+//traffic is only counted in the breakdown by protocol), so a port scan cannot make the table grow without limit and the
+//ports with more traffic are kept even if they are seen late in the interval.
+const maxPortsPerDevice = 64
+
+//Remote domains counted per device and interval, for the same reason (the domain with less traffic leaves its place).
+const maxDomainsPerDevice = 64
+
+//Remote countries and autonomous systems counted per device and interval, for the same reason (the ones with less traffic
+//leave their place).
+const maxCountriesPerDevice = 64
+const maxAsnsPerDevice = 64
+
+type directionBytes struct {
+	download uint64
+	upload uint64
+}
+
+func (d *directionBytes) add(bytes uint64, reversed bool) {
+	if reversed {
+		d.download += bytes
+	} else {
+		d.upload += bytes
+	}
+}
+
+func (d directionBytes) total() uint64 {
+	return d.download + d.upload
+}
+
+type portKey struct {
+	protocol capture.L4Protocol
+	port uint16
+}
+
+type asnKey struct {
+	number uint32
+	organization string
+}
+
+//Traffic of a device by transport protocol, by remote port (see maxPortsPerDevice), by remote domain (see
+//maxDomainsPerDevice), by remote country (see maxCountriesPerDevice), by remote autonomous system (see
+//maxAsnsPerDevice) and by category of applications (which are the ones of the rules, so they are already bounded).
+type breakdown struct {
+	protocols [capture.L4ProtocolCount]directionBytes
+	ports map[portKey]directionBytes
+	domains map[string]directionBytes
+	countries map[string]directionBytes
+	asns map[asnKey]directionBytes
+	categories map[string]directionBytes
+}
+
+//Adds the bytes of the packet. The domain is the name of the remote end, the location is where the remote end is and
+//the category is the one of the application of the traffic (all of them are empty if they are not known).
+func (b *breakdown) add(packet *capture.Packet, domain string, location geoip.Location, category string, bytes uint64, reversed bool) {
+	protocol := packet.L4()
+	b.protocols[protocol].add(bytes, reversed)
+
+	if category != "" {
+		if b.categories == nil {
+			b.categories = make(map[string]directionBytes)
+		}
+		counted := b.categories[category]
+		counted.add(bytes, reversed)
+		b.categories[category] = counted
+	}
+
+	if location.Country != "" || location.Asn != 0 {
+		counted := directionBytes{}
+		counted.add(bytes, reversed)
+		if location.Country != "" {
+			b.countries = addNamed(b.countries, location.Country, counted, maxCountriesPerDevice)
+		}
+		if location.Asn != 0 {
+			b.addAsn(asnKey{ location.Asn, location.AsOrganization }, counted)
+		}
+	}
+
+	if domain != "" {
+		counted := directionBytes{}
+		counted.add(bytes, reversed)
+		b.domains = addNamed(b.domains, domain, counted, maxDomainsPerDevice)
+	}
+
+	if port := packet.RemotePort(); port != 0 {
+		counted := directionBytes{}
+		counted.add(bytes, reversed)
+		b.addPort(portKey{ protocol, port }, counted)
+	}
+}
+
+//Adds the bytes to the port. When there are too many ports, the one with less traffic is evicted to make room for the new
+//one (see maxPortsPerDevice).
+func (b *breakdown) addPort(key portKey, bytes directionBytes) {
+	counted, ok := b.ports[key]
+	if !ok && len(b.ports) >= maxPortsPerDevice {
+		delete(b.ports, smallestPort(b.ports))
+	}
+	if b.ports == nil {
+		b.ports = make(map[portKey]directionBytes)
+	}
+	counted.download += bytes.download
+	counted.upload += bytes.upload
+	b.ports[key] = counted
+}
+
+func smallestPort(ports map[portKey]directionBytes) portKey {
+	var smallest portKey
+	var smallestBytes directionBytes
+	first := true
+	for key, bytes := range ports {
+		if first || bytes.total() < smallestBytes.total() {
+			smallest, smallestBytes, first = key, bytes, false
+		}
+	}
+	return smallest
+}
+
+//Adds the bytes to the name (a domain...) in the map, which is created if nil. When there are more than max names, the
+//one with less traffic is evicted to make room for the new one, as with the ports.
+func addNamed(named map[string]directionBytes, name string, bytes directionBytes, max int) map[string]directionBytes {
+	counted, ok := named[name]
+	if !ok && len(named) >= max {
+		var smallest string
+		var smallestBytes directionBytes
+		first := true
+		for key, bytes := range named {
+			if first || bytes.total() < smallestBytes.total() {
+				smallest, smallestBytes, first = key, bytes, false
+			}
+		}
+		delete(named, smallest)
+	}
+	if named == nil {
+		named = make(map[string]directionBytes)
+	}
+	counted.download += bytes.download
+	counted.upload += bytes.upload
+	named[name] = counted
+	return named
+}
+
+//Adds the bytes to the autonomous system, evicting the one with less traffic when there are too many, as with the ports.
+func (b *breakdown) addAsn(key asnKey, bytes directionBytes) {
+	counted, ok := b.asns[key]
+	if !ok && len(b.asns) >= maxAsnsPerDevice {
+		var smallest asnKey
+		var smallestBytes directionBytes
+		first := true
+		for asn, bytes := range b.asns {
+			if first || bytes.total() < smallestBytes.total() {
+				smallest, smallestBytes, first = asn, bytes, false
+			}
+		}
+		delete(b.asns, smallest)
+	}
+	if b.asns == nil {
+		b.asns = make(map[asnKey]directionBytes)
+	}
+	counted.download += bytes.download
+	counted.upload += bytes.upload
+	b.asns[key] = counted
+}
+
+func (b *breakdown) merge(other *breakdown) {
+	for protocol := range b.protocols {
+		b.protocols[protocol].download += other.protocols[protocol].download
+		b.protocols[protocol].upload += other.protocols[protocol].upload
+	}
+	for key, bytes := range other.ports {
+		b.addPort(key, bytes)
+	}
+	for domain, bytes := range other.domains {
+		b.domains = addNamed(b.domains, domain, bytes, maxDomainsPerDevice)
+	}
+	for country, bytes := range other.countries {
+		b.countries = addNamed(b.countries, country, bytes, maxCountriesPerDevice)
+	}
+	for key, bytes := range other.asns {
+		b.addAsn(key, bytes)
+	}
+	for category, bytes := range other.categories {
+		if b.categories == nil {
+			b.categories = make(map[string]directionBytes)
+		}
+		counted := b.categories[category]
+		counted.download += bytes.download
+		counted.upload += bytes.upload
+		b.categories[category] = counted
+	}
+}
+
+//Clears the counters. The maps are not cleared but replaced, as a copy of the entry could be reading them.
+func (b *breakdown) clear() {
+	b.protocols = [capture.L4ProtocolCount]directionBytes{}
+	b.ports = nil
+	b.domains = nil
+	b.countries = nil
+	b.asns = nil
+	b.categories = nil
+}
+
+func (b *breakdown) protocolItems() []database.BreakdownItem {
+	items := make([]database.BreakdownItem, 0)
+	for protocol, bytes := range b.protocols {
+		if bytes.download != 0 || bytes.upload != 0 {
+			name := capture.L4Protocol(protocol).String()
+			items = append(items, database.BreakdownItem{ Protocol: name, Download: bytes.download, Upload: bytes.upload })
+		}
+	}
+	return items
+}
+
+//Gets the n ports with more traffic (both directions), from the most used one.
+func (b *breakdown) topPorts(n int) []database.BreakdownItem {
+	items := make([]database.BreakdownItem, 0, len(b.ports))
+	for key, bytes := range b.ports {
+		items = append(items, database.BreakdownItem{
+			Protocol: key.protocol.String(),
+			Port: key.port,
+			Download: bytes.download,
+			Upload: bytes.upload,
+		})
+	}
+	return topItems(items, n)
+}
+
+//Gets the n domains with more traffic (both directions), from the most used one.
+func (b *breakdown) topDomains(n int) []database.BreakdownItem {
+	items := make([]database.BreakdownItem, 0, len(b.domains))
+	for domain, bytes := range b.domains {
+		items = append(items, database.BreakdownItem{ Domain: domain, Download: bytes.download, Upload: bytes.upload })
+	}
+	return topItems(items, n)
+}
+
+//Gets the traffic of every country, from the most used one.
+func (b *breakdown) countryItems() []database.BreakdownItem {
+	items := make([]database.BreakdownItem, 0, len(b.countries))
+	for country, bytes := range b.countries {
+		items = append(items, database.BreakdownItem{ Country: country, Download: bytes.download, Upload: bytes.upload })
+	}
+	return topItems(items, len(items))
+}
+
+//Gets the n autonomous systems with more traffic (both directions), from the most used one.
+func (b *breakdown) topAsns(n int) []database.BreakdownItem {
+	items := make([]database.BreakdownItem, 0, len(b.asns))
+	for key, bytes := range b.asns {
+		items = append(items, database.BreakdownItem{
+			Asn: key.number,
+			AsOrganization: key.organization,
+			Download: bytes.download,
+			Upload: bytes.upload,
+		})
+	}
+	return topItems(items, n)
+}
+
+//Gets the traffic of every category, from the most used one.
+func (b *breakdown) categoryItems() []database.BreakdownItem {
+	items := make([]database.BreakdownItem, 0, len(b.categories))
+	for category, bytes := range b.categories {
+		items = append(items, database.BreakdownItem{ Category: category, Download: bytes.download, Upload: bytes.upload })
+	}
+	return topItems(items, len(items))
+}
+
+func topItems(items []database.BreakdownItem, n int) []database.BreakdownItem {
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Download + a.Upload != b.Download + b.Upload {
+			return a.Download + a.Upload > b.Download + b.Upload
+		}
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.Country != b.Country {
+			return a.Country < b.Country
+		}
+		if a.Asn != b.Asn {
+			return a.Asn < b.Asn
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Port < b.Port
+	})
+	if n < 0 {
+		n = 0
+	}
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/geoip"
+)
+
+func TestBreakdownCountsTheProtocolsAndThePorts(t *testing.T) {
+	b := breakdown{}
+
+	b.add(&capture.Packet{ Protocol: 17, SrcPort: 50000, DstPort: 443 }, "www.netflix.com", geoip.Location{}, "", 1000, false)
+	//The answer, already reversed so the device is the source
+	b.add(&capture.Packet{ Protocol: 17, SrcPort: 50000, DstPort: 443 }, "www.netflix.com", geoip.Location{}, "", 3000, true)
+	b.add(&capture.Packet{ Protocol: 6, SrcPort: 50001, DstPort: 445 }, "nas.local", geoip.Location{}, "", 500, false)
+	b.add(&capture.Packet{ Protocol: 1 }, "", geoip.Location{}, "", 64, false)
+
+	protocols := b.protocolItems()
+	if len(protocols) != 3 {
+		t.Fatal("There should be 3 protocols, but there are", protocols)
+	}
+	for _, item := range protocols {
+		if item.Protocol == "udp" && (item.Upload != 1000 || item.Download != 3000) {
+			t.Error("UDP should have 1000 bytes up and 3000 down, but has", item.Upload, item.Download)
+		}
+	}
+
+	ports := b.topPorts(1)
+	if len(ports) != 1 || ports[0].Protocol != "udp" || ports[0].Port != 443 || ports[0].Download != 3000 {
+		t.Error("The top port should be UDP 443 with 3000 bytes down, but is", ports)
+	}
+	if ports := b.topPorts(10); len(ports) != 2 || ports[1].Port != 445 {
+		t.Error("There should be 2 ports, the last one TCP 445, but there are", ports)
+	}
+
+	domains := b.topDomains(10)
+	if len(domains) != 2 || domains[0].Domain != "www.netflix.com" || domains[0].Upload != 1000 || domains[1].Domain != "nas.local" {
+		t.Error("There should be www.netflix.com and then nas.local, but there are", domains)
+	}
+}
+
+func TestBreakdownCountsTheCountriesAndTheAsns(t *testing.T) {
+	b := breakdown{}
+	other := breakdown{}
+	netflix := geoip.Location{ Country: "US", Asn: 2906, AsOrganization: "NETFLIX-ASN" }
+
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", netflix, "", 100, false)
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", netflix, "", 7000, true)
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", geoip.Location{ Country: "ES", Asn: 3352, AsOrganization: "TELEFONICA" }, "", 2000, true)
+	//Without AS, only the country is counted, and the local traffic has no location
+	other.add(&capture.Packet{ Protocol: 17, DstPort: 53 }, "", geoip.Location{ Country: "ES" }, "", 1000, true)
+	other.add(&capture.Packet{ Protocol: 6, DstPort: 445 }, "", geoip.Location{}, "", 9000, true)
+	b.merge(&other)
+
+	countries := b.countryItems()
+	if len(countries) != 2 || countries[0].Country != "US" || countries[0].Download != 7000 || countries[0].Upload != 100 {
+		t.Fatal("There should be US with 7000 bytes down and 100 up and then ES, but there are", countries)
+	}
+	if countries[1].Country != "ES" || countries[1].Download != 3000 {
+		t.Error("ES should have 3000 bytes down, but there are", countries[1])
+	}
+
+	asns := b.topAsns(1)
+	if len(asns) != 1 || asns[0].Asn != 2906 || asns[0].AsOrganization != "NETFLIX-ASN" || asns[0].Download != 7000 {
+		t.Error("The top AS should be AS2906 (NETFLIX-ASN) with 7000 bytes down, but is", asns)
+	}
+	if asns := b.topAsns(10); len(asns) != 2 || asns[1].Asn != 3352 || asns[1].Download != 2000 {
+		t.Error("There should be 2 ASs, the last one AS3352 with 2000 bytes down, but there are", asns)
+	}
+}
+
+func TestBreakdownCountsTheCategories(t *testing.T) {
+	b := breakdown{}
+	other := breakdown{}
+
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", geoip.Location{}, "video-streaming", 5000, true)
+	b.add(&capture.Packet{ Protocol: 17, DstPort: 27015 }, "", geoip.Location{}, "gaming", 300, false)
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 80 }, "", geoip.Location{}, "", 10000, true)
+	other.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", geoip.Location{}, "video-streaming", 1000, true)
+	b.merge(&other)
+
+	categories := b.categoryItems()
+	if len(categories) != 2 || categories[0].Category != "video-streaming" || categories[0].Download != 6000 {
+		t.Fatal("There should be video-streaming with 6000 bytes down and then gaming, but there are", categories)
+	}
+	if categories[1].Category != "gaming" || categories[1].Upload != 300 {
+		t.Error("gaming should have 300 bytes up, but there are", categories[1])
+	}
+}
+
+func TestBreakdownPortsAreBounded(t *testing.T) {
+	b := breakdown{}
+	other := breakdown{}
+
+	for port := 1; port <= maxPortsPerDevice + 10; port++ {
+		b.add(&capture.Packet{ Protocol: 6, DstPort: uint16(port) }, "host" + strconv.Itoa(port), geoip.Location{}, "", 100, false)
+		other.add(&capture.Packet{ Protocol: 17, DstPort: uint16(port) }, "", geoip.Location{}, "", 100, false)
+	}
+	b.merge(&other)
+
+	if len(b.domains) != maxDomainsPerDevice {
+		t.Error("There should be", maxDomainsPerDevice, "domains, but there are", len(b.domains))
+	}
+	if len(b.ports) != maxPortsPerDevice {
+		t.Error("There should be", maxPortsPerDevice, "ports, but there are", len(b.ports))
+	}
+	if b.protocols[capture.L4Tcp].upload != 100 * (maxPortsPerDevice + 10) {
+		t.Error("All the traffic should be counted in the protocol, but there are", b.protocols[capture.L4Tcp].upload)
+	}
+	if b.protocols[capture.L4Udp].upload != 100 * (maxPortsPerDevice + 10) {
+		t.Error("The merged traffic should be counted in the protocol, but there are", b.protocols[capture.L4Udp].upload)
+	}
+}
+
+func TestBreakdownKeepsThePortsWithMoreTrafficWhenThereAreTooMany(t *testing.T) {
+	b := breakdown{}
+	other := breakdown{}
+
+	for port := 1; port <= maxPortsPerDevice; port++ {
+		b.add(&capture.Packet{ Protocol: 6, DstPort: uint16(port) }, "", geoip.Location{}, "", uint64(100 + port), false)
+	}
+	//Every new port takes the place of the one with less traffic, so the scanned ports do not push out the busy ones
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", geoip.Location{}, "", 10000, false)
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 444 }, "", geoip.Location{}, "", 50, false)
+	other.add(&capture.Packet{ Protocol: 17, DstPort: 3478 }, "", geoip.Location{}, "", 5000, true)
+	b.merge(&other)
+
+	if len(b.ports) != maxPortsPerDevice {
+		t.Error("There should be", maxPortsPerDevice, "ports, but there are", len(b.ports))
+	}
+	top := b.topPorts(2)
+	if len(top) != 2 || top[0].Port != 443 || top[1].Port != 3478 || top[1].Download != 5000 {
+		t.Error("The top ports should be 443 and 3478, but they are", top)
+	}
+	for _, key := range []portKey{ { capture.L4Tcp, 1 }, { capture.L4Tcp, 2 }, { capture.L4Tcp, 444 } } {
+		if _, ok := b.ports[key]; ok {
+			t.Error("The port", key.port, "should have been evicted")
+		}
+	}
+}
+
+func TestBreakdownKeepsTheDomainsWithMoreTrafficWhenThereAreTooMany(t *testing.T) {
+	b := breakdown{}
+	other := breakdown{}
+
+	for i := 1; i <= maxDomainsPerDevice; i++ {
+		b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "host" + strconv.Itoa(i), geoip.Location{}, "", uint64(100 + i), false)
+	}
+	other.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "www.netflix.com", geoip.Location{}, "", 10000, true)
+	b.merge(&other)
+
+	if len(b.domains) != maxDomainsPerDevice {
+		t.Error("There should be", maxDomainsPerDevice, "domains, but there are", len(b.domains))
+	}
+	if top := b.topDomains(1); len(top) != 1 || top[0].Domain != "www.netflix.com" || top[0].Download != 10000 {
+		t.Error("The top domain should be www.netflix.com, but it is", top)
+	}
+	if _, ok := b.domains["host1"]; ok {
+		t.Error("The domain with less traffic should have been evicted")
+	}
+}
+
+func TestBreakdownKeepsTheLocationsWithMoreTrafficWhenThereAreTooMany(t *testing.T) {
+	b := breakdown{}
+
+	for i := 1; i <= maxAsnsPerDevice; i++ {
+		location := geoip.Location{ Country: "C" + strconv.Itoa(i), Asn: uint32(i), AsOrganization: "AS" + strconv.Itoa(i) }
+		b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", location, "", uint64(100 + i), false)
+	}
+	b.add(&capture.Packet{ Protocol: 6, DstPort: 443 }, "", geoip.Location{ Country: "US", Asn: 2906, AsOrganization: "NETFLIX-ASN" }, "", 10000, true)
+
+	if len(b.countries) != maxCountriesPerDevice || len(b.asns) != maxAsnsPerDevice {
+		t.Error("There should be", maxCountriesPerDevice, "countries and", maxAsnsPerDevice, "ASes, but there are", len(b.countries), len(b.asns))
+	}
+	if top := b.topAsns(1); len(top) != 1 || top[0].Asn != 2906 || top[0].Download != 10000 {
+		t.Error("The top AS should be 2906, but it is", top)
+	}
+	if _, ok := b.countries["US"]; !ok {
+		t.Error("The country with more traffic should be counted")
+	}
+	if _, ok := b.asns[asnKey{ 1, "AS1" }]; ok {
+		t.Error("The AS with less traffic should have been evicted")
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"net"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Direction of a packet from the point of view of the local network.
+type Direction uint8
+
+const (
+	//From a local device to the Internet
+	DirectionUpload Direction = iota
+	//From the Internet to a local device
+	DirectionDownload
+	//Between two local devices
+	DirectionLocal
+	//Neither side is a local device
+	DirectionTransit
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionUpload:
+		return "upload"
+	case DirectionDownload:
+		return "download"
+	case DirectionLocal:
+		return "local"
+	default:
+		return "transit"
+	}
+}
+
+//Tells the direction of the packets using the networks of the local devices and the MACs of the gateways, instead of
+//comparing with the MAC of the capture interface. Works behind VRRP/HA gateways, on mirror ports and on bridges with
+//several router MACs.
+type DirectionClassifier struct {
+	networks []*net.IPNet
+	gatewayMacs []net.HardwareAddr
+}
+
+//Creates a classifier with the given local networks and gateway MACs (both can be empty).
+func NewDirectionClassifier(networks []*net.IPNet, gatewayMacs []net.HardwareAddr) *DirectionClassifier {
+	return &DirectionClassifier{ networks, gatewayMacs }
+}
+
+//Parses the classifier from comma separated lists of CIDRs and MACs.
+func ParseDirectionClassifier(networks string, gatewayMacs string) (*DirectionClassifier, error) {
+	classifier := &DirectionClassifier{}
+	for _, network := range splitList(networks) {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		classifier.networks = append(classifier.networks, ipNet)
+	}
+
+	for _, mac := range splitList(gatewayMacs) {
+		hwAddr, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, err
+		}
+		classifier.gatewayMacs = append(classifier.gatewayMacs, hwAddr)
+	}
+
+	return classifier, nil
+}
+
+//Returns true if there are neither networks nor gateway MACs.
+func (c *DirectionClassifier) IsEmpty() bool {
+	return len(c.networks) == 0 && len(c.gatewayMacs) == 0
+}
+
+//Gets the direction of the packet. The IPs decide when any of them is local. Otherwise (no IPs or none of them is
+//local), the side that is not a gateway is considered the local device.
+func (c *DirectionClassifier) Classify(p *capture.Packet) Direction {
+	srcLocal := capture.ContainsIP(c.networks, p.SrcIp)
+	dstLocal := capture.ContainsIP(c.networks, p.DstIp)
+	switch {
+	case srcLocal && dstLocal:
+		return DirectionLocal
+	case srcLocal:
+		return DirectionUpload
+	case dstLocal:
+		return DirectionDownload
+	}
+
+	srcGateway := c.isGateway(p.SrcMac)
+	dstGateway := c.isGateway(p.DstMac)
+	switch {
+	case srcGateway && dstGateway:
+		return DirectionTransit
+	case srcGateway && len(p.DstMac) != 0:
+		return DirectionDownload
+	case dstGateway && len(p.SrcMac) != 0:
+		return DirectionUpload
+	case p.SrcIp == nil && len(c.gatewayMacs) != 0 && len(p.SrcMac) != 0 && len(p.DstMac) != 0:
+		//Non-IP traffic that does not go through any gateway stays in the LAN
+		return DirectionLocal
+	default:
+		return DirectionTransit
+	}
+}
+
+//Without classifier, a packet is LAN-internal when it does not go through the capture device (both MACs are known and
+//none of them is the MAC of the interface) or when both IPs are inside the networks of the interface.
+func isLocalTraffic(p *capture.Packet, mac net.HardwareAddr, networks []*net.IPNet) bool {
+	if len(mac) != 0 && len(p.SrcMac) != 0 && len(p.DstMac) != 0 && !sameMac(mac, p.SrcMac) && !sameMac(mac, p.DstMac) {
+		return true
+	}
+	return capture.ContainsIP(networks, p.SrcIp) && capture.ContainsIP(networks, p.DstIp)
+}
+
+func (c *DirectionClassifier) isGateway(mac net.HardwareAddr) bool {
+	for _, gatewayMac := range c.gatewayMacs {
+		if sameMac(gatewayMac, mac) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"net"
+	"testing"
+	"github.com/melchor629/speedy/capture"
+)
+
+var gatewayMac = []byte{ 0x00, 0x00, 0x5e, 0x00, 0x01, 0x01 }
+var deviceMac = []byte{ 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 }
+var otherDeviceMac = []byte{ 0x11, 0x22, 0x33, 0x44, 0x55, 0x77 }
+
+func newTestClassifier(t *testing.T) *DirectionClassifier {
+	classifier, err := ParseDirectionClassifier("192.168.1.0/24", "00:00:5e:00:01:01")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return classifier
+}
+
+func TestParseDirectionClassifierInvalidMAC(t *testing.T) {
+	_, err := ParseDirectionClassifier("192.168.1.0/24", "00:00:5e")
+
+	if err == nil {
+		t.Error("An error was expected")
+	}
+}
+
+func TestClassifyUpload(t *testing.T) {
+	packet := capture.Packet{
+		SrcMac: deviceMac, DstMac: gatewayMac,
+		SrcIp: []byte{ 192, 168, 1, 10 }, DstIp: []byte{ 1, 1, 1, 1 },
+	}
+
+	if d := newTestClassifier(t).Classify(&packet); d != DirectionUpload {
+		t.Error("Direction should be upload, but is", d)
+	}
+}
+
+func TestClassifyDownload(t *testing.T) {
+	packet := capture.Packet{
+		SrcMac: gatewayMac, DstMac: deviceMac,
+		SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 192, 168, 1, 10 },
+	}
+
+	if d := newTestClassifier(t).Classify(&packet); d != DirectionDownload {
+		t.Error("Direction should be download, but is", d)
+	}
+}
+
+func TestClassifyLocal(t *testing.T) {
+	packet := capture.Packet{
+		SrcMac: deviceMac, DstMac: otherDeviceMac,
+		SrcIp: []byte{ 192, 168, 1, 10 }, DstIp: []byte{ 192, 168, 1, 20 },
+	}
+
+	if d := newTestClassifier(t).Classify(&packet); d != DirectionLocal {
+		t.Error("Direction should be local, but is", d)
+	}
+}
+
+func TestClassifyTransit(t *testing.T) {
+	packet := capture.Packet{
+		SrcMac: gatewayMac, DstMac: gatewayMac,
+		SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 8, 8, 8, 8 },
+	}
+
+	if d := newTestClassifier(t).Classify(&packet); d != DirectionTransit {
+		t.Error("Direction should be transit, but is", d)
+	}
+}
+
+func TestClassifyByGatewayMACWhenIPsAreNotLocal(t *testing.T) {
+	packet := capture.Packet{
+		SrcMac: deviceMac, DstMac: gatewayMac,
+		SrcIp: []byte{ 203, 0, 113, 5 }, DstIp: []byte{ 1, 1, 1, 1 },
+	}
+
+	if d := newTestClassifier(t).Classify(&packet); d != DirectionUpload {
+		t.Error("Direction should be upload, but is", d)
+	}
+}
+
+func TestClassifyNonIPBetweenDevicesIsLocal(t *testing.T) {
+	packet := capture.Packet{ SrcMac: deviceMac, DstMac: otherDeviceMac }
+
+	if d := newTestClassifier(t).Classify(&packet); d != DirectionLocal {
+		t.Error("Direction should be local, but is", d)
+	}
+}
+
+func TestIsLocalTrafficWhenTheFrameDoesNotGoThroughTheCaptureDevice(t *testing.T) {
+	packet := capture.Packet{ SrcMac: deviceMac, DstMac: otherDeviceMac }
+
+	if !isLocalTraffic(&packet, gatewayMac, nil) {
+		t.Error("Traffic between two devices should be local")
+	}
+}
+
+func TestIsLocalTrafficWhenBothIPsAreInTheNetworks(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.168.1.0/24")
+	packet := capture.Packet{
+		SrcMac: gatewayMac, DstMac: deviceMac,
+		SrcIp: []byte{ 192, 168, 1, 1 }, DstIp: []byte{ 192, 168, 1, 10 },
+	}
+
+	if !isLocalTraffic(&packet, gatewayMac, []*net.IPNet{ network }) {
+		t.Error("Traffic between two local IPs should be local")
+	}
+
+	packet.SrcIp = []byte{ 1, 1, 1, 1 }
+	if isLocalTraffic(&packet, gatewayMac, []*net.IPNet{ network }) {
+		t.Error("Traffic from the Internet should not be local")
+	}
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Names remembered at the same time. The answers that do not fit are ignored until some names expire.
+const maxCachedDomains = 1 << 16
+
+//The connections usually last more than the TTL of the names, so the names are remembered at least this time.
+const minDomainTtl = 5 * time.Minute
+
+//mDNS responses are sent to everyone (to the multicast group), so their names are known by all the devices.
+var mdnsGroups = []net.IP{ net.IPv4(224, 0, 0, 251), net.ParseIP("ff02::fb") }
+
+//Identifies a name of a remote address for a device, which is identified by its IP (it sent the DNS query).
+type domainKey struct {
+	iface string
+	vlan uint16
+	device [16]byte
+	remote [16]byte
+}
+
+type cachedDomain struct {
+	name string
+	expires time.Time
+}
+
+//Remembers the names of the remote addresses that every device has resolved, taken from the DNS (and mDNS) responses
+//of the captured traffic, and the names of the servers that it has connected to, taken from the TLS and QUIC handshakes
+//(which are seen even when the DNS is encrypted), so the traffic can be attributed to the names. Shared by all the
+//workers.
+type DomainCache struct {
+	mutex sync.RWMutex
+	domains map[domainKey]cachedDomain
+	full bool
+	logger *log.Logger
+}
+
+//Creates an empty cache of names.
+func NewDomainCache() *DomainCache {
+	return &DomainCache{
+		domains: make(map[domainKey]cachedDomain),
+		logger: log.New(os.Stdout, "[DomainCache]: ", log.LstdFlags),
+	}
+}
+
+//Remembers the answers of the packet, if it is a DNS response, for the device that receives it, or the server name, if
+//it is a TLS or QUIC handshake, for the device that sends it. Must be called before the packet is reversed.
+func (c *DomainCache) learn(packet *capture.Packet, now time.Time) {
+	if c == nil || (len(packet.DnsAnswers) == 0 && packet.ServerName == "") {
+		return
+	}
+
+	key := domainKey{ iface: packet.Interface, vlan: packet.VlanID }
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if packet.ServerName != "" {
+		copy(key.device[:], packet.SrcIp.To16())
+		copy(key.remote[:], packet.DstIp.To16())
+		//The name of the server is better than the one of the DNS, as the address may be shared by several names
+		c.put(key, packet.ServerName, now.Add(minDomainTtl))
+		return
+	}
+
+	if !isMdnsGroup(packet.DstIp) {
+		copy(key.device[:], packet.DstIp.To16())
+	}
+	for _, answer := range packet.DnsAnswers {
+		copy(key.remote[:], answer.Ip.To16())
+		ttl := time.Duration(answer.Ttl) * time.Second
+		if ttl < minDomainTtl {
+			ttl = minDomainTtl
+		}
+		c.put(key, answer.Name, now.Add(ttl))
+	}
+}
+
+//Stores the name, if there is room for it. The mutex must be locked.
+func (c *DomainCache) put(key domainKey, name string, expires time.Time) {
+	if _, ok := c.domains[key]; !ok && len(c.domains) >= maxCachedDomains {
+		if !c.full {
+			c.logger.Println("The cache is full, the new names will be ignored until some of them expire")
+			c.full = true
+		}
+		return
+	}
+	c.domains[key] = cachedDomain{ name, expires }
+}
+
+//Gets the name of the destination of the packet as resolved by its source (or by mDNS), or an empty string if it is not
+//known. Must be called after the packet is reversed, when its source is the device.
+func (c *DomainCache) nameOf(packet *capture.Packet, now time.Time) string {
+	if packet.IpType == 0 {
+		return ""
+	}
+	return c.nameOfRemote(packet.Interface, packet.VlanID, packet.SrcIp, packet.DstIp, now)
+}
+
+//Gets the name of the remote address as resolved by the device (or by mDNS), or an empty string if it is not known.
+func (c *DomainCache) nameOfRemote(iface string, vlan uint16, device net.IP, remote net.IP, now time.Time) string {
+	if c == nil {
+		return ""
+	}
+
+	key := domainKey{ iface: iface, vlan: vlan }
+	copy(key.device[:], device.To16())
+	copy(key.remote[:], remote.To16())
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	domain, ok := c.domains[key]
+	if !ok {
+		key.device = [16]byte{}
+		domain, ok = c.domains[key]
+	}
+	if !ok || now.After(domain.expires) {
+		return ""
+	}
+	return domain.name
+}
+
+//Forgets the names that have expired.
+func (c *DomainCache) expire(now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, domain := range c.domains {
+		if now.After(domain.expires) {
+			delete(c.domains, key)
+		}
+	}
+	if c.full && len(c.domains) < maxCachedDomains {
+		c.full = false
+	}
+}
+
+func isMdnsGroup(ip net.IP) bool {
+	for _, group := range mdnsGroups {
+		if group.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
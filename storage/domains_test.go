@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"net"
+	"testing"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+func TestDomainCacheKnowsTheNamesResolvedByEveryDevice(t *testing.T) {
+	c := NewDomainCache()
+	now := time.Now()
+	c.learn(&capture.Packet{
+		IpType: 4,
+		SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 192, 168, 1, 2 },
+		DnsAnswers: []capture.DnsAnswer{ { Name: "www.netflix.com", Ip: []byte{ 1, 2, 3, 4 }, Ttl: 60 } },
+	}, now)
+
+	toNetflix := &capture.Packet{ IpType: 4, SrcIp: []byte{ 192, 168, 1, 2 }, DstIp: []byte{ 1, 2, 3, 4 } }
+	if name := c.nameOf(toNetflix, now.Add(time.Minute)); name != "www.netflix.com" {
+		t.Error("The name should be www.netflix.com, but is", name)
+	}
+	fromOtherDevice := &capture.Packet{ IpType: 4, SrcIp: []byte{ 192, 168, 1, 3 }, DstIp: []byte{ 1, 2, 3, 4 } }
+	if name := c.nameOf(fromOtherDevice, now); name != "" {
+		t.Error("Other device has not resolved the name, but it is", name)
+	}
+	//The TTL is shorter than the minimum
+	if name := c.nameOf(toNetflix, now.Add(minDomainTtl + time.Second)); name != "" {
+		t.Error("The name has expired, but it is", name)
+	}
+	c.expire(now.Add(minDomainTtl + time.Second))
+	if len(c.domains) != 0 {
+		t.Error("The expired names should be forgotten, but there are", len(c.domains))
+	}
+}
+
+func TestDomainCacheMdnsNamesAreKnownByAllTheDevices(t *testing.T) {
+	c := NewDomainCache()
+	now := time.Now()
+	c.learn(&capture.Packet{
+		IpType: 4,
+		SrcIp: []byte{ 192, 168, 1, 10 },
+		DstIp: net.IPv4(224, 0, 0, 251),
+		DnsAnswers: []capture.DnsAnswer{ { Name: "printer.local", Ip: []byte{ 192, 168, 1, 10 }, Ttl: 120 } },
+	}, now)
+
+	toPrinter := &capture.Packet{ IpType: 4, SrcIp: []byte{ 192, 168, 1, 3 }, DstIp: []byte{ 192, 168, 1, 10 } }
+	if name := c.nameOf(toPrinter, now); name != "printer.local" {
+		t.Error("The name should be printer.local, but is", name)
+	}
+	var nilCache *DomainCache
+	if nilCache.nameOf(toPrinter, now) != "" {
+		t.Error("A nil cache knows no names")
+	}
+}
+
+func TestDomainCacheServerNamesAreBetterThanTheDns(t *testing.T) {
+	c := NewDomainCache()
+	now := time.Now()
+	c.learn(&capture.Packet{
+		IpType: 4,
+		SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 192, 168, 1, 2 },
+		DnsAnswers: []capture.DnsAnswer{ { Name: "cdn.example.net", Ip: []byte{ 1, 2, 3, 4 }, Ttl: 60 } },
+	}, now)
+	c.learn(&capture.Packet{ IpType: 4, SrcIp: []byte{ 192, 168, 1, 2 }, DstIp: []byte{ 1, 2, 3, 4 }, ServerName: "www.youtube.com" }, now)
+	//A device that uses encrypted DNS
+	c.learn(&capture.Packet{ IpType: 4, SrcIp: []byte{ 192, 168, 1, 3 }, DstIp: []byte{ 5, 6, 7, 8 }, ServerName: "www.netflix.com" }, now)
+
+	if name := c.nameOf(&capture.Packet{ IpType: 4, SrcIp: []byte{ 192, 168, 1, 2 }, DstIp: []byte{ 1, 2, 3, 4 } }, now); name != "www.youtube.com" {
+		t.Error("The name should be the server name, www.youtube.com, but is", name)
+	}
+	if name := c.nameOf(&capture.Packet{ IpType: 4, SrcIp: []byte{ 192, 168, 1, 3 }, DstIp: []byte{ 5, 6, 7, 8 } }, now); name != "www.netflix.com" {
+		t.Error("The name should be www.netflix.com, but is", name)
+	}
+}
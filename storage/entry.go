@@ -1,20 +1,37 @@
 package storage
 
 import (
+	"math"
 	"net"
 	"time"
+	"github.com/melchor629/speedy/database"
 )
 
 //An entry of data.
 type Entry struct {
+	iface string
+	vlan uint16
 	mac net.HardwareAddr
 	ipv4 net.IP
 	ipv6 net.IP
 
 	accumulatedDownload uint64
 	accumulatedUpload uint64
+	accumulatedLocalDownload uint64
+	accumulatedLocalUpload uint64
+	downloadPackets uint64
+	uploadPackets uint64
+	newFlows uint64
+	samples uint64
+	samplingRate uint32
+	breakdown breakdown
+	topPorts []database.BreakdownItem
+	topDomains []database.BreakdownItem
+	topAsns []database.BreakdownItem
 
 	lastModified time.Time
+	intervalStart time.Time
+	timestamp time.Time
 }
 
 //Get the IPv6 address for this entry (if given).
@@ -27,6 +44,16 @@ func (e *Entry) Ipv4() net.IP {
 	return e.ipv4
 }
 
+//Get the name of the interface where the traffic of this entry was captured (empty if unknown).
+func (e *Entry) Interface() string {
+	return e.iface
+}
+
+//Get the VLAN ID where the traffic of this entry was seen (0 if untagged).
+func (e *Entry) Vlan() uint16 {
+	return e.vlan
+}
+
 //Get the MAC address for this entry.
 func (e *Entry) Mac() net.HardwareAddr {
 	return e.mac
@@ -42,16 +69,117 @@ func (e *Entry) GetUploadSpeed() uint64 {
 	return e.accumulatedUpload
 }
 
-//Clear the accumulated upload and download speeds
+//Gets the download speed of the traffic that comes from other local devices (or the accumulated local download)
+func (e *Entry) GetLocalDownloadSpeed() uint64 {
+	return e.accumulatedLocalDownload
+}
+
+//Gets the upload speed of the traffic that goes to other local devices (or the accumulated local upload)
+func (e *Entry) GetLocalUploadSpeed() uint64 {
+	return e.accumulatedLocalUpload
+}
+
+//Gets the packets received by the device (from the Internet and from the LAN)
+func (e *Entry) GetDownloadPackets() uint64 {
+	return e.downloadPackets
+}
+
+//Gets the packets sent by the device (to the Internet and to the LAN)
+func (e *Entry) GetUploadPackets() uint64 {
+	return e.uploadPackets
+}
+
+//Gets the connections opened by or to the device: TCP SYNs and new UDP flows
+func (e *Entry) GetNewFlows() uint64 {
+	return e.newFlows
+}
+
+//Gets the traffic of every transport protocol, both Internet and local (only the protocols with traffic)
+func (e *Entry) GetProtocols() []database.BreakdownItem {
+	return e.breakdown.protocolItems()
+}
+
+//Gets the traffic of the remote ports with more traffic, both Internet and local, from the most used one
+func (e *Entry) GetTopPorts() []database.BreakdownItem {
+	return e.topPorts
+}
+
+//Gets the traffic of the remote domains with more traffic, both Internet and local, from the most used one
+func (e *Entry) GetTopDomains() []database.BreakdownItem {
+	return e.topDomains
+}
+
+//Gets the traffic of every remote country, both Internet and local, from the most used one
+func (e *Entry) GetCountries() []database.BreakdownItem {
+	return e.breakdown.countryItems()
+}
+
+//Gets the traffic of the remote autonomous systems with more traffic, both Internet and local, from the most used one
+func (e *Entry) GetTopAsns() []database.BreakdownItem {
+	return e.topAsns
+}
+
+//Gets the traffic of every category of applications, both Internet and local, from the most used one
+func (e *Entry) GetCategories() []database.BreakdownItem {
+	return e.breakdown.categoryItems()
+}
+
+//Gets the highest sampling rate of the packets counted in the accumulated speeds (1 if they were not sampled)
+func (e *Entry) SamplingRate() uint32 {
+	if e.samplingRate < 1 {
+		return 1
+	}
+	return e.samplingRate
+}
+
+//Gets the relative error of the accumulated speeds caused by the sampling, with a confidence of 95% (0 if they were not
+//sampled). With n sampled packets, it is 1.96 * sqrt(1 / n).
+func (e *Entry) SamplingError() float64 {
+	if e.SamplingRate() == 1 || e.samples == 0 {
+		return 0
+	}
+	return 1.96 * math.Sqrt(1 / float64(e.samples))
+}
+
+//Gets the beginning of the interval in which the accumulated speeds were counted (aligned to the second)
+func (e *Entry) IntervalStart() time.Time {
+	return e.intervalStart
+}
+
+//Gets the moment when the accumulated speeds were taken, the end of the interval (aligned to the second)
+func (e *Entry) Timestamp() time.Time {
+	return e.timestamp
+}
+
+//Clear the accumulated upload and download speeds (both Internet and local ones), packets, new flows and breakdown
 func (e *Entry) ClearSpeed() {
 	e.accumulatedUpload = 0
 	e.accumulatedDownload = 0
+	e.accumulatedLocalUpload = 0
+	e.downloadPackets = 0
+	e.uploadPackets = 0
+	e.newFlows = 0
+	e.accumulatedLocalDownload = 0
+	e.samples = 0
+	e.samplingRate = 0
+	e.breakdown.clear()
+	e.topPorts = nil
+	e.topDomains = nil
+	e.topAsns = nil
 }
 
 func (e *Entry) tooOld() bool {
-	return time.Now().Sub(e.lastModified) > time.Hour
+	return e.tooOldAt(time.Now())
+}
+
+func (e *Entry) tooOldAt(now time.Time) bool {
+	return now.Sub(e.lastModified) > time.Hour
 }
 
 func (e *Entry) modified() {
-	e.lastModified = time.Now()
+	e.modifiedAt(time.Now())
+}
+
+func (e *Entry) modifiedAt(now time.Time) {
+	e.lastModified = now
 }
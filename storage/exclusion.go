@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Rules that tell which traffic must not be counted. A packet is excluded if any of its IPs is inside one of the
+//networks, if any of its MACs is one of the MACs, or if any of its ports is one of the ports.
+type ExclusionRules struct {
+	networks []*net.IPNet
+	macs []net.HardwareAddr
+	ports map[uint16]bool
+}
+
+//Parses the exclusion rules from comma separated lists of CIDRs (192.168.2.0/24,fd00::/8), MACs and ports. Any of the
+//lists can be empty.
+func ParseExclusionRules(networks string, macs string, ports string) (*ExclusionRules, error) {
+	rules := &ExclusionRules{ ports: make(map[uint16]bool) }
+	for _, network := range splitList(networks) {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		rules.networks = append(rules.networks, ipNet)
+	}
+
+	for _, mac := range splitList(macs) {
+		hwAddr, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, err
+		}
+		rules.macs = append(rules.macs, hwAddr)
+	}
+
+	for _, port := range splitList(ports) {
+		value, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || value == 0 {
+			return nil, fmt.Errorf("invalid port %s", port)
+		}
+		rules.ports[uint16(value)] = true
+	}
+
+	return rules, nil
+}
+
+//Returns true if there are no rules.
+func (r *ExclusionRules) IsEmpty() bool {
+	return len(r.networks) == 0 && len(r.macs) == 0 && len(r.ports) == 0
+}
+
+//Returns true if the packet matches any of the rules.
+func (r *ExclusionRules) Excludes(p *capture.Packet) bool {
+	for _, network := range r.networks {
+		if (p.SrcIp != nil && network.Contains(p.SrcIp)) || (p.DstIp != nil && network.Contains(p.DstIp)) {
+			return true
+		}
+	}
+
+	for _, mac := range r.macs {
+		if sameMac(mac, p.SrcMac) || sameMac(mac, p.DstMac) {
+			return true
+		}
+	}
+
+	return r.ports[p.SrcPort] || r.ports[p.DstPort]
+}
+
+func sameMac(a net.HardwareAddr, b net.HardwareAddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//Splits a comma separated list, ignoring the empty elements.
+func splitList(list string) []string {
+	elements := make([]string, 0)
+	for _, element := range strings.Split(list, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
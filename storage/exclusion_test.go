@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"testing"
+	"github.com/melchor629/speedy/capture"
+)
+
+func TestParseExclusionRulesEmpty(t *testing.T) {
+	rules, err := ParseExclusionRules("", "", "")
+
+	if err != nil {
+		t.Error("Unexpected error:", err)
+		t.FailNow()
+	}
+
+	if !rules.IsEmpty() {
+		t.Error("Rules should be empty")
+	}
+}
+
+func TestParseExclusionRulesInvalidCIDR(t *testing.T) {
+	_, err := ParseExclusionRules("192.168.1.0", "", "")
+
+	if err == nil {
+		t.Error("An error was expected")
+	}
+}
+
+func TestParseExclusionRulesInvalidPort(t *testing.T) {
+	_, err := ParseExclusionRules("", "", "445,70000")
+
+	if err == nil {
+		t.Error("An error was expected")
+	}
+}
+
+func TestExcludesByNetwork(t *testing.T) {
+	rules, _ := ParseExclusionRules("10.0.0.0/8, fd00::/8", "", "")
+
+	if !rules.Excludes(&capture.Packet{ SrcIp: []byte{ 192, 168, 1, 2 }, DstIp: []byte{ 10, 1, 2, 3 } }) {
+		t.Error("Packet with destination 10.1.2.3 should be excluded")
+	}
+
+	if rules.Excludes(&capture.Packet{ SrcIp: []byte{ 192, 168, 1, 2 }, DstIp: []byte{ 1, 1, 1, 1 } }) {
+		t.Error("Packet from 192.168.1.2 to 1.1.1.1 should not be excluded")
+	}
+}
+
+func TestExcludesByMAC(t *testing.T) {
+	rules, _ := ParseExclusionRules("", "00:11:22:33:44:55", "")
+
+	if !rules.Excludes(&capture.Packet{ SrcMac: []byte{ 0x00, 0x11, 0x22, 0x33, 0x44, 0x55 } }) {
+		t.Error("Packet from 00:11:22:33:44:55 should be excluded")
+	}
+
+	if rules.Excludes(&capture.Packet{ SrcMac: []byte{ 0x00, 0x11, 0x22, 0x33, 0x44, 0x56 } }) {
+		t.Error("Packet from 00:11:22:33:44:56 should not be excluded")
+	}
+}
+
+func TestExcludesByPort(t *testing.T) {
+	rules, _ := ParseExclusionRules("", "", "445")
+
+	if !rules.Excludes(&capture.Packet{ SrcPort: 50000, DstPort: 445 }) {
+		t.Error("Packet to port 445 should be excluded")
+	}
+
+	if rules.Excludes(&capture.Packet{ SrcPort: 50000, DstPort: 443 }) {
+		t.Error("Packet to port 443 should not be excluded")
+	}
+
+	if rules.Excludes(&capture.Packet{}) {
+		t.Error("Packet without ports should not be excluded")
+	}
+}
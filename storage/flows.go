@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+//A UDP flow is new when none of its packets has been seen for this time
+const udpFlowTimeout = 60 * time.Second
+
+//Identifies a flow (5-tuple and VLAN) in both directions: the endpoint with the lowest IP (and port) goes first.
+type flowKey struct {
+	iface string
+	vlan uint16
+	protocol uint8
+	ipA [16]byte
+	ipB [16]byte
+	portA uint16
+	portB uint16
+}
+
+//Gets the key of the flow of the packet, and if the source of the packet is the first endpoint of the key.
+func flowKeyOf(packet *capture.Packet) (flowKey, bool) {
+	key := flowKey{
+		iface: packet.Interface,
+		vlan: packet.VlanID,
+		protocol: packet.Protocol,
+		portA: packet.SrcPort,
+		portB: packet.DstPort,
+	}
+	copy(key.ipA[:], packet.SrcIp.To16())
+	copy(key.ipB[:], packet.DstIp.To16())
+	order := bytes.Compare(key.ipA[:], key.ipB[:])
+	if order > 0 || (order == 0 && key.portA > key.portB) {
+		key.ipA, key.ipB = key.ipB, key.ipA
+		key.portA, key.portB = key.portB, key.portA
+		return key, false
+	}
+	return key, true
+}
+
+//Remembers the UDP flows seen recently by all the workers. Every worker remembers the flows that it sees in the
+//interval (see udpFlowSeen), and they are told here when the interval is merged, so the workers do not share a lock for
+//every packet. Only used while merging and cleaning up, under the lock of the storage.
+type udpFlows struct {
+	lastSeen map[flowKey]time.Time
+}
+
+func newUdpFlows() *udpFlows {
+	return &udpFlows{ lastSeen: make(map[flowKey]time.Time) }
+}
+
+//Remembers that the flow was seen between the times, and returns true if it is a new flow: none of its packets (in any
+//direction) had been seen since the flow timed out.
+func (f *udpFlows) see(key flowKey, first time.Time, last time.Time) bool {
+	lastSeen, ok := f.lastSeen[key]
+	if !ok || last.After(lastSeen) {
+		f.lastSeen[key] = last
+	}
+	return !ok || first.Sub(lastSeen) > udpFlowTimeout
+}
+
+//Forgets the flows that have timed out.
+func (f *udpFlows) expire(now time.Time) {
+	if f == nil {
+		return
+	}
+
+	for key, lastSeen := range f.lastSeen {
+		if now.Sub(lastSeen) > udpFlowTimeout {
+			delete(f.lastSeen, key)
+		}
+	}
+}
+
+//A UDP flow seen by a worker in the interval, with the entries credited by its first packet. If the flow is new, they
+//are credited with the new flow when the interval is merged.
+type udpFlowSeen struct {
+	first time.Time
+	last time.Time
+	entries []*shardEntry
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"testing"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+func TestUdpFlowIsTheSameInBothDirections(t *testing.T) {
+	query := &capture.Packet{ SrcIp: []byte{ 10, 0, 0, 2 }, DstIp: []byte{ 1, 1, 1, 1 }, SrcPort: 40000, DstPort: 53 }
+	answer := &capture.Packet{ SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 10, 0, 0, 2 }, SrcPort: 53, DstPort: 40000 }
+	other := &capture.Packet{ SrcIp: []byte{ 10, 0, 0, 2 }, DstIp: []byte{ 1, 1, 1, 1 }, SrcPort: 40001, DstPort: 53 }
+
+	queryKey, _ := flowKeyOf(query)
+	answerKey, _ := flowKeyOf(answer)
+	otherKey, _ := flowKeyOf(other)
+	if queryKey != answerKey {
+		t.Error("The answer should be in the same flow as the query")
+	}
+	if queryKey == otherKey {
+		t.Error("A packet from another port should be in another flow")
+	}
+}
+
+func TestUdpFlowIsNewAgainAfterTheTimeout(t *testing.T) {
+	f := newUdpFlows()
+	now := time.Now()
+	key, _ := flowKeyOf(&capture.Packet{ Interface: "eth0", SrcIp: []byte{ 10, 0, 0, 2 }, DstIp: []byte{ 1, 1, 1, 1 }, SrcPort: 40000, DstPort: 53 })
+
+	if !f.see(key, now, now) {
+		t.Error("The first time, the flow should be new")
+	}
+	if f.see(key, now.Add(udpFlowTimeout), now.Add(udpFlowTimeout)) {
+		t.Error("The flow has not timed out yet")
+	}
+	//Another worker saw it before the last time, it is still the same flow
+	if f.see(key, now.Add(time.Second), now.Add(2 * time.Second)) {
+		t.Error("The flow was seen by another worker, it should not be new")
+	}
+	if !f.see(key, now.Add(2 * udpFlowTimeout + time.Second), now.Add(2 * udpFlowTimeout + time.Second)) {
+		t.Error("The flow has timed out, it should be new again")
+	}
+
+	f.expire(now.Add(4 * udpFlowTimeout))
+	if len(f.lastSeen) != 0 {
+		t.Error("The flows that timed out should be forgotten, but there are", len(f.lastSeen))
+	}
+	var nilFlows *udpFlows
+	nilFlows.expire(now)
+}
+
+func TestUdpFlowSeenBySeveralWorkersIsCountedOnce(t *testing.T) {
+	end := time.Now().Truncate(time.Second).Add(time.Second)
+	s := Storage{ db: make(map[string]Entry), udpFlows: newUdpFlows(), shards: []*shard{ newShard(end), newShard(end) } }
+	device := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+	query := &capture.Packet{ Bytes: 60, SrcMac: device, IpType: 4, SrcIp: []byte{ 10, 0, 0, 2 }, DstIp: []byte{ 1, 1, 1, 1 },
+		Protocol: 17, SrcPort: 40000, DstPort: 53 }
+	now := end.Add(-time.Millisecond)
+
+	//Both workers see the first packets of the flow in the same interval, and the second one sees it twice
+	for _, sh := range []*shard{ s.shards[0], s.shards[1], s.shards[1] } {
+		s.addTraffic(sh, query, false, false, false, 0, sh.seeUdpFlow(query, now), now)
+	}
+	s.merge(end)
+	//In the next interval, the flow is not new anymore
+	s.addTraffic(s.shards[0], query, false, false, false, 0, s.shards[0].seeUdpFlow(query, end), end)
+	s.merge(end.Add(time.Second))
+
+	e := s.db["12:22:33:44:55:66"]
+	if e.GetNewFlows() != 1 {
+		t.Error("There should be 1 new flow, but there are", e.GetNewFlows())
+	}
+}
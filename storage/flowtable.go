@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/database"
+)
+
+//Flows tracked at the same time (split evenly between the shards). The packets of more flows are counted in the devices,
+//but there are no records of them, so a port scan cannot make the table grow without limit.
+const maxTrackedFlows = 1 << 16
+
+//Parts of the flow table, each one with its own lock, so the workers seldom wait for each other to add their packets.
+const flowTableShards = 64
+
+//Closed TCP connections are kept for this time after being stored, so their last packets (the ACK of the FIN) do not
+//start a new flow.
+const closedFlowLinger = 10 * time.Second
+
+type trackedFlow struct {
+	record database.FlowRecord
+	srcIsA bool //The source of the record is the first endpoint of the key
+	finFromSrc bool
+	finFromDst bool
+	ended string //Why the TCP connection ended (see database.FlowEndFin), or empty if it is open
+	stored bool //The TCP connection ended and its record was stored
+	last time.Time
+}
+
+//Tracks the flows (5-tuple and VLAN) of the captured traffic in both directions, and gets their records when they end:
+//after the idle timeout since their last packet, when their TCP connection is closed or reset, or after the active
+//timeout since the beginning of the record (long flows have several records). Shared by all the workers, as the
+//packets of a flow can be counted by any of them, but split in shards by the key of the flow (see flowTableShards).
+type FlowTable struct {
+	idleTimeout time.Duration
+	activeTimeout time.Duration
+	shards [flowTableShards]flowTableShard
+	full int32 //1 when a shard is full, so it is told only once
+	logger *log.Logger
+}
+
+type flowTableShard struct {
+	mutex sync.Mutex
+	flows map[flowKey]*trackedFlow
+}
+
+//Creates a flow table with the given timeouts.
+func NewFlowTable(idleTimeout time.Duration, activeTimeout time.Duration) *FlowTable {
+	t := &FlowTable{
+		idleTimeout: idleTimeout,
+		activeTimeout: activeTimeout,
+		logger: log.New(os.Stdout, "[FlowTable]: ", log.LstdFlags),
+	}
+	for i := range t.shards {
+		t.shards[i].flows = make(map[flowKey]*trackedFlow)
+	}
+	return t
+}
+
+//Gets the shard of the flow, with a FNV-1a hash of its key (without the interface, which is usually the same).
+func (t *FlowTable) shardOf(key flowKey) *flowTableShard {
+	hash := uint32(2166136261)
+	mix := func(b byte) {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	for i := range key.ipA {
+		mix(key.ipA[i])
+		mix(key.ipB[i])
+	}
+	mix(byte(key.portA))
+	mix(byte(key.portA >> 8))
+	mix(byte(key.portB))
+	mix(byte(key.portB >> 8))
+	mix(byte(key.vlan))
+	mix(key.protocol)
+	return &t.shards[hash % flowTableShards]
+}
+
+//Number of flows tracked.
+func (t *FlowTable) tracked() int {
+	count := 0
+	for i := range t.shards {
+		t.shards[i].mutex.Lock()
+		count += len(t.shards[i].flows)
+		t.shards[i].mutex.Unlock()
+	}
+	return count
+}
+
+//Adds the packet to its flow. Must be called before the packet is reversed, so the direction of the packet is known.
+//Packets without IP are not tracked.
+func (t *FlowTable) add(packet *capture.Packet, now time.Time) {
+	if t == nil || packet.IpType == 0 {
+		return
+	}
+
+	key, srcIsA := flowKeyOf(packet)
+	syn := packet.TcpFlags & capture.TcpSyn != 0 && packet.TcpFlags & capture.TcpAck == 0
+	sh := t.shardOf(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	f, ok := sh.flows[key]
+	if ok && f.stored {
+		//A new connection with the same ports replaces the closed one
+		if packet.Protocol != 6 || !syn {
+			f.last = now
+			return
+		}
+		delete(sh.flows, key)
+		ok = false
+	}
+	if !ok {
+		if len(sh.flows) >= maxTrackedFlows / flowTableShards {
+			if atomic.CompareAndSwapInt32(&t.full, 0, 1) {
+				t.logger.Println("The table is full, the new flows will not be tracked until some of them end")
+			}
+			return
+		}
+		f = newTrackedFlow(packet, srcIsA)
+		sh.flows[key] = f
+	}
+
+	forward := srcIsA == f.srcIsA
+	rate := samplingRateOf(packet)
+	if forward {
+		f.record.Bytes += packet.Bytes * rate
+		f.record.Packets += packet.PacketCount() * rate
+	} else {
+		f.record.ReplyBytes += packet.Bytes * rate
+		f.record.ReplyPackets += packet.PacketCount() * rate
+	}
+	if f.record.Start.IsZero() {
+		f.record.Start = now
+	}
+	f.record.End = now
+	f.last = now
+	if packet.ServerName != "" {
+		f.record.ServerName = packet.ServerName
+	}
+
+	if packet.Protocol == 6 {
+		f.record.TcpFlags |= packet.TcpFlags
+		if packet.TcpFlags & capture.TcpFin != 0 {
+			f.finFromSrc = f.finFromSrc || forward
+			f.finFromDst = f.finFromDst || !forward
+		}
+		if packet.TcpFlags & capture.TcpRst != 0 {
+			f.ended = database.FlowEndRst
+		} else if f.finFromSrc && f.finFromDst {
+			f.ended = database.FlowEndFin
+		}
+	}
+}
+
+//The source of the flow is the sender of the first packet, unless it is the answer to a SYN that was not seen.
+func newTrackedFlow(packet *capture.Packet, srcIsA bool) *trackedFlow {
+	f := &trackedFlow{
+		record: database.FlowRecord{
+			Interface: packet.Interface,
+			Vlan: packet.VlanID,
+			Protocol: packet.Protocol,
+			SrcIp: packet.SrcIp,
+			SrcPort: packet.SrcPort,
+			DstIp: packet.DstIp,
+			DstPort: packet.DstPort,
+		},
+		srcIsA: srcIsA,
+	}
+	if packet.Protocol == 6 && packet.TcpFlags & (capture.TcpSyn | capture.TcpAck) == capture.TcpSyn | capture.TcpAck {
+		f.record.SrcIp, f.record.DstIp = f.record.DstIp, f.record.SrcIp
+		f.record.SrcPort, f.record.DstPort = f.record.DstPort, f.record.SrcPort
+		f.srcIsA = !srcIsA
+	}
+	return f
+}
+
+//Gets the records of the flows that ended at the given time, and forgets them. With all, the records of all the flows
+//are returned, as the capture has ended.
+func (t *FlowTable) expire(now time.Time, all bool) []database.FlowRecord {
+	if t == nil {
+		return nil
+	}
+
+	records := make([]database.FlowRecord, 0)
+	full := false
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.mutex.Lock()
+		records = t.expireShard(sh, records, now, all)
+		full = full || len(sh.flows) >= maxTrackedFlows / flowTableShards
+		sh.mutex.Unlock()
+	}
+
+	if !full {
+		atomic.StoreInt32(&t.full, 0)
+	}
+	return records
+}
+
+//Appends the records of the flows of the shard that ended (see expire). Must be called with the lock of the shard.
+func (t *FlowTable) expireShard(sh *flowTableShard, records []database.FlowRecord, now time.Time, all bool) []database.FlowRecord {
+	for key, f := range sh.flows {
+		switch {
+		case f.stored:
+			if all || now.Sub(f.last) > closedFlowLinger {
+				delete(sh.flows, key)
+			}
+		case f.ended != "":
+			records = append(records, f.endRecord(f.ended))
+			f.stored = true
+			if all {
+				delete(sh.flows, key)
+			}
+		case all:
+			if f.record.Start.IsZero() {
+				delete(sh.flows, key)
+				continue
+			}
+			records = append(records, f.endRecord(database.FlowEndShutdown))
+			delete(sh.flows, key)
+		case now.Sub(f.last) >= t.idleTimeout:
+			//After an active timeout, the flow may have no packets in the next record
+			if !f.record.Start.IsZero() {
+				records = append(records, f.endRecord(database.FlowEndIdle))
+			}
+			delete(sh.flows, key)
+		case !f.record.Start.IsZero() && now.Sub(f.record.Start) >= t.activeTimeout:
+			records = append(records, f.endRecord(database.FlowEndActive))
+			f.record.Start = time.Time{}
+			f.record.Bytes, f.record.Packets, f.record.ReplyBytes, f.record.ReplyPackets = 0, 0, 0, 0
+			f.record.TcpFlags = 0
+		}
+	}
+	return records
+}
+
+func (f *trackedFlow) endRecord(reason string) database.FlowRecord {
+	record := f.record
+	record.Reason = reason
+	return record
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/database"
+)
+
+func tcpPacket(fromClient bool, flags uint8) *capture.Packet {
+	packet := &capture.Packet{
+		Bytes: 100,
+		IpType: 4,
+		Protocol: 6,
+		SrcIp: []byte{ 10, 0, 0, 2 },
+		DstIp: []byte{ 1, 1, 1, 1 },
+		SrcPort: 50000,
+		DstPort: 443,
+		TcpFlags: flags,
+	}
+	if !fromClient {
+		packet.Reverse()
+	}
+	return packet
+}
+
+func TestFlowTableClosedConnection(t *testing.T) {
+	table := NewFlowTable(15 * time.Second, time.Minute)
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	table.add(tcpPacket(true, capture.TcpSyn), now)
+	table.add(tcpPacket(false, capture.TcpSyn | capture.TcpAck), now)
+	hello := tcpPacket(true, capture.TcpAck)
+	hello.ServerName = "www.example.com"
+	table.add(hello, now.Add(time.Second))
+	table.add(tcpPacket(false, capture.TcpFin | capture.TcpAck), now.Add(2 * time.Second))
+	table.add(tcpPacket(true, capture.TcpFin | capture.TcpAck), now.Add(2 * time.Second))
+	records := table.expire(now.Add(3 * time.Second), false)
+	//The last ACK does not start a new flow
+	table.add(tcpPacket(false, capture.TcpAck), now.Add(3 * time.Second))
+
+	if len(records) != 1 {
+		t.Fatal("There should be one record, but there are", records)
+	}
+	r := records[0]
+	if r.Reason != database.FlowEndFin || r.SrcPort != 50000 || r.DstPort != 443 || !r.SrcIp.Equal([]byte{ 10, 0, 0, 2 }) {
+		t.Error("The record is not the expected one:", r)
+	}
+	if r.ServerName != "www.example.com" {
+		t.Error("The server name should be www.example.com, but is", r.ServerName)
+	}
+	if r.Packets != 3 || r.ReplyPackets != 2 || r.Bytes != 300 || r.ReplyBytes != 200 {
+		t.Error("The counters of the directions are not the expected ones:", r.Packets, r.ReplyPackets, r.Bytes, r.ReplyBytes)
+	}
+	if !r.Start.Equal(now) || !r.End.Equal(now.Add(2 * time.Second)) {
+		t.Error("The times are not the expected ones:", r.Start, r.End)
+	}
+	if r.TcpFlags != capture.TcpSyn | capture.TcpAck | capture.TcpFin {
+		t.Error("The flags are not the expected ones:", r.TcpFlags)
+	}
+	if records := table.expire(now.Add(20 * time.Second), false); len(records) != 0 || table.tracked() != 0 {
+		t.Error("The closed connection should be forgotten without more records, but there are", records)
+	}
+}
+
+func TestFlowTableSourceIsTheClientWhenTheSynWasNotSeen(t *testing.T) {
+	table := NewFlowTable(15 * time.Second, time.Minute)
+	now := time.Now()
+
+	table.add(tcpPacket(false, capture.TcpSyn | capture.TcpAck), now)
+	table.add(tcpPacket(true, capture.TcpRst), now)
+	records := table.expire(now, false)
+
+	if len(records) != 1 || records[0].Reason != database.FlowEndRst || records[0].SrcPort != 50000 {
+		t.Fatal("There should be a reset record from the client, but there are", records)
+	}
+	if records[0].Packets != 1 || records[0].ReplyPackets != 1 {
+		t.Error("There should be a packet in each direction, but there are", records[0].Packets, records[0].ReplyPackets)
+	}
+}
+
+func TestFlowTableTimeouts(t *testing.T) {
+	table := NewFlowTable(15 * time.Second, time.Minute)
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	packet := &capture.Packet{ Bytes: 100, IpType: 4, Protocol: 17, SrcIp: []byte{ 10, 0, 0, 2 }, DstIp: []byte{ 1, 1, 1, 1 },
+		SrcPort: 40000, DstPort: 443, SamplingRate: 2 }
+
+	for i := 0; i <= 60; i += 10 {
+		table.add(packet, now.Add(time.Duration(i) * time.Second))
+	}
+	active := table.expire(now.Add(60 * time.Second), false)
+	table.add(packet, now.Add(61 * time.Second))
+	idle := table.expire(now.Add(80 * time.Second), false)
+
+	if len(active) != 1 || active[0].Reason != database.FlowEndActive || active[0].Packets != 14 || active[0].Bytes != 1400 {
+		t.Error("There should be an active record with 7 sampled packets, but there are", active)
+	}
+	if len(idle) != 1 || idle[0].Reason != database.FlowEndIdle || idle[0].Packets != 2 || !idle[0].Start.Equal(now.Add(61 * time.Second)) {
+		t.Error("There should be an idle record with the packet after the active one, but there are", idle)
+	}
+	if table.tracked() != 0 {
+		t.Error("The idle flow should be forgotten")
+	}
+}
+
+func TestFlowTableShutdownStoresTheOpenFlows(t *testing.T) {
+	table := NewFlowTable(15 * time.Second, time.Minute)
+	now := time.Now()
+
+	table.add(tcpPacket(true, capture.TcpSyn), now)
+	table.add(&capture.Packet{ Bytes: 100 }, now)
+	records := table.expire(now, true)
+
+	if len(records) != 1 || records[0].Reason != database.FlowEndShutdown {
+		t.Error("There should be one shutdown record (packets without IP are not tracked), but there are", records)
+	}
+	var nilTable *FlowTable
+	nilTable.add(tcpPacket(true, capture.TcpSyn), now)
+	if nilTable.expire(now, true) != nil {
+		t.Error("A nil table has no records")
+	}
+}
+
+func TestFlowTableSharedByWorkers(t *testing.T) {
+	table := NewFlowTable(15 * time.Second, time.Minute)
+	now := time.Now()
+
+	//Every worker adds a packet of every flow in both directions, as the packets of a flow can go to any worker
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := 1; port <= 200; port++ {
+				table.add(&capture.Packet{ Bytes: 100, IpType: 4, Protocol: 17, SrcIp: []byte{ 10, 0, 0, 2 },
+					DstIp: []byte{ 1, 1, 1, 1 }, SrcPort: uint16(port), DstPort: 53 }, now)
+				table.add(&capture.Packet{ Bytes: 200, IpType: 4, Protocol: 17, SrcIp: []byte{ 1, 1, 1, 1 },
+					DstIp: []byte{ 10, 0, 0, 2 }, SrcPort: 53, DstPort: uint16(port) }, now)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if table.tracked() != 200 {
+		t.Error("There should be 200 flows, but there are", table.tracked())
+	}
+	records := table.expire(now, true)
+	if len(records) != 200 {
+		t.Fatal("There should be 200 records, but there are", len(records))
+	}
+	for _, r := range records {
+		if r.DstPort != 53 || r.Packets != 4 || r.Bytes != 400 || r.ReplyPackets != 4 || r.ReplyBytes != 800 {
+			t.Error("The record of every flow should have the packets of all the workers, but it is", r)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"net"
+	"github.com/melchor629/speedy/capture"
+)
+
+//In mirror (SPAN port) mode the capture device is not part of the traffic, so both ends of every frame are credited:
+//the sender gets the upload and the receiver the download. The Internet side is told by the MACs of the uplinks (the
+//routers) or by the networks that are outside the LAN.
+type MirrorClassifier struct {
+	uplinkNetworks []*net.IPNet
+	uplinkMacs []net.HardwareAddr
+}
+
+//Creates a mirror classifier with the given uplink networks and MACs (both can be empty).
+func NewMirrorClassifier(uplinkNetworks []*net.IPNet, uplinkMacs []net.HardwareAddr) *MirrorClassifier {
+	return &MirrorClassifier{ uplinkNetworks, uplinkMacs }
+}
+
+//Parses the mirror classifier from comma separated lists of CIDRs and MACs of the uplinks.
+func ParseMirrorClassifier(uplinkNetworks string, uplinkMacs string) (*MirrorClassifier, error) {
+	classifier := &MirrorClassifier{}
+	for _, network := range splitList(uplinkNetworks) {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		classifier.uplinkNetworks = append(classifier.uplinkNetworks, ipNet)
+	}
+
+	for _, mac := range splitList(uplinkMacs) {
+		hwAddr, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, err
+		}
+		classifier.uplinkMacs = append(classifier.uplinkMacs, hwAddr)
+	}
+
+	return classifier, nil
+}
+
+//Returns true if there are neither uplink networks nor uplink MACs. In that case, every device is a local one.
+func (c *MirrorClassifier) IsEmpty() bool {
+	return len(c.uplinkNetworks) == 0 && len(c.uplinkMacs) == 0
+}
+
+//Tells which ends of the packet are in the LAN. An end is on the Internet side if its MAC is one of the uplinks or its
+//IP is inside one of the uplink networks. Multicast and broadcast destinations are always in the LAN.
+func (c *MirrorClassifier) Classify(p *capture.Packet) (srcLocal bool, dstLocal bool) {
+	srcLocal = !c.isUplink(p.SrcMac, p.SrcIp)
+	dstLocal = isGroupMac(p.DstMac) || !c.isUplink(p.DstMac, p.DstIp)
+	return
+}
+
+func (c *MirrorClassifier) isUplink(mac net.HardwareAddr, ip net.IP) bool {
+	for _, uplinkMac := range c.uplinkMacs {
+		if sameMac(uplinkMac, mac) {
+			return true
+		}
+	}
+	return capture.ContainsIP(c.uplinkNetworks, ip)
+}
+
+//Broadcast and multicast MACs have the group bit set.
+func isGroupMac(mac net.HardwareAddr) bool {
+	return len(mac) != 0 && mac[0] & 1 != 0
+}
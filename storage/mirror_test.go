@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Unicast MACs: in mirror mode, the traffic to group MACs has no device to credit the download to
+var mirrorDeviceMac = []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+var otherMirrorDeviceMac = []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x77 }
+
+func TestMirrorClassifyBetweenLocalDevices(t *testing.T) {
+	classifier, _ := ParseMirrorClassifier("", "00:00:5e:00:01:01")
+	packet := capture.Packet{ SrcMac: mirrorDeviceMac, DstMac: otherMirrorDeviceMac }
+
+	srcLocal, dstLocal := classifier.Classify(&packet)
+	if !srcLocal || !dstLocal {
+		t.Error("Both ends should be local, but are", srcLocal, dstLocal)
+	}
+}
+
+func TestMirrorClassifyByUplinkMAC(t *testing.T) {
+	classifier, _ := ParseMirrorClassifier("", "00:00:5e:00:01:01")
+	packet := capture.Packet{ SrcMac: mirrorDeviceMac, DstMac: gatewayMac }
+
+	srcLocal, dstLocal := classifier.Classify(&packet)
+	if !srcLocal || dstLocal {
+		t.Error("Only the source should be local, but are", srcLocal, dstLocal)
+	}
+}
+
+func TestMirrorClassifyByUplinkNetwork(t *testing.T) {
+	classifier, _ := ParseMirrorClassifier("0.0.0.0/1", "")
+	packet := capture.Packet{
+		SrcMac: gatewayMac, DstMac: mirrorDeviceMac,
+		SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 192, 168, 1, 10 },
+	}
+
+	srcLocal, dstLocal := classifier.Classify(&packet)
+	if srcLocal || !dstLocal {
+		t.Error("Only the destination should be local, but are", srcLocal, dstLocal)
+	}
+}
+
+func TestMirrorClassifyBroadcastStaysInTheLAN(t *testing.T) {
+	classifier, _ := ParseMirrorClassifier("", "00:00:5e:00:01:01")
+	packet := capture.Packet{ SrcMac: mirrorDeviceMac, DstMac: []byte{ 0xff, 0xff, 0xff, 0xff, 0xff, 0xff } }
+
+	srcLocal, dstLocal := classifier.Classify(&packet)
+	if !srcLocal || !dstLocal {
+		t.Error("Both ends should be local, but are", srcLocal, dstLocal)
+	}
+}
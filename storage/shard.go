@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"net"
+	"sync"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+//Identifies a device in the counters of a worker without building a string for every packet: the MAC (EUI-48) or, in
+//links without MACs, the IP, plus the interface and the VLAN.
+type deviceKey struct {
+	iface string
+	vlan uint16
+	ipKeyed bool
+	mac [6]byte
+	ip [16]byte
+}
+
+func deviceKeyOf(packet *capture.Packet, ipKeyed bool) deviceKey {
+	key := deviceKey{ iface: packet.Interface, vlan: packet.VlanID, ipKeyed: ipKeyed }
+	if ipKeyed {
+		copy(key.ip[:], packet.SrcIp.To16())
+	} else {
+		copy(key.mac[:], packet.SrcMac)
+	}
+	return key
+}
+
+//Gets the key of the device in the table that is stored (see entryKey).
+func (k deviceKey) String() string {
+	if k.ipKeyed {
+		ip := net.IP(k.ip[:])
+		if ip.IsUnspecified() {
+			ip = nil
+		}
+		return entryKey(k.iface, k.vlan, "ip/" + ip.String())
+	}
+	return entryKey(k.iface, k.vlan, net.HardwareAddr(k.mac[:]).String())
+}
+
+//The traffic of a device counted by one worker since the last merge.
+type shardEntry struct {
+	Entry
+	key string
+	ipKeyed bool
+}
+
+//The counters of one worker. Only its worker counts in them, so the lock is only contended by the merge of every
+//second. The packets captured after the end of the interval that is being counted (but before it is merged) are
+//counted apart, in the next interval.
+type shard struct {
+	mutex sync.Mutex
+	entries map[deviceKey]*shardEntry
+	next map[deviceKey]*shardEntry
+	udpFlows map[flowKey]*udpFlowSeen
+	nextUdpFlows map[flowKey]*udpFlowSeen
+	end time.Time
+}
+
+//Creates the counters of a worker, counting the interval that ends at the given time (if zero, all the packets are
+//counted in the interval until the first merge).
+func newShard(end time.Time) *shard {
+	return &shard{
+		entries: make(map[deviceKey]*shardEntry),
+		next: make(map[deviceKey]*shardEntry),
+		udpFlows: make(map[flowKey]*udpFlowSeen),
+		nextUdpFlows: make(map[flowKey]*udpFlowSeen),
+		end: end,
+	}
+}
+
+//Returns true if the time is after the end of the interval that is being counted, so it is counted in the next one.
+//Must be called with the lock held.
+func (sh *shard) isNext(now time.Time) bool {
+	return !sh.end.IsZero() && !now.Before(sh.end)
+}
+
+//Remembers that the worker has seen a packet of the UDP flow in the interval. If it is the first one, returns the
+//record of the flow, to remember the entries credited by the packet.
+func (sh *shard) seeUdpFlow(packet *capture.Packet, now time.Time) *udpFlowSeen {
+	key, _ := flowKeyOf(packet)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	flows := sh.udpFlows
+	if sh.isNext(now) {
+		flows = sh.nextUdpFlows
+	}
+
+	seen, ok := flows[key]
+	if !ok {
+		seen = &udpFlowSeen{ first: now, last: now }
+		flows[key] = seen
+		return seen
+	}
+	if now.After(seen.last) {
+		seen.last = now
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"github.com/melchor629/speedy/capture"
+)
+
+func TestDeviceKeyStringIsTheEntryKey(t *testing.T) {
+	packet := &capture.Packet{
+		Interface: "eth0",
+		VlanID: 10,
+		SrcMac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 },
+		SrcIp: []byte{ 10, 0, 0, 2 },
+	}
+
+	if key := deviceKeyOf(packet, false).String(); key != "eth0/vlan10/12:22:33:44:55:66" {
+		t.Error("Unexpected key for the MAC:", key)
+	}
+	if key := deviceKeyOf(packet, true).String(); key != "eth0/vlan10/ip/10.0.0.2" {
+		t.Error("Unexpected key for the IP:", key)
+	}
+	if key := deviceKeyOf(&capture.Packet{}, true).String(); key != "ip/<nil>" {
+		t.Error("Unexpected key without IP:", key)
+	}
+}
+
+func TestStartWithWorkersMergesTheCounters(t *testing.T) {
+	s := Storage{ Workers: 4 }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+
+	done := startInBackground(&s, &c, &d)
+	for i := 0; i < 1000; i++ {
+		c.p <- &capture.Packet{
+			Bytes: 100,
+			SrcMac: []byte{ 0x12, 0, 0, 0, 0, byte(i % 4) },
+			DstMac: c.GetMAC(),
+			IpType: 4,
+			SrcIp: []byte{ 10, 0, 0, byte(i % 4) },
+		}
+	}
+	c.Close()
+	<- done
+
+	if len(s.db) != 4 {
+		t.Error("Expected 4 entries, but there are", len(s.db))
+		t.FailNow()
+	}
+	for i := 0; i < 4; i++ {
+		e := s.db["12:00:00:00:00:0" + string(rune('0' + i))]
+		if e.accumulatedUpload != 250 * 100 || e.samples != 250 {
+			t.Error("Entry", i, "has", e.accumulatedUpload, "bytes in", e.samples, "packets")
+		}
+		if e.ipv4.String() != "10.0.0." + string(rune('0' + i)) {
+			t.Error("Entry", i, "has the IPv4", e.ipv4)
+		}
+	}
+}
+
+func TestMergeReportsTheChangesOfMetadataOnce(t *testing.T) {
+	s := Storage{ db: make(map[string]Entry), shards: []*shard{ newShard(time.Time{}), newShard(time.Time{}) } }
+	packet := &capture.Packet{
+		Bytes: 100,
+		SrcMac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 },
+		IpType: 4,
+		SrcIp: []byte{ 10, 0, 0, 2 },
+	}
+	now := time.Now()
+
+	s.addTraffic(s.shards[0], packet, false, false, false, 0, nil, now)
+	s.addTraffic(s.shards[1], packet, false, true, false, 0, nil, now)
+	changed := s.merge(now)
+	if len(changed) != 1 {
+		t.Error("The new IP should be reported once, but was reported", len(changed), "times")
+	}
+
+	e := s.db["12:22:33:44:55:66"]
+	if e.accumulatedUpload != 100 || e.accumulatedDownload != 100 || e.samples != 2 {
+		t.Error("The counters of both workers were not merged:", e.accumulatedUpload, e.accumulatedDownload, e.samples)
+	}
+
+	s.addTraffic(s.shards[1], packet, false, false, false, 0, nil, now)
+	if changed := s.merge(now.Add(time.Second)); len(changed) != 0 {
+		t.Error("The IP has not changed, but was reported", len(changed), "times")
+	}
+	if e := s.db["12:22:33:44:55:66"]; e.accumulatedUpload != 200 {
+		t.Error("The upload should be 200, but is", e.accumulatedUpload)
+	}
+}
+
+func benchmarkPackets(mac []byte) []*capture.Packet {
+	packets := make([]*capture.Packet, 256)
+	for i := range packets {
+		packets[i] = &capture.Packet{
+			Bytes: 100,
+			SrcMac: []byte{ 0x12, 0, 0, 0, 0, byte(i) },
+			DstMac: mac,
+			IpType: 4,
+			SrcIp: []byte{ 10, 0, 0, byte(i) },
+		}
+	}
+	return packets
+}
+
+//Counts the packets of 256 devices, sent from several gorutines as the capture does with fanout, and reports how many
+//packets per second the storage can count with the given number of workers.
+func benchmarkStart(b *testing.B, workers int) {
+	c := dumbCapturer{ p: make(chan *capture.Packet, capture.PacketsBufferSize) }
+	packets := benchmarkPackets(c.GetMAC())
+	s := Storage{ Workers: workers }
+	d := dumbDB{}
+
+	start := time.Now()
+	b.ResetTimer()
+	done := startInBackground(&s, &c, &d)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.p <- packets[i % len(packets)]
+			i++
+		}
+	})
+	c.Close()
+	<- done
+	b.ReportMetric(float64(b.N) / time.Since(start).Seconds(), "packets/s")
+}
+
+func BenchmarkStart1Worker(b *testing.B) { benchmarkStart(b, 1) }
+func BenchmarkStart2Workers(b *testing.B) { benchmarkStart(b, 2) }
+func BenchmarkStart4Workers(b *testing.B) { benchmarkStart(b, 4) }
+
+//The counting as it was before the workers, to compare with: a single gorutine reads the packets from an unbuffered
+//channel, and every packet takes the lock of the whole map, whose key has the MAC as text.
+type referenceStorage struct {
+	mutex sync.RWMutex
+	db map[string]Entry
+}
+
+func (s *referenceStorage) addTraffic(packet *capture.Packet, reversed bool, now time.Time) {
+	s.mutex.Lock()
+	key := entryKey(packet.Interface, packet.VlanID, packet.SrcMac.String())
+	elem, ok := s.db[key]
+	if !ok {
+		elem = Entry{ iface: packet.Interface, vlan: packet.VlanID, mac: packet.SrcMac }
+	}
+	if packet.IsIP4() {
+		elem.ipv4 = packet.SrcIp
+	} else if packet.IsIP6() {
+		elem.ipv6 = packet.SrcIp
+	}
+
+	bytes := AccountingL3.BytesOf(packet) * samplingRateOf(packet)
+	elem.samples++
+	if reversed {
+		elem.accumulatedDownload += bytes
+	} else {
+		elem.accumulatedUpload += bytes
+	}
+	elem.modifiedAt(now)
+	s.db[key] = elem
+	s.mutex.Unlock()
+}
+
+func BenchmarkStartReference(b *testing.B) {
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	packets := benchmarkPackets(c.GetMAC())
+	s := referenceStorage{ db: make(map[string]Entry) }
+
+	start := time.Now()
+	b.ResetTimer()
+	done := make(chan bool)
+	go func() {
+		for packet := range c.Packets() {
+			s.addTraffic(packet, packet.IsReversed(c.GetMAC()), time.Now())
+		}
+		done <- true
+	}()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.p <- packets[i % len(packets)]
+			i++
+		}
+	})
+	c.Close()
+	<- done
+	b.ReportMetric(float64(b.N) / time.Since(start).Seconds(), "packets/s")
+}
@@ -3,116 +3,616 @@ package storage
 import (
 	"github.com/melchor629/speedy/capture"
 	"github.com/melchor629/speedy/database"
+	"github.com/melchor629/speedy/geoip"
 	"log"
+	"net"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// The key is the MAC Address as String (to be easily hasheable in go I suppose)
+//The data is stored every second, in intervals aligned to the second boundaries of the wall clock. The interval is
+//stored a bit after its end, so the packets captured before the end that are still being decoded are counted in it.
+const intervalGrace = 100 * time.Millisecond
+
+//Gets where the remote ends are, to store the traffic by country and by autonomous system (see geoip.Database).
+type Locator interface {
+	Lookup(ip net.IP) geoip.Location
+}
+
+//Receives the captured packets before they are counted (for example, to export them as flows). The packet must not be
+//modified nor kept, as the storage will modify it.
+type PacketObserver interface {
+	Observe(packet *capture.Packet)
+}
+
+// The key is the MAC Address as String (to be easily hasheable in go I suppose), prefixed by the interface and the VLAN
+// if known (see entryKey). In links without MAC addresses, the IP is used instead. The workers count in their own
+// shards, keyed by the binary MAC, that are merged into this table every second.
 type Storage struct {
+	//Traffic that matches these rules is not counted (can be nil)
+	ExclusionRules *ExclusionRules
+	//Tells the direction of the packets. If nil, the MAC of the capture interface is used (can be nil)
+	Classifier *DirectionClassifier
+	//If set, the capture is done from a mirror port and both ends of the packets are credited (can be nil)
+	Mirror *MirrorClassifier
+	//Which bytes of the packets are counted (L3 by default)
+	Accounting Accounting
+	//Sees all the packets before they are counted, as they were captured (can be nil)
+	Observer PacketObserver
+	//Number of gorutines that count the packets, each one with its own counters (1 if not set)
+	Workers int
+	//Number of remote ports of every device stored every second, the ones with more traffic (none if not set)
+	TopPorts int
+	//Number of remote domains of every device stored every second, the ones with more traffic (none if not set)
+	TopDomains int
+	//Knows the names of the remote ends from the DNS responses, to store the traffic by domain (can be nil)
+	Domains *DomainCache
+	//Tracks the flows and stores their records when they end, if the database can store them (can be nil)
+	Flows *FlowTable
+	//Knows the country and the autonomous system of the remote ends, to store the traffic by them (can be nil)
+	Locator Locator
+	//Number of remote autonomous systems of every device stored every second, the ones with more traffic (none if not set)
+	TopAsns int
+	//Assigns an application and a category to the traffic, to store the traffic by category and the application of
+	//the flows (can be nil)
+	Applications *ApplicationRules
+
 	db map[string]Entry
+	shards []*shard
+	down map[string]bool
+	udpFlows *udpFlows
+	intervalEnd time.Time
 	mutex sync.RWMutex
+	excludedBytes uint64
+	reportedExcludedBytes uint64
 }
 
 //Starts capturing the traffic, processing them and then storing it into the database every second. The recommended way
-//is to call this function as a gorutine.
+//is to call this function as a gorutine. The packets are counted by the configured number of workers, each one with its
+//own counters, which are merged before being stored. If the capture context is offline, the seconds are counted using
+//the timestamps of the packets (so there is only one worker) and the function returns when all the packets have been
+//processed.
 func (s *Storage) Start(capturer capture.Context, db database.Database) {
 	s.db = make(map[string]Entry)
+	s.udpFlows = newUdpFlows()
+	offline := capture.IsOffline(capturer)
+	workers := s.Workers
+	if workers < 1 || offline {
+		workers = 1
+	}
+	//Without packets, the first interval of an offline context is not known yet
+	var end time.Time
+	if !offline {
+		end = time.Now().Truncate(time.Second).Add(time.Second)
+	}
+	s.shards = make([]*shard, workers)
+	for i := range s.shards {
+		s.shards[i] = newShard(end)
+	}
+
 	stop := make(chan bool)
 	go capturer.StartCapturing()
-	go s.storeInDB(db, stop)
+	if !offline {
+		go s.storeInDB(capturer, db, stop)
+	}
 
-	for packet := range capturer.Packets() {
-		reversed := false
-		if packet.IsReversed(capturer.GetMAC()) {
-			packet.Reverse()
-			reversed = true
+	//Without MACs, the local device is the one whose IP is inside the networks of the interface
+	networks := capture.Networks(capturer)
+	networksClassifier := NewDirectionClassifier(networks, nil)
+	if offline {
+		var nextStore time.Time
+		for packet := range capturer.Packets() {
+			now := packet.Timestamp
+			if nextStore.IsZero() {
+				nextStore = now.Truncate(time.Second).Add(time.Second)
+			}
+
+			for !now.Before(nextStore) {
+				s.storeInDBAt(db, nextStore)
+				nextStore = nextStore.Add(time.Second)
+			}
+
+			s.count(s.shards[0], capturer, networks, networksClassifier, packet, now)
 		}
 
-		if packet.IsBroadcast() || packet.IsIPv6Multicast() {
-			continue
+		//Stores the last (and incomplete) second
+		if !nextStore.IsZero() {
+			s.storeInDBAt(db, nextStore)
+			s.storeFlows(db, s.Flows.expire(nextStore, true))
 		}
+		s.reportExcludedBytes(log.New(os.Stdout, "[Storage]: ", 0))
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, sh := range s.shards {
+		wg.Add(1)
+		go func(sh *shard) {
+			defer wg.Done()
+			for packet := range capturer.Packets() {
+				now := packet.Timestamp
+				if now.IsZero() {
+					now = time.Now()
+				}
+				s.count(sh, capturer, networks, networksClassifier, packet, now)
+			}
+		}(sh)
+	}
+	wg.Wait()
+	stop <- true
+
+	//The traffic of the last (and incomplete) interval and of the packets captured after its end is left in the table
+	end = time.Now().Truncate(time.Second).Add(time.Second)
+	for _, entry := range append(s.merge(end), s.merge(end.Add(time.Second))...) {
+		s.storeChangeOfMetadata(db, entry)
+	}
+	s.storeFlows(db, s.Flows.expire(end, true))
+}
+
+//Decides who is credited with the packet and counts it in the counters of the worker.
+func (s *Storage) count(sh *shard, capturer capture.Context, networks []*net.IPNet, networksClassifier *DirectionClassifier, packet *capture.Packet, now time.Time) {
+	if s.Observer != nil {
+		s.Observer.Observe(packet)
+	}
+
+	if s.ExclusionRules != nil && s.ExclusionRules.Excludes(packet) {
+		atomic.AddUint64(&s.excludedBytes, s.Accounting.BytesOf(packet) * samplingRateOf(packet))
+		return
+	}
+
+	newFlows := newFlowsOf(packet)
+	var udpFlow *udpFlowSeen
+	if packet.Protocol == 17 && s.udpFlows != nil {
+		udpFlow = sh.seeUdpFlow(packet, now)
+	}
+	s.Flows.add(packet, now)
+	s.Domains.learn(packet, now)
 
-		s.mutex.Lock()
-		elem, ok := s.db[packet.SrcMac.String()]
-		if !ok {
-			elem = Entry{ mac: packet.SrcMac }
+	if s.Mirror != nil {
+		//Both ends are credited, the receiver after the sender. The MAC of the interface does not matter here
+		ipKeyed := len(packet.SrcMac) == 0
+		srcLocal, dstLocal := s.Mirror.Classify(packet)
+		if srcLocal {
+			s.addTraffic(sh, packet, ipKeyed, false, dstLocal, newFlows, udpFlow, now)
+		}
+		//Broadcast and multicast traffic stays in the LAN, but there is no device to credit the download to
+		if dstLocal && !isGroupMac(packet.DstMac) {
+			packet.Reverse()
+			s.addTraffic(sh, packet, ipKeyed, true, srcLocal, newFlows, udpFlow, now)
 		}
+		return
+	}
 
-		changedMetadata := false
-		if packet.IsIP4() {
-			changedMetadata = !elem.ipv4.Equal(packet.SrcIp)
-			elem.ipv4 = packet.SrcIp
-		} else if packet.IsIP6() {
-			changedMetadata = !elem.ipv6.Equal(packet.SrcIp)
-			elem.ipv6 = packet.SrcIp
+	mac := capture.InterfaceMAC(capturer, packet)
+	ipKeyed := len(mac) == 0 || len(packet.SrcMac) == 0
+	reversed := false
+	local := false
+	classifier := s.Classifier
+	if classifier == nil && ipKeyed {
+		classifier = networksClassifier
+	}
+
+	if classifier != nil {
+		switch classifier.Classify(packet) {
+		case DirectionDownload:
+			packet.Reverse()
+			reversed = true
+		case DirectionLocal:
+			//LAN-internal traffic is counted as local upload of the sender and local download of the receiver
+			local = true
+		case DirectionTransit:
+			return
 		}
+	} else {
+		local = isLocalTraffic(packet, mac, networks)
+		if packet.IsReversed(mac) {
+			packet.Reverse()
+			reversed = true
+		}
+	}
+
+	s.addTraffic(sh, packet, ipKeyed, reversed, local, newFlows, udpFlow, now)
+	//The receiver of the LAN-internal traffic is credited too, as in mirror mode, unless it is the router (or a group)
+	if local && !reversed && !isGroupMac(packet.DstMac) && (len(mac) == 0 || !sameMac(mac, packet.DstMac)) &&
+		(classifier == nil || !classifier.isGateway(packet.DstMac)) {
+		packet.Reverse()
+		s.addTraffic(sh, packet, ipKeyed, true, true, newFlows, udpFlow, now)
+	}
+}
+
+//Gets the connections that the packet opens: a TCP SYN (scaled when sampled). The first packets of the UDP flows are
+//known when the interval is merged (see udpFlows). With sampling, the UDP flows are seen when their first packet is
+//sampled, so they are not scaled.
+func newFlowsOf(packet *capture.Packet) uint64 {
+	if packet.Protocol == 6 && packet.TcpFlags & capture.TcpSyn != 0 && packet.TcpFlags & capture.TcpAck == 0 {
+		return samplingRateOf(packet)
+	}
+	return 0
+}
+
+//Adds the bytes of the packet to the entry of its source device in the counters of the worker, as download if reversed
+//or as upload otherwise. The local traffic goes to the local counters. The packet is counted in the interval of the
+//time when it was captured. If the packet is the first of a UDP flow in the interval, the entry is remembered in it.
+func (s *Storage) addTraffic(sh *shard, packet *capture.Packet, ipKeyed bool, reversed bool, local bool, newFlows uint64, udpFlow *udpFlowSeen, now time.Time) {
+	if !ipKeyed && (packet.IsBroadcast() || packet.IsIPv6Multicast()) {
+		return
+	}
+
+	key := deviceKeyOf(packet, ipKeyed)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	entries := sh.entries
+	if sh.isNext(now) {
+		entries = sh.next
+	}
+	elem, ok := entries[key]
+	if !ok {
+		elem = &shardEntry{ Entry: Entry{ iface: packet.Interface, vlan: packet.VlanID }, key: key.String(), ipKeyed: ipKeyed }
+		if !ipKeyed {
+			elem.mac = packet.SrcMac
+		}
+		entries[key] = elem
+	}
+
+	if packet.IsIP4() {
+		elem.ipv4 = packet.SrcIp
+	} else if packet.IsIP6() {
+		elem.ipv6 = packet.SrcIp
+	}
+
+	//A sampled packet represents several packets, so the bytes are scaled back
+	rate := samplingRateOf(packet)
+	bytes := s.Accounting.BytesOf(packet) * rate
+	packets := packet.PacketCount() * rate
+	elem.samples++
+	elem.newFlows += newFlows
+	if udpFlow != nil {
+		udpFlow.entries = append(udpFlow.entries, elem)
+	}
+	domain := s.Domains.nameOf(packet, now)
+	location := s.locationOf(packet.DstIp)
+	application := s.Applications.classify(remoteEnd{ packet.L4(), packet.DstIp, packet.RemotePort(), location.Asn, domain })
+	elem.breakdown.add(packet, domain, location, application.Category, bytes, reversed)
+	if reversed {
+		elem.downloadPackets += packets
+	} else {
+		elem.uploadPackets += packets
+	}
+	if uint32(rate) > elem.samplingRate {
+		elem.samplingRate = uint32(rate)
+	}
+	switch {
+	case reversed && local:
+		elem.accumulatedLocalDownload += bytes
+	case reversed:
+		elem.accumulatedDownload += bytes
+	case local:
+		elem.accumulatedLocalUpload += bytes
+	default:
+		elem.accumulatedUpload += bytes
+	}
+
+	elem.modifiedAt(now)
+}
+
+//Gets where the IP is, or an empty location if it is not known or there is no locator.
+func (s *Storage) locationOf(ip net.IP) geoip.Location {
+	if s.Locator == nil || ip == nil {
+		return geoip.Location{}
+	}
+	return s.Locator.Lookup(ip)
+}
 
-		if reversed {
-			elem.accumulatedDownload += uint64(packet.Bytes)
-		} else {
-			elem.accumulatedUpload += uint64(packet.Bytes)
+//Moves the counters of the interval that ends at the given time from the workers to the table that is stored, so the
+//workers never wait for the database. The packets captured after the end start the next interval. Returns the entries
+//whose IPs have changed since the last merge (only the ones with MAC, as the metadata is stored by MAC).
+func (s *Storage) merge(end time.Time) []Entry {
+	changed := make([]Entry, 0)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, sh := range s.shards {
+		sh.mutex.Lock()
+		for key, seen := range sh.udpFlows {
+			if s.udpFlows.see(key, seen.first, seen.last) {
+				for _, counted := range seen.entries {
+					counted.newFlows++
+				}
+			}
+			delete(sh.udpFlows, key)
 		}
 
-		elem.modified()
-		s.db[packet.SrcMac.String()] = elem
-		if changedMetadata {
-			go s.storeChangeOfMetadata(db, elem)
+		for _, counted := range sh.entries {
+			if counted.samples == 0 {
+				continue
+			}
+
+			elem, ok := s.db[counted.key]
+			if !ok {
+				elem = Entry{ iface: counted.iface, vlan: counted.vlan, mac: counted.mac }
+			}
+
+			changedMetadata := false
+			if counted.ipv4 != nil && !elem.ipv4.Equal(counted.ipv4) {
+				changedMetadata = true
+				elem.ipv4 = counted.ipv4
+			}
+			if counted.ipv6 != nil && !elem.ipv6.Equal(counted.ipv6) {
+				changedMetadata = true
+				elem.ipv6 = counted.ipv6
+			}
+
+			elem.accumulatedDownload += counted.accumulatedDownload
+			elem.accumulatedUpload += counted.accumulatedUpload
+			elem.accumulatedLocalDownload += counted.accumulatedLocalDownload
+			elem.accumulatedLocalUpload += counted.accumulatedLocalUpload
+			elem.downloadPackets += counted.downloadPackets
+			elem.uploadPackets += counted.uploadPackets
+			elem.newFlows += counted.newFlows
+			elem.breakdown.merge(&counted.breakdown)
+			elem.samples += counted.samples
+			if counted.samplingRate > elem.samplingRate {
+				elem.samplingRate = counted.samplingRate
+			}
+			if counted.lastModified.After(elem.lastModified) {
+				elem.modifiedAt(counted.lastModified)
+			}
+			s.db[counted.key] = elem
+			counted.ClearSpeed()
+
+			if changedMetadata && !counted.ipKeyed {
+				changed = append(changed, elem)
+			}
 		}
-		s.mutex.Unlock()
+		sh.entries, sh.next = sh.next, sh.entries
+		sh.udpFlows, sh.nextUdpFlows = sh.nextUdpFlows, sh.udpFlows
+		sh.end = end.Add(time.Second)
+		sh.mutex.Unlock()
 	}
+	return changed
+}
 
-	stop <- true
+func samplingRateOf(packet *capture.Packet) uint64 {
+	if packet.SamplingRate < 1 {
+		return 1
+	}
+	return uint64(packet.SamplingRate)
+}
+
+//The same device could be seen from different interfaces or VLANs, so both are part of the key. The device is
+//identified by its MAC or, in links without MACs, by its IP.
+func entryKey(iface string, vlan uint16, device string) string {
+	key := device
+	if vlan != 0 {
+		key = "vlan" + strconv.Itoa(int(vlan)) + "/" + key
+	}
+	if iface != "" {
+		key = iface + "/" + key
+	}
+	return key
 }
 
-//Every second, gets a copy of the memory db and stores them into the good old db. Also cleans the unused entries.
-func (s *Storage) storeInDB(db database.Database, stop chan bool) {
+//Every second (just after the second boundary), gets a copy of the memory db and stores them into the good old db. Also
+//cleans the unused entries. Every minute, reports the excluded bytes and the statistics of the capture. The gap markers
+//of the capture are stored as soon as they arrive.
+func (s *Storage) storeInDB(capturer capture.Context, db database.Database, stop chan bool) {
 	logger := log.New(os.Stdout, "[Storage]: ", 0)
-	timer := time.NewTicker(1 * time.Second)
+	gaps := capture.Gaps(capturer)
+	timer := time.NewTimer(time.Until(time.Now().Truncate(time.Second).Add(time.Second + intervalGrace)))
 	defer timer.Stop()
 	logger.Println("Starting storeInDB gorutine")
 	itsTimeToStop := false
+	ticks := 0
 	for !itsTimeToStop {
 		select {
 		case <- stop:
 			logger.Println("Stopping storeInDB gorutine...")
 			itsTimeToStop = true
+		case gap, ok := <- gaps:
+			if !ok {
+				gaps = nil
+			} else {
+				s.markGap(gap, db, logger)
+			}
 		case <- timer.C:
-			go db.Store(s.getCopyAndClearSpeed())
-			s.cleanUpOldEntries()
+			//If the gorutine was late (the machine was suspended, for example), the missed intervals are joined
+			end := time.Now().Add(-intervalGrace).Truncate(time.Second)
+			for _, entry := range s.merge(end) {
+				go s.storeChangeOfMetadata(db, entry)
+			}
+			go s.store(db, s.getCopyAndClearSpeedAt(end))
+			go s.storeFlows(db, s.Flows.expire(end, false))
+			s.cleanUpOldEntriesAt(end)
+			timer.Reset(time.Until(end.Add(time.Second + intervalGrace)))
+			ticks++
+			if ticks % 60 == 0 {
+				s.reportExcludedBytes(logger)
+				s.reportHealth(capturer, db, logger)
+			}
 		}
 	}
 }
 
+//Gets the amount of bytes that were not counted because of the exclusion rules.
+func (s *Storage) ExcludedBytes() uint64 {
+	return atomic.LoadUint64(&s.excludedBytes)
+}
+
+//Logs the bytes excluded since the last report (if any).
+func (s *Storage) reportExcludedBytes(logger *log.Logger) {
+	excluded := s.ExcludedBytes()
+	if excluded != s.reportedExcludedBytes {
+		logger.Println("Excluded", excluded - s.reportedExcludedBytes, "bytes since the last report,", excluded, "in total")
+		s.reportedExcludedBytes = excluded
+	}
+}
+
+//Logs the statistics of the capture and stores them in the database, if it can store them.
+func (s *Storage) reportHealth(capturer capture.Context, db database.Database, logger *log.Logger) {
+	health, err := capture.GetHealth(capturer)
+	if err != nil {
+		logger.Println("Could not get the statistics of the capture:", err)
+		return
+	}
+	if len(health) == 0 {
+		return
+	}
+
+	for _, h := range health {
+		logger.Println("Capture of", h.Interface + ":", h.Received, "packets received,", h.DroppedByKernel,
+			"dropped by the kernel,", h.DroppedByInterface, "dropped by the interface")
+	}
+
+	if healthDB, ok := db.(database.HealthDatabase); ok {
+		go healthDB.StoreHealth(health)
+	}
+}
+
+//Remembers which interfaces are down, so their devices are not stored as zero usage while there is no capture, and
+//stores the marker in the database, if it can store them.
+func (s *Storage) markGap(gap capture.Gap, db database.Database, logger *log.Logger) {
+	if gap.Down {
+		logger.Println("Capture of", gap.Interface, "is down, there will be no data until it comes back:", gap.Err)
+	} else {
+		logger.Println("Capture of", gap.Interface, "is back")
+	}
+
+	s.mutex.Lock()
+	if s.down == nil {
+		s.down = make(map[string]bool)
+	}
+	if gap.Down {
+		s.down[gap.Interface] = true
+	} else {
+		delete(s.down, gap.Interface)
+	}
+	s.mutex.Unlock()
+
+	if gapDB, ok := db.(database.GapDatabase); ok {
+		go gapDB.StoreGap(gap)
+	}
+}
+
+//Stores the data of the second that ends at the given time synchronously. Used when the time is driven by the packets.
+func (s *Storage) storeInDBAt(db database.Database, now time.Time) {
+	for _, entry := range s.merge(now) {
+		s.storeChangeOfMetadata(db, entry)
+	}
+	s.store(db, s.getCopyAndClearSpeedAt(now))
+	s.storeFlows(db, s.Flows.expire(now, false))
+	s.cleanUpOldEntriesAt(now)
+}
+
+//Stores the entries and, if the database can store it, their breakdown by protocol and remote port.
+func (s *Storage) store(db database.Database, entries []database.Entry) {
+	db.Store(entries)
+	if breakdownDB, ok := db.(database.BreakdownDatabase); ok {
+		breakdownDB.StoreBreakdown(entries)
+	}
+}
+
+//Stores the records of the flows that ended, if the database can store them, with the location of their remote end
+//(usually the destination, but the source when the flow was opened from the Internet) and their application, which is
+//the one of their destination (with the name it had at the end of the flow).
+func (s *Storage) storeFlows(db database.Database, flows []database.FlowRecord) {
+	flowDB, ok := db.(database.FlowDatabase)
+	if !ok || len(flows) == 0 {
+		return
+	}
+
+	for i := range flows {
+		f := &flows[i]
+		dstLocation := s.locationOf(f.DstIp)
+		location := dstLocation
+		if location == (geoip.Location{}) {
+			location = s.locationOf(f.SrcIp)
+		}
+		f.Country, f.Asn, f.AsOrganization = location.Country, location.Asn, location.AsOrganization
+
+		if s.Applications != nil {
+			domain := f.ServerName
+			if domain == "" {
+				domain = s.Domains.nameOfRemote(f.Interface, f.Vlan, f.SrcIp, f.DstIp, f.End)
+			}
+			remote := remoteEnd{ capture.L4ProtocolOf(f.Protocol), f.DstIp, f.DstPort, dstLocation.Asn, domain }
+			application := s.Applications.classify(remote)
+			f.Application, f.Category = application.Name, application.Category
+		}
+	}
+	flowDB.StoreFlows(flows)
+}
+
 func (s *Storage) storeChangeOfMetadata(db database.Database, entry Entry) {
 	db.StoreMetadata(database.Entry(&entry))
 }
 
 //Cleanup: when some entry has not been modified for a while, it will be deleted
 func (s *Storage) cleanUpOldEntries() {
+	s.cleanUpOldEntriesAt(time.Now())
+}
+
+func (s *Storage) cleanUpOldEntriesAt(now time.Time) {
 	s.mutex.Lock()
 	keysToDelete := make([]string, 0)
 	for key, value := range s.db {
-		if value.tooOld() {
+		if value.tooOldAt(now) {
 			keysToDelete = append(keysToDelete, key)
 		}
 	}
 	for _, key := range keysToDelete {
 		delete(s.db, key)
 	}
+	s.udpFlows.expire(now)
+	s.Domains.expire(now)
+	for _, sh := range s.shards {
+		sh.mutex.Lock()
+		for key, value := range sh.entries {
+			if value.tooOldAt(now) {
+				delete(sh.entries, key)
+			}
+		}
+		for key, value := range sh.next {
+			if value.tooOldAt(now) {
+				delete(sh.next, key)
+			}
+		}
+		sh.mutex.Unlock()
+	}
 	s.mutex.Unlock()
 }
 
 func (s *Storage) getCopyAndClearSpeed() []database.Entry {
-	s.mutex.RLock()
+	return s.getCopyAndClearSpeedAt(time.Now())
+}
+
+//Gets a copy of the entries with the traffic of the interval that ends at the given time, which starts at the end of
+//the previous one (or a second before if there is none), and clears them.
+func (s *Storage) getCopyAndClearSpeedAt(now time.Time) []database.Entry {
+	s.mutex.Lock()
+	start := s.intervalEnd
+	if start.IsZero() || !start.Before(now) {
+		start = now.Add(-time.Second)
+	}
+	s.intervalEnd = now
 	newSlice := make([]database.Entry, 0)
 	for key, value := range s.db {
+		//Without capture, there is no data (instead of zero usage)
+		if s.down[value.iface] && value.samples == 0 {
+			continue
+		}
+
 		copiedValue := value
+		copiedValue.intervalStart = start
+		copiedValue.timestamp = now
+		copiedValue.topPorts = value.breakdown.topPorts(s.TopPorts)
+		copiedValue.topDomains = value.breakdown.topDomains(s.TopDomains)
+		copiedValue.topAsns = value.breakdown.topAsns(s.TopAsns)
 		newSlice = append(newSlice, database.Entry(&copiedValue))
 		value.ClearSpeed()
 		s.db[key] = value
 	}
-	s.mutex.RUnlock()
+	s.mutex.Unlock()
 	return newSlice
 }
@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"io/ioutil"
+	"log"
+	"strings"
 	"testing"
 	"time"
 	"github.com/melchor629/speedy/database"
 	"github.com/melchor629/speedy/capture"
+	"github.com/melchor629/speedy/geoip"
 	"net"
 )
 
@@ -143,6 +147,16 @@ func (db *dumbDB) StoreMetadata(entry2 database.Entry) {
 
 func (db *dumbDB) Close() {}
 
+//Sends every store to the channel, so the test can wait for them.
+type channelDB struct {
+	dumbDB
+	stored chan []database.Entry
+}
+
+func (db *channelDB) Store(entries []database.Entry) {
+	db.stored <- entries
+}
+
 func TestStoreInDb(t *testing.T) {
 	s := Storage{
 		db: map[string]Entry{
@@ -160,25 +174,26 @@ func TestStoreInDb(t *testing.T) {
 			},
 		},
 	}
-	db := dumbDB{}
+	db := channelDB{ stored: make(chan []database.Entry, 4) }
 	stop := make(chan bool)
 
-	go s.storeInDB(&db, stop)
-	<- time.NewTimer(1 * time.Second + 500 * time.Millisecond).C
-	stop <- true
-
-	if !db.storeCalled {
+	go s.storeInDB(&dumbCapturer{}, &db, stop)
+	var entries []database.Entry
+	select {
+	case entries = <- db.stored:
+	case <- time.After(1 * time.Second + 500 * time.Millisecond):
 		t.Error("Database was not called")
 		t.FailNow()
 	}
+	stop <- true
 
-	if len(db.entries) != 2 {
+	if len(entries) != 2 {
 		t.Error("Entries seems to not to be correct")
 		t.FailNow()
 	}
 
-	dbMac1 := db.entries[0].Mac().String()
-	dbMac2 := db.entries[1].Mac().String()
+	dbMac1 := entries[0].Mac().String()
+	dbMac2 := entries[1].Mac().String()
 	if dbMac1 != "00:11:22:33:44:55" && dbMac2 != "00:11:22:33:44:55" {
 		t.Error("00:11:22:33:44:55 not found in entries,", dbMac1, dbMac2)
 	}
@@ -199,6 +214,16 @@ func (c *dumbCapturer) Packets() chan *capture.Packet { return c.p }
 func (c *dumbCapturer) StartCapturing() {}
 func (c *dumbCapturer) Close() { close(c.p) }
 
+//Runs Start in a gorutine. The channel receives a value when it returns, so the counters are in the table.
+func startInBackground(s *Storage, c capture.Context, d database.Database) chan bool {
+	done := make(chan bool)
+	go func() {
+		s.Start(c, d)
+		done <- true
+	}()
+	return done
+}
+
 func TestStartNoPackets(t *testing.T) {
 	s := Storage{}
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
@@ -217,7 +242,7 @@ func TestStartPacketNotReversedIPv4(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -227,6 +252,7 @@ func TestStartPacketNotReversedIPv4(t *testing.T) {
 		SrcIp: []byte{ 127, 0, 0, 1 },
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 1 {
 		t.Error("Should be one entry in the in-memory DB")
@@ -261,7 +287,7 @@ func TestStartPacketReversedIPv4(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -271,6 +297,7 @@ func TestStartPacketReversedIPv4(t *testing.T) {
 		DstIp: []byte{ 127, 0, 0, 1 },
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 1 {
 		t.Error("Should be one entry in the in-memory DB")
@@ -305,7 +332,7 @@ func TestStartPacketIPv4Broadcast(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -314,6 +341,7 @@ func TestStartPacketIPv4Broadcast(t *testing.T) {
 		IpType: 4,
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 0 {
 		t.Error("Should not be entries in the in-memory DB")
@@ -325,7 +353,7 @@ func TestStartPacketNotReversedIPv6(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -335,6 +363,7 @@ func TestStartPacketNotReversedIPv6(t *testing.T) {
 		SrcIp: []byte{ 0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01 },
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 1 {
 		t.Error("Should be one entry in the in-memory DB")
@@ -369,7 +398,7 @@ func TestStartPacketReversedIPv6(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -379,6 +408,7 @@ func TestStartPacketReversedIPv6(t *testing.T) {
 		DstIp: []byte{ 0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01 },
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 1 {
 		t.Error("Should be one entry in the in-memory DB")
@@ -413,7 +443,7 @@ func TestStartPacketIPv6Broadcast(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -422,6 +452,7 @@ func TestStartPacketIPv6Broadcast(t *testing.T) {
 		IpType: 4,
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 0 {
 		t.Error("Should not be entries in the in-memory DB")
@@ -433,7 +464,7 @@ func TestStartPacketNotReversedNoNet(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -442,6 +473,7 @@ func TestStartPacketNotReversedNoNet(t *testing.T) {
 		IpType: 0,
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 1 {
 		t.Error("Should be one entry in the in-memory DB")
@@ -476,7 +508,7 @@ func TestStartPacketReversedNoNet(t *testing.T) {
 	c := dumbCapturer{ p: make(chan *capture.Packet) }
 	d := dumbDB{}
 
-	go s.Start(&c, &d)
+	done := startInBackground(&s, &c, &d)
 	c.p <- &capture.Packet{
 		Bytes: 100,
 		DataBytes: 20,
@@ -485,6 +517,7 @@ func TestStartPacketReversedNoNet(t *testing.T) {
 		IpType: 0,
 	}
 	c.Close()
+	<- done
 
 	if len(s.db) != 1 {
 		t.Error("Should be one entry in the in-memory DB")
@@ -513,3 +546,842 @@ func TestStartPacketReversedNoNet(t *testing.T) {
 		t.Error("There should not be IP")
 	}
 }
+
+type dumbOfflineCapturer struct {
+	dumbCapturer
+}
+
+func (c *dumbOfflineCapturer) IsOffline() bool { return true }
+
+type recordingDB struct {
+	dumbDB
+	stored [][]database.Entry
+}
+
+func (db *recordingDB) Store(entry2 []database.Entry) {
+	db.stored = append(db.stored, entry2)
+}
+
+func TestStartOfflineUsesPacketTimestamps(t *testing.T) {
+	s := Storage{}
+	c := dumbOfflineCapturer{ dumbCapturer{ p: make(chan *capture.Packet) } }
+	d := recordingDB{}
+	start := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	for i := 0; i < 3; i++ {
+		c.p <- &capture.Packet{
+			Bytes: 100,
+			SrcMac: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+			DstMac: c.GetMAC(),
+			Timestamp: start.Add(time.Duration(i) * time.Second + 500 * time.Millisecond),
+		}
+	}
+	c.Close()
+	<- done
+
+	if len(d.stored) != 3 {
+		t.Error("Expected 3 stores, one per second, but got", len(d.stored))
+		t.FailNow()
+	}
+
+	for i, entries := range d.stored {
+		if len(entries) != 1 {
+			t.Error("Expected 1 entry in the store", i, "but got", len(entries))
+			continue
+		}
+
+		expected := start.Add(time.Duration(i + 1) * time.Second)
+		if !entries[0].Timestamp().Equal(expected) {
+			t.Error("Store", i, "should have timestamp", expected, "but has", entries[0].Timestamp())
+		}
+		if !entries[0].IntervalStart().Equal(expected.Add(-time.Second)) {
+			t.Error("Store", i, "should start at", expected.Add(-time.Second), "but starts at", entries[0].IntervalStart())
+		}
+		if entries[0].GetUploadSpeed() != 100 {
+			t.Error("Store", i, "should have 100 bytes of upload but has", entries[0].GetUploadSpeed())
+		}
+	}
+}
+
+func TestStartPacketWithInterfaceIsKeyedByInterface(t *testing.T) {
+	s := Storage{}
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	for _, iface := range []string{ "eth0", "eth1" } {
+		c.p <- &capture.Packet{
+			Bytes: 100,
+			SrcMac: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+			DstMac: c.GetMAC(),
+			Interface: iface,
+		}
+	}
+	c.Close()
+	<- done
+
+	if len(s.db) != 2 {
+		t.Error("Should be two entries in the in-memory DB, but there are", len(s.db))
+		t.FailNow()
+	}
+
+	e, ok := s.db["eth1/11:22:33:44:55:66"]
+	if !ok {
+		t.Error("The entry eth1/11:22:33:44:55:66 is not there")
+		t.FailNow()
+	}
+
+	if e.Interface() != "eth1" {
+		t.Error("Interface should be eth1, but is", e.Interface())
+	}
+}
+
+func TestStartExcludedPacketIsNotCounted(t *testing.T) {
+	rules, _ := ParseExclusionRules("", "", "445")
+	s := Storage{ ExclusionRules: rules }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	c.p <- &capture.Packet{
+		Bytes: 100,
+		SrcMac: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		DstMac: c.GetMAC(),
+		SrcPort: 50000,
+		DstPort: 445,
+	}
+	c.Close()
+	<- done
+
+	if len(s.db) != 0 {
+		t.Error("Should not be entries in the in-memory DB")
+	}
+
+	if s.ExcludedBytes() != 100 {
+		t.Error("Excluded bytes should be 100, but are", s.ExcludedBytes())
+	}
+}
+
+func TestStartPacketWithVlanIsKeyedByVlan(t *testing.T) {
+	s := Storage{}
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	c.p <- &capture.Packet{
+		Bytes: 100,
+		SrcMac: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		DstMac: c.GetMAC(),
+		Interface: "eth0",
+		VlanID: 10,
+	}
+	c.Close()
+	<- done
+
+	e, ok := s.db["eth0/vlan10/11:22:33:44:55:66"]
+	if !ok {
+		t.Error("The entry eth0/vlan10/11:22:33:44:55:66 is not there")
+		t.FailNow()
+	}
+
+	if e.Vlan() != 10 {
+		t.Error("VLAN should be 10, but is", e.Vlan())
+	}
+}
+
+type dumbTunnelCapturer struct {
+	dumbCapturer
+}
+
+func (c *dumbTunnelCapturer) GetMAC() net.HardwareAddr { return nil }
+func (c *dumbTunnelCapturer) GetNetworks() []*net.IPNet {
+	_, network, _ := net.ParseCIDR("10.8.0.0/24")
+	return []*net.IPNet{ network }
+}
+
+func TestStartWithoutMacsIsKeyedByIP(t *testing.T) {
+	s := Storage{}
+	c := dumbTunnelCapturer{ dumbCapturer{ p: make(chan *capture.Packet) } }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	c.p <- &capture.Packet{ Bytes: 100, IpType: 4, SrcIp: []byte{ 10, 8, 0, 2 }, DstIp: []byte{ 1, 1, 1, 1 } }
+	c.p <- &capture.Packet{ Bytes: 300, IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 10, 8, 0, 2 } }
+	c.p <- &capture.Packet{ Bytes: 500, IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 8, 8, 8, 8 } }
+	c.Close()
+	<- done
+
+	if len(s.db) != 1 {
+		t.Error("Should be one entry in the in-memory DB, but there are", len(s.db))
+		t.FailNow()
+	}
+
+	e, ok := s.db["ip/10.8.0.2"]
+	if !ok {
+		t.Error("The entry ip/10.8.0.2 is not there")
+		t.FailNow()
+	}
+
+	if e.accumulatedUpload != 100 || e.accumulatedDownload != 300 {
+		t.Error("Upload and download should be 100 and 300, but are", e.accumulatedUpload, e.accumulatedDownload)
+	}
+
+	if e.Mac() != nil || e.ipv4.String() != "10.8.0.2" {
+		t.Error("Entry should not have MAC and should have IPv4 10.8.0.2:", e.Mac(), e.ipv4)
+	}
+}
+
+func TestStartWithClassifierUsesTheIPs(t *testing.T) {
+	classifier, _ := ParseDirectionClassifier("192.168.1.0/24", "")
+	s := Storage{ Classifier: classifier }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	//Another router (not the capture MAC) sends the download
+	c.p <- &capture.Packet{
+		Bytes: 100,
+		SrcMac: []byte{0x00, 0x00, 0x5e, 0x00, 0x01, 0x01},
+		DstMac: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		IpType: 4,
+		SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 192, 168, 1, 10 },
+	}
+	c.Close()
+	<- done
+
+	e, ok := s.db["11:22:33:44:55:66"]
+	if !ok {
+		t.Error("The entry 11:22:33:44:55:66 is not there")
+		t.FailNow()
+	}
+
+	if e.accumulatedDownload != 100 || e.accumulatedUpload != 0 {
+		t.Error("Download and upload should be 100 and 0, but are", e.accumulatedDownload, e.accumulatedUpload)
+	}
+}
+
+func TestStartPacketBetweenLocalDevicesIsLocalForBothEnds(t *testing.T) {
+	s := Storage{}
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	//The laptop copies something to the NAS, the frame does not go through the capture device
+	c.p <- &capture.Packet{
+		Bytes: 100,
+		SrcMac: []byte{0x12, 0x22, 0x33, 0x44, 0x55, 0x66},
+		DstMac: []byte{0x12, 0x22, 0x33, 0x44, 0x55, 0x77},
+		IpType: 4,
+		SrcIp: []byte{ 192, 168, 1, 10 },
+		DstIp: []byte{ 192, 168, 1, 20 },
+	}
+	c.Close()
+	<- done
+
+	e, ok := s.db["12:22:33:44:55:66"]
+	if !ok {
+		t.Error("The entry 12:22:33:44:55:66 is not there")
+		t.FailNow()
+	}
+
+	if e.accumulatedUpload != 0 || e.accumulatedDownload != 0 {
+		t.Error("Upload and download should be 0, but are", e.accumulatedUpload, e.accumulatedDownload)
+	}
+
+	if e.accumulatedLocalUpload != 100 || e.accumulatedLocalDownload != 0 {
+		t.Error("Local upload and download should be 100 and 0, but are", e.accumulatedLocalUpload, e.accumulatedLocalDownload)
+	}
+
+	nas := s.db["12:22:33:44:55:77"]
+	if nas.accumulatedLocalDownload != 100 || nas.accumulatedLocalUpload != 0 || nas.accumulatedDownload != 0 {
+		t.Error("NAS local download, local upload and download should be 100, 0 and 0, but are",
+			nas.accumulatedLocalDownload, nas.accumulatedLocalUpload, nas.accumulatedDownload)
+	}
+}
+
+func TestStartWithLocalNetworksCreditsTheReceiverOfTheLocalTraffic(t *testing.T) {
+	classifier, _ := ParseDirectionClassifier("192.168.1.0/24", "00:00:5e:00:01:01")
+	s := Storage{ Classifier: classifier }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	laptop := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+	nas := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x77 }
+
+	done := startInBackground(&s, &c, &d)
+	//The laptop copies something to the NAS
+	c.p <- &capture.Packet{ Bytes: 1000, SrcMac: laptop, DstMac: nas, IpType: 4, SrcIp: []byte{ 192, 168, 1, 10 },
+		DstIp: []byte{ 192, 168, 1, 20 } }
+	//The laptop asks the router (its LAN address is local too), which is not a device
+	c.p <- &capture.Packet{ Bytes: 100, SrcMac: laptop, DstMac: []byte{ 0x00, 0x00, 0x5e, 0x00, 0x01, 0x01 }, IpType: 4,
+		SrcIp: []byte{ 192, 168, 1, 10 }, DstIp: []byte{ 192, 168, 1, 1 } }
+	c.Close()
+	<- done
+
+	if len(s.db) != 2 {
+		t.Fatal("There should be the laptop and the NAS, but there are", len(s.db), "entries")
+	}
+	e := s.db["12:22:33:44:55:77"]
+	if e.GetLocalDownloadSpeed() != 1000 || e.GetLocalUploadSpeed() != 0 || e.GetDownloadSpeed() != 0 {
+		t.Error("NAS local download, local upload and download should be 1000, 0 and 0, but are",
+			e.GetLocalDownloadSpeed(), e.GetLocalUploadSpeed(), e.GetDownloadSpeed())
+	}
+	e = s.db["12:22:33:44:55:66"]
+	if e.GetLocalUploadSpeed() != 1100 || e.GetLocalDownloadSpeed() != 0 {
+		t.Error("Laptop local upload and download should be 1100 and 0, but are", e.GetLocalUploadSpeed(), e.GetLocalDownloadSpeed())
+	}
+}
+
+func TestStartWithoutMacsBetweenLocalIPsIsLocal(t *testing.T) {
+	s := Storage{}
+	c := dumbTunnelCapturer{ dumbCapturer{ p: make(chan *capture.Packet) } }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	c.p <- &capture.Packet{
+		Bytes: 100,
+		IpType: 4,
+		SrcIp: []byte{ 10, 8, 0, 2 },
+		DstIp: []byte{ 10, 8, 0, 3 },
+	}
+	c.Close()
+	<- done
+
+	e, ok := s.db["ip/10.8.0.2"]
+	if !ok {
+		t.Error("The entry ip/10.8.0.2 is not there")
+		t.FailNow()
+	}
+
+	if e.accumulatedLocalUpload != 100 || e.accumulatedUpload != 0 {
+		t.Error("Local upload and upload should be 100 and 0, but are", e.accumulatedLocalUpload, e.accumulatedUpload)
+	}
+	if e := s.db["ip/10.8.0.3"]; e.accumulatedLocalDownload != 100 {
+		t.Error("The receiver should have 100 bytes of local download, but there are", e.accumulatedLocalDownload)
+	}
+}
+
+func TestStartInMirrorModeCreditsBothEnds(t *testing.T) {
+	mirror, _ := ParseMirrorClassifier("", "00:00:5e:00:01:01")
+	s := Storage{ Mirror: mirror }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	//Laptop to NAS
+	c.p <- &capture.Packet{
+		Bytes: 100,
+		SrcMac: []byte{0x12, 0x22, 0x33, 0x44, 0x55, 0x66},
+		DstMac: []byte{0x12, 0x22, 0x33, 0x44, 0x55, 0x77},
+	}
+	//Internet to laptop
+	c.p <- &capture.Packet{
+		Bytes: 300,
+		SrcMac: []byte{0x00, 0x00, 0x5e, 0x00, 0x01, 0x01},
+		DstMac: []byte{0x12, 0x22, 0x33, 0x44, 0x55, 0x66},
+	}
+	c.Close()
+	<- done
+
+	if len(s.db) != 2 {
+		t.Error("Should be two entries in the in-memory DB, but there are", len(s.db))
+		t.FailNow()
+	}
+
+	laptop := s.db["12:22:33:44:55:66"]
+	if laptop.accumulatedLocalUpload != 100 || laptop.accumulatedDownload != 300 || laptop.accumulatedUpload != 0 {
+		t.Error("Laptop local upload, download and upload should be 100, 300 and 0, but are",
+			laptop.accumulatedLocalUpload, laptop.accumulatedDownload, laptop.accumulatedUpload)
+	}
+
+	nas := s.db["12:22:33:44:55:77"]
+	if nas.accumulatedLocalDownload != 100 || nas.accumulatedDownload != 0 {
+		t.Error("NAS local download and download should be 100 and 0, but are", nas.accumulatedLocalDownload, nas.accumulatedDownload)
+	}
+}
+
+type dumbHealthCapturer struct {
+	dumbCapturer
+}
+
+func (c *dumbHealthCapturer) Health() ([]capture.Health, error) {
+	return []capture.Health{{ Interface: "eth0", Received: 1000, DroppedByKernel: 10 }}, nil
+}
+
+type healthDB struct {
+	dumbDB
+	health chan []capture.Health
+}
+
+func (db *healthDB) StoreHealth(health []capture.Health) {
+	db.health <- health
+}
+
+func TestReportHealthStoresTheStatistics(t *testing.T) {
+	s := Storage{}
+	c := dumbHealthCapturer{}
+	db := healthDB{ health: make(chan []capture.Health, 1) }
+
+	s.reportHealth(&c, &db, log.New(ioutil.Discard, "", 0))
+
+	select {
+	case health := <- db.health:
+		if len(health) != 1 || health[0].Interface != "eth0" || health[0].DroppedByKernel != 10 {
+			t.Error("The statistics are not the expected ones:", health)
+		}
+	case <- time.After(time.Second):
+		t.Error("The statistics were not stored")
+	}
+}
+
+func TestStartScalesSampledPackets(t *testing.T) {
+	s := Storage{}
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	for i := 0; i < 4; i++ {
+		c.p <- &capture.Packet{
+			Bytes: 100,
+			SrcMac: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+			DstMac: c.GetMAC(),
+			SamplingRate: 10,
+		}
+	}
+	c.Close()
+	<- done
+
+	e := s.db["11:22:33:44:55:66"]
+	if e.accumulatedUpload != 4000 {
+		t.Error("Accumulated upload should be 4000, but is", e.accumulatedUpload)
+	}
+
+	if e.SamplingRate() != 10 {
+		t.Error("Sampling rate should be 10, but is", e.SamplingRate())
+	}
+
+	//1.96 * sqrt(1 / 4)
+	if e.SamplingError() != 0.98 {
+		t.Error("Sampling error should be 0.98, but is", e.SamplingError())
+	}
+}
+
+type recordingObserver struct {
+	packets []capture.Packet
+}
+
+func (o *recordingObserver) Observe(packet *capture.Packet) {
+	o.packets = append(o.packets, *packet)
+}
+
+func TestStartObserverSeesThePacketsAsCaptured(t *testing.T) {
+	o := recordingObserver{}
+	s := Storage{ Observer: &o }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	done := make(chan bool)
+
+	go func() {
+		s.Start(&c, &d)
+		done <- true
+	}()
+	c.p <- &capture.Packet{
+		Bytes: 100,
+		SrcMac: c.GetMAC(),
+		DstMac: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+	}
+	c.Close()
+	<- done
+
+	if len(o.packets) != 1 {
+		t.Fatal("The observer should have seen one packet, but saw", len(o.packets))
+	}
+	if o.packets[0].IsReversed(c.GetMAC()) == false {
+		t.Error("The observer should see the packet before being reversed")
+	}
+}
+
+type gapDB struct {
+	dumbDB
+	gaps chan capture.Gap
+}
+
+func (db *gapDB) StoreGap(gap capture.Gap) {
+	db.gaps <- gap
+}
+
+func TestMarkGapStoresTheMarker(t *testing.T) {
+	s := Storage{}
+	db := gapDB{ gaps: make(chan capture.Gap, 1) }
+
+	s.markGap(capture.Gap{ Interface: "eth0", Down: true }, &db, log.New(ioutil.Discard, "", 0))
+
+	select {
+	case gap := <- db.gaps:
+		if gap.Interface != "eth0" || !gap.Down {
+			t.Error("The marker is not the expected one:", gap)
+		}
+	case <- time.After(time.Second):
+		t.Error("The marker was not stored")
+	}
+}
+
+func TestDevicesOfAnInterfaceThatIsDownAreNotStored(t *testing.T) {
+	s := Storage{
+		db: map[string]Entry{
+			"eth0/11:22:33:44:55:66": { iface: "eth0", mac: []byte{ 0x11, 0x22, 0x33, 0x44, 0x55, 0x66 } },
+			"eth1/aa:bb:cc:dd:ee:ff": { iface: "eth1", mac: []byte{ 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff } },
+		},
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+
+	s.markGap(capture.Gap{ Interface: "eth0", Down: true }, &dumbDB{}, logger)
+	l := s.getCopyAndClearSpeed()
+	if len(l) != 1 || l[0].Interface() != "eth1" {
+		t.Error("Only the devices of eth1 should be stored, but were", l)
+	}
+
+	s.markGap(capture.Gap{ Interface: "eth0" }, &dumbDB{}, logger)
+	if l := s.getCopyAndClearSpeed(); len(l) != 2 {
+		t.Error("The devices of eth0 should be stored again, but were", l)
+	}
+}
+
+func TestPacketsCapturedAfterTheEndOfTheIntervalAreCountedInTheNextOne(t *testing.T) {
+	end := time.Date(2019, 1, 1, 12, 0, 1, 0, time.UTC)
+	s := Storage{ db: make(map[string]Entry), shards: []*shard{ newShard(end) } }
+	packet := &capture.Packet{ Bytes: 100, SrcMac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 } }
+
+	s.addTraffic(s.shards[0], packet, false, false, false, 0, nil, end.Add(-time.Millisecond))
+	s.addTraffic(s.shards[0], packet, false, false, false, 0, nil, end.Add(time.Millisecond))
+	s.merge(end)
+	first := s.getCopyAndClearSpeedAt(end)
+	s.merge(end.Add(time.Second))
+	second := s.getCopyAndClearSpeedAt(end.Add(time.Second))
+
+	if len(first) != 1 || first[0].GetUploadSpeed() != 100 {
+		t.Error("The interval that ends at", end, "should have 100 bytes:", first)
+	}
+	if len(second) != 1 || second[0].GetUploadSpeed() != 100 {
+		t.Error("The next interval should have 100 bytes:", second)
+	}
+}
+
+func TestIntervalStartsAtTheEndOfThePreviousOne(t *testing.T) {
+	s := Storage{ db: map[string]Entry{ "12:22:33:44:55:66": { mac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 } } } }
+	end := time.Date(2019, 1, 1, 12, 0, 1, 0, time.UTC)
+
+	first := s.getCopyAndClearSpeedAt(end)
+	//The store was late, so the interval is longer
+	second := s.getCopyAndClearSpeedAt(end.Add(3 * time.Second))
+
+	if !first[0].IntervalStart().Equal(end.Add(-time.Second)) || !first[0].Timestamp().Equal(end) {
+		t.Error("The first interval is not the expected one:", first[0].IntervalStart(), first[0].Timestamp())
+	}
+	if !second[0].IntervalStart().Equal(end) || !second[0].Timestamp().Equal(end.Add(3 * time.Second)) {
+		t.Error("The second interval is not the expected one:", second[0].IntervalStart(), second[0].Timestamp())
+	}
+}
+
+func TestStoreInDBAlignsTheIntervalsToTheSeconds(t *testing.T) {
+	s := Storage{ db: map[string]Entry{ "12:22:33:44:55:66": { mac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }, lastModified: time.Now() } } }
+	db := channelDB{ stored: make(chan []database.Entry, 4) }
+	stop := make(chan bool)
+
+	go s.storeInDB(&dumbCapturer{}, &db, stop)
+	var entries []database.Entry
+	select {
+	case entries = <- db.stored:
+	case <- time.After(5 * time.Second):
+		t.Fatal("The entries were not stored")
+	}
+	stop <- true
+
+	if entries[0].Timestamp().Nanosecond() != 0 || entries[0].IntervalStart().Nanosecond() != 0 {
+		t.Error("The interval is not aligned to the seconds:", entries[0].IntervalStart(), entries[0].Timestamp())
+	}
+}
+
+func TestStartCountsThePacketsAndTheNewFlows(t *testing.T) {
+	s := Storage{}
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	device := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+
+	done := startInBackground(&s, &c, &d)
+	//A sampled SYN, its SYN-ACK and two packets of a DNS query and its answer
+	c.p <- &capture.Packet{ Bytes: 60, SrcMac: device, DstMac: c.GetMAC(), IpType: 4, SrcIp: []byte{ 10, 0, 0, 2 },
+		DstIp: []byte{ 1, 1, 1, 1 }, Protocol: 6, SrcPort: 50000, DstPort: 443, TcpFlags: capture.TcpSyn, SamplingRate: 2 }
+	c.p <- &capture.Packet{ Bytes: 60, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 6, SrcPort: 443, DstPort: 50000, TcpFlags: capture.TcpSyn | capture.TcpAck }
+	c.p <- &capture.Packet{ Bytes: 60, SrcMac: device, DstMac: c.GetMAC(), IpType: 4, SrcIp: []byte{ 10, 0, 0, 2 },
+		DstIp: []byte{ 1, 1, 1, 1 }, Protocol: 17, SrcPort: 40000, DstPort: 53 }
+	c.p <- &capture.Packet{ Bytes: 100, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 17, SrcPort: 53, DstPort: 40000 }
+	c.Close()
+	<- done
+
+	e := s.db["12:22:33:44:55:66"]
+	if e.GetUploadPackets() != 3 || e.GetDownloadPackets() != 2 {
+		t.Error("There should be 3 packets up and 2 down, but there are", e.GetUploadPackets(), e.GetDownloadPackets())
+	}
+	//The SYN is scaled, the SYN-ACK and the answer to the query are not new
+	if e.GetNewFlows() != 3 {
+		t.Error("There should be 3 new flows, but there are", e.GetNewFlows())
+	}
+}
+
+func TestStartCountsThePacketsOfTheFlowRecords(t *testing.T) {
+	s := Storage{}
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+
+	done := startInBackground(&s, &c, &d)
+	c.p <- &capture.Packet{
+		Bytes: 15000,
+		Packets: 10,
+		SrcMac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 },
+		DstMac: c.GetMAC(),
+		SamplingRate: 4,
+	}
+	c.Close()
+	<- done
+
+	if e := s.db["12:22:33:44:55:66"]; e.GetUploadPackets() != 40 {
+		t.Error("There should be 40 packets up, but there are", e.GetUploadPackets())
+	}
+}
+
+type breakdownDB struct {
+	dumbDB
+	breakdown []database.Entry
+}
+
+func (db *breakdownDB) StoreBreakdown(entries []database.Entry) {
+	db.breakdown = entries
+}
+
+func TestStoreInDBAtStoresTheBreakdown(t *testing.T) {
+	s := Storage{ TopPorts: 1, db: make(map[string]Entry), shards: []*shard{ newShard(time.Time{}) } }
+	d := breakdownDB{}
+	now := time.Now()
+	device := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+
+	s.addTraffic(s.shards[0], &capture.Packet{ Bytes: 100, SrcMac: device, Protocol: 6, DstPort: 445 }, false, false, true, 0, nil, now)
+	s.addTraffic(s.shards[0], &capture.Packet{ Bytes: 50, SrcMac: device, Protocol: 17, DstPort: 53 }, false, false, false, 0, nil, now)
+	s.storeInDBAt(&d, now)
+
+	if len(d.breakdown) != 1 {
+		t.Fatal("The breakdown of one device should be stored, but there are", len(d.breakdown))
+	}
+	if protocols := d.breakdown[0].GetProtocols(); len(protocols) != 2 {
+		t.Error("There should be 2 protocols, but there are", protocols)
+	}
+	if ports := d.breakdown[0].GetTopPorts(); len(ports) != 1 || ports[0].Port != 445 || ports[0].Upload != 100 {
+		t.Error("The top port should be TCP 445 with 100 bytes up, but is", ports)
+	}
+	if e := s.db["12:22:33:44:55:66"]; len(e.GetProtocols()) != 0 || e.breakdown.ports != nil {
+		t.Error("The breakdown should be cleared after being stored")
+	}
+}
+
+type flowDB struct {
+	dumbDB
+	flows []database.FlowRecord
+}
+
+func (db *flowDB) StoreFlows(flows []database.FlowRecord) {
+	db.flows = append(db.flows, flows...)
+}
+
+func TestStartOfflineStoresTheFlowsAtTheEnd(t *testing.T) {
+	s := Storage{ Flows: NewFlowTable(15 * time.Second, time.Minute) }
+	c := dumbOfflineCapturer{ dumbCapturer{ p: make(chan *capture.Packet, 2) } }
+	d := flowDB{}
+	ts := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	c.p <- &capture.Packet{ Bytes: 100, SrcMac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }, DstMac: c.GetMAC(), IpType: 4,
+		SrcIp: []byte{ 10, 0, 0, 2 }, DstIp: []byte{ 1, 1, 1, 1 }, Protocol: 17, SrcPort: 40000, DstPort: 53, Timestamp: ts }
+	c.p <- &capture.Packet{ Bytes: 200, SrcMac: c.GetMAC(), DstMac: []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }, IpType: 4,
+		SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 17, SrcPort: 53, DstPort: 40000, Timestamp: ts }
+	c.Close()
+	s.Start(&c, &d)
+
+	if len(d.flows) != 1 || d.flows[0].Reason != database.FlowEndShutdown {
+		t.Fatal("There should be the record of the flow, but there are", d.flows)
+	}
+	if d.flows[0].Bytes != 100 || d.flows[0].ReplyBytes != 200 || d.flows[0].DstPort != 53 {
+		t.Error("The record is not the expected one:", d.flows[0])
+	}
+}
+
+func TestStartCountsTheTrafficOfTheDomains(t *testing.T) {
+	s := Storage{ TopDomains: 5, Domains: NewDomainCache() }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := dumbDB{}
+	device := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+
+	done := startInBackground(&s, &c, &d)
+	c.p <- &capture.Packet{ Bytes: 100, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 17, SrcPort: 53, DstPort: 40000,
+		DnsAnswers: []capture.DnsAnswer{ { Name: "www.netflix.com", Ip: []byte{ 1, 2, 3, 4 }, Ttl: 60 } } }
+	c.p <- &capture.Packet{ Bytes: 1500, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 1, 2, 3, 4 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 6, SrcPort: 443, DstPort: 50000 }
+	c.Close()
+	<- done
+
+	e := s.db["12:22:33:44:55:66"]
+	domains := e.breakdown.topDomains(s.TopDomains)
+	if len(domains) != 1 || domains[0].Domain != "www.netflix.com" || domains[0].Download != 1500 {
+		t.Error("There should be 1500 bytes from www.netflix.com, but there are", domains)
+	}
+}
+
+type mapLocator map[string]geoip.Location
+
+func (l mapLocator) Lookup(ip net.IP) geoip.Location {
+	return l[ip.String()]
+}
+
+func TestStartCountsTheTrafficOfTheCountriesAndTheAsns(t *testing.T) {
+	netflix := geoip.Location{ Country: "US", Asn: 2906, AsOrganization: "NETFLIX-ASN" }
+	s := Storage{ TopAsns: 5, Flows: NewFlowTable(15 * time.Second, time.Minute), Locator: mapLocator{ "45.57.1.1": netflix } }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := flowDB{}
+	device := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+
+	done := startInBackground(&s, &c, &d)
+	c.p <- &capture.Packet{ Bytes: 1500, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 45, 57, 1, 1 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 6, SrcPort: 443, DstPort: 50000, TcpFlags: capture.TcpSyn | capture.TcpAck }
+	c.p <- &capture.Packet{ Bytes: 500, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 17, SrcPort: 53, DstPort: 40000 }
+	c.Close()
+	<- done
+
+	e := s.db["12:22:33:44:55:66"]
+	if countries := e.GetCountries(); len(countries) != 1 || countries[0].Country != "US" || countries[0].Download != 1500 {
+		t.Error("There should be 1500 bytes from US, but there are", countries)
+	}
+	asns := e.breakdown.topAsns(s.TopAsns)
+	if len(asns) != 1 || asns[0].Asn != 2906 || asns[0].AsOrganization != "NETFLIX-ASN" || asns[0].Download != 1500 {
+		t.Error("There should be 1500 bytes from AS2906, but there are", asns)
+	}
+
+	//The flow was opened by the device, so the remote end is the destination of the record
+	if len(d.flows) != 2 {
+		t.Fatal("There should be the records of 2 flows, but there are", d.flows)
+	}
+	for _, flow := range d.flows {
+		if flow.Protocol == 6 && (flow.Country != "US" || flow.Asn != 2906 || !flow.DstIp.Equal(net.IPv4(45, 57, 1, 1))) {
+			t.Error("The record of the TCP flow should be located in AS2906, but it is", flow)
+		}
+		if flow.Protocol == 17 && (flow.Country != "" || flow.Asn != 0) {
+			t.Error("The record of the UDP flow should not be located, but it is", flow)
+		}
+	}
+}
+
+func TestStoreFlowsUsesTheNamesOfTheEndOfTheFlows(t *testing.T) {
+	rules, _ := ParseApplicationRules(strings.NewReader("netflix video-streaming domain=netflix.com\n"))
+	s := Storage{ Applications: rules, Domains: NewDomainCache() }
+	d := flowDB{}
+	//A replayed capture, whose names expired long before now
+	end := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.Domains.learn(&capture.Packet{ IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 }, DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 17,
+		SrcPort: 53, DnsAnswers: []capture.DnsAnswer{ { Name: "www.netflix.com", Ip: []byte{ 1, 2, 3, 4 }, Ttl: 60 } } }, end)
+
+	s.storeFlows(&d, []database.FlowRecord{ { Protocol: 6, SrcIp: []byte{ 10, 0, 0, 2 }, SrcPort: 50000,
+		DstIp: []byte{ 1, 2, 3, 4 }, DstPort: 443, Start: end.Add(-time.Minute), End: end.Add(time.Second) } })
+	if len(d.flows) != 1 || d.flows[0].Application != "netflix" {
+		t.Error("The flow should be of netflix, but it is", d.flows)
+	}
+}
+
+func TestStartCountsTheTrafficOfTheCategories(t *testing.T) {
+	rules, err := ParseApplicationRules(strings.NewReader("netflix video-streaming domain=netflix.com\ndns network port=53\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Storage{ Applications: rules, Domains: NewDomainCache(), Flows: NewFlowTable(15 * time.Second, time.Minute) }
+	c := dumbCapturer{ p: make(chan *capture.Packet) }
+	d := flowDB{}
+	device := []byte{ 0x12, 0x22, 0x33, 0x44, 0x55, 0x66 }
+
+	done := startInBackground(&s, &c, &d)
+	c.p <- &capture.Packet{ Bytes: 100, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 1, 1, 1, 1 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 17, SrcPort: 53, DstPort: 40000,
+		DnsAnswers: []capture.DnsAnswer{ { Name: "www.netflix.com", Ip: []byte{ 1, 2, 3, 4 }, Ttl: 60 } } }
+	c.p <- &capture.Packet{ Bytes: 1500, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 1, 2, 3, 4 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 6, SrcPort: 443, DstPort: 50000, TcpFlags: capture.TcpSyn | capture.TcpAck }
+	c.p <- &capture.Packet{ Bytes: 700, SrcMac: c.GetMAC(), DstMac: device, IpType: 4, SrcIp: []byte{ 5, 6, 7, 8 },
+		DstIp: []byte{ 10, 0, 0, 2 }, Protocol: 6, SrcPort: 80, DstPort: 50001 }
+	c.Close()
+	<- done
+
+	e := s.db["12:22:33:44:55:66"]
+	categories := e.GetCategories()
+	if len(categories) != 2 || categories[0].Category != "video-streaming" || categories[0].Download != 1500 {
+		t.Fatal("There should be 1500 bytes of video-streaming and then network, but there are", categories)
+	}
+	if categories[1].Category != "network" || categories[1].Download != 100 {
+		t.Error("There should be 100 bytes of network, but there are", categories[1])
+	}
+
+	//The application of the records is the one of their destination, with the names that the device resolved
+	if len(d.flows) != 3 {
+		t.Fatal("There should be the records of 3 flows, but there are", d.flows)
+	}
+	for _, flow := range d.flows {
+		var expected string
+		switch flow.DstPort {
+		case 443:
+			expected = "netflix"
+		case 53:
+			expected = "dns"
+		}
+		if flow.Application != expected {
+			t.Errorf("The application of the flow to the port %d should be %q, but it is %q", flow.DstPort, expected, flow.Application)
+		}
+	}
+}